@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"sync"
@@ -18,12 +19,71 @@ type Config struct {
 	QuadtreeMaxObj int     `json:"quadtree_max_obj"`
 	QuadtreeMaxLvl int     `json:"quadtree_max_lvl"`
 	UpdateRateMs   int     `json:"update_rate_ms"`
+	// MaxSpeed is the top boid speed per axis in world units per second (default 100)
+	MaxSpeed float64 `json:"max_speed,omitempty"`
+	// MaxCatchUpTicks caps how many steps are simulated at once when the loop falls behind;
+	// the rest of the backlog is dropped (default 5)
+	MaxCatchUpTicks int `json:"max_catch_up_ticks,omitempty"`
+	// QuadtreeAutoTune lets the simulation adjust QuadtreeMaxObj and QuadtreeMaxLvl at run time,
+	// starting from the configured values. It is ignored with LongRangeWeight, which is
+	// approximated from the tree.
+	QuadtreeAutoTune bool `json:"quadtree_auto_tune,omitempty"`
+	// NeighborMode selects how flockmates are chosen: "metric" (all within view_radius) or
+	// "topological" (the NeighborK nearest regardless of distance). Empty means metric.
+	NeighborMode string `json:"neighbor_mode,omitempty"`
+	NeighborK    int    `json:"neighbor_k,omitempty"`
+	// NeighborSkin enables Verlet neighbor lists in metric mode: candidates within
+	// view_radius + skin are cached and re-queried only once some boid moved more than skin/2.
+	// 0 disables the cache.
+	NeighborSkin float64 `json:"neighbor_skin,omitempty"`
+	// LongRangeWeight scales an approximate Barnes–Hut pull toward boids of the same species beyond
+	// view_radius, letting separate flocks find each other; negative values repel. 0 disables it.
+	// LongRangeTheta is the opening angle (default 0.5): larger is faster but coarser.
+	LongRangeWeight float64 `json:"long_range_weight,omitempty"`
+	LongRangeTheta  float64 `json:"long_range_theta,omitempty"`
+	// SpatialIndex selects the neighbor search structure: "quadtree" (default), "grid" or "hash"
+	SpatialIndex string `json:"spatial_index,omitempty"`
+	// ReorderTicks is how often, in ticks, boids are re-sorted in memory by spatial cell so that
+	// flockmates are stored close together (default DefaultReorderTicks). 0 disables reordering.
+	ReorderTicks int `json:"reorder_ticks"`
+	// Obstacles are solid rectangles in world coordinates
+	Obstacles []Obstacle `json:"obstacles,omitempty"`
+	// LookAhead is how far ahead along its velocity a boid probes for obstacles and starts
+	// steering away from them. 0 disables obstacle avoidance.
+	LookAhead float64 `json:"look_ahead,omitempty"`
+	// LineOfSight hides flockmates whose line of sight crosses an obstacle.
+	LineOfSight bool `json:"line_of_sight,omitempty"`
+	// MinFlockSize is the smallest connected group of flockmates counted and tracked as a flock
+	// (default and minimum 2)
+	MinFlockSize int `json:"min_flock_size,omitempty"`
+	// RewindSeconds is how much recent simulated time is kept in memory for rewinding.
+	// 0 disables rewinding.
+	RewindSeconds float64 `json:"rewind_seconds,omitempty"`
+	// RewindMaxMB caps the memory the rewind history may take (default 256)
+	RewindMaxMB int `json:"rewind_max_mb,omitempty"`
 	// Seed enables deterministic runs; if 0, a random seed is used.
 	Seed int64 `json:"seed,omitempty"`
 }
 
+// Obstacle is an axis-aligned rectangle boids cannot see or fly through
+type Obstacle struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
 const (
 	cfgPath = "config.json"
+
+	// DefaultReorderTicks is the reordering interval of config files that do not set reorder_ticks
+	DefaultReorderTicks = 50
+)
+
+// Neighbor selection modes
+const (
+	NeighborModeMetric      = "metric"
+	NeighborModeTopological = "topological"
 )
 
 var (
@@ -35,12 +95,33 @@ var (
 func GetConfig() *Config {
 	once.Do(func() {
 		// load config from file
-		instance = &Config{}
 		data, err := os.ReadFile(cfgPath)
 		if err != nil {
 			log.Fatal(err)
 		}
-		_ = json.Unmarshal(data, instance)
+		if instance, err = Parse(data); err != nil {
+			log.Fatal(err)
+		}
 	})
 	return instance
 }
+
+// Parse decodes and validates a config file; settings it leaves out keep their defaults
+func Parse(data []byte) (*Config, error) {
+	c := &Config{ReorderTicks: DefaultReorderTicks}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports settings the simulation cannot run with
+func (c *Config) Validate() error {
+	if c.NeighborMode == NeighborModeTopological && c.NeighborK <= 0 {
+		return fmt.Errorf("neighbor_k must be positive in %s mode, got %d", NeighborModeTopological, c.NeighborK)
+	}
+	return nil
+}
@@ -164,3 +164,44 @@ func TestConfigStruct(t *testing.T) {
 		}
 	})
 }
+
+func TestValidate(t *testing.T) {
+	cfg := config.Config{NeighborMode: config.NeighborModeTopological, NeighborK: 7}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+	for _, k := range []int{0, -1} {
+		cfg.NeighborK = k
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("neighbor_k %d accepted in topological mode", k)
+		}
+	}
+	// metric mode does not use neighbor_k
+	cfg.NeighborMode = config.NeighborModeMetric
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("metric mode with neighbor_k 0 rejected: %v", err)
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	cfg, err := config.Parse([]byte(`{"width": 800}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ReorderTicks != config.DefaultReorderTicks {
+		t.Errorf("reorder_ticks left out: got %d, want %d", cfg.ReorderTicks, config.DefaultReorderTicks)
+	}
+	if cfg, err = config.Parse([]byte(`{"reorder_ticks": 0}`)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ReorderTicks != 0 {
+		t.Errorf("reorder_ticks 0: got %d, want reordering off", cfg.ReorderTicks)
+	}
+
+	if _, err = config.Parse([]byte(`{"width": "wide"}`)); err == nil {
+		t.Error("malformed config accepted")
+	}
+	if _, err = config.Parse([]byte(`{"neighbor_mode": "topological"}`)); err == nil {
+		t.Error("invalid config accepted")
+	}
+}
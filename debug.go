@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/vector"
+	"github.com/gopxl/pixel/v2"
+	"github.com/gopxl/pixel/v2/ext/imdraw"
+	"golang.org/x/image/colornames"
+)
+
+// drawQuadTree outlines the leaves of the quadtree; qt may be nil when another index is in use
+func drawQuadTree(imd *imdraw.IMDraw, qt *quadtree.QuadTree) {
+	if qt == nil {
+		return
+	}
+	imd.Color = colornames.Darkslategray
+	qt.Walk(func(node *quadtree.QuadTree) bool {
+		if node.IsLeaf() {
+			b := node.Bounds()
+			imd.Push(pixel.V(b.X, b.Y), pixel.V(b.X+b.Width, b.Y+b.Height))
+			imd.Rectangle(1)
+		}
+		return true
+	})
+}
+
+// rebuildQuadTree builds the quadtree the simulation would use for the given positions, for
+// states that do not come with their index
+func rebuildQuadTree(cfg *config.Config, x, y []float64) *quadtree.QuadTree {
+	bounds := quadtree.Bounds{X: 0, Y: 0, Width: float64(cfg.Width), Height: float64(cfg.Height)}
+	objects := make([]*quadtree.Object, len(x))
+	for i := range objects {
+		objects[i] = &quadtree.Object{ID: int64(i), Position: vector.V(x[i], y[i])}
+	}
+	return quadtree.Build(bounds, cfg.QuadtreeMaxObj, cfg.QuadtreeMaxLvl, objects)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/sim"
+)
+
+// exportFlags choose where and how the metrics time series is written
+type exportFlags struct {
+	out    *string
+	every  *int64
+	format *string
+	fields *string
+}
+
+// toStdout reports whether the metrics go to standard output
+func (x exportFlags) toStdout() bool {
+	return *x.out == "-"
+}
+
+// start begins exporting metrics from the world if an output is set; the returned function
+// ends the export
+func (x exportFlags) start(world *sim.World) (func() error, error) {
+	if *x.out == "" {
+		return func() error { return nil }, nil
+	}
+	format := metrics.Format(strings.ToLower(*x.format))
+	if format == "" {
+		format = metrics.FormatCSV
+		if ext := strings.ToLower(filepath.Ext(*x.out)); ext == ".ndjson" || ext == ".jsonl" {
+			format = metrics.FormatNDJSON
+		}
+	}
+	var fields []string
+	if *x.fields != "" {
+		fields = strings.Split(*x.fields, ",")
+	}
+
+	// rows go out line by line to standard output and pipes, where a reader may be following
+	// them, and are buffered on their way to files
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if !x.toStdout() {
+		f, err := os.Create(*x.out)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			out = &bufferedFile{Writer: bufio.NewWriter(f), f: f}
+		}
+	}
+	e, err := metrics.NewExporter(out, format, fields, len(sim.SpeciesColors))
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	world.ExportMetrics(e, *x.every)
+	return func() error {
+		world.StopMetricsExport()
+		return out.Close()
+	}, nil
+}
+
+// addExportFlags defines the metrics export flags
+func addExportFlags(fs *flag.FlagSet) exportFlags {
+	return exportFlags{
+		out:   fs.String("metrics-out", "", "write a time series of metrics to FILE, - for standard output"),
+		every: fs.Int64("metrics-every", 1, "ticks between exported metrics rows"),
+		format: fs.String("metrics-format", "",
+			"metrics file format, csv or ndjson; by default ndjson for .ndjson and .jsonl files, csv otherwise"),
+		fields: fs.String("metrics-fields", strings.Join(metrics.DefaultFields, ","),
+			fmt.Sprintf("comma-separated metrics to export, from %s; append _N for species N only",
+				strings.Join(metrics.FieldNames(), ", "))),
+	}
+}
+
+// bufferedFile is a file written through a buffer, flushed when it is closed
+type bufferedFile struct {
+	*bufio.Writer
+	f *os.File
+}
+
+func (b *bufferedFile) Close() error {
+	err := b.Flush()
+	if cerr := b.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// nopCloser keeps standard output open when the export ends
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
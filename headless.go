@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/sim"
+)
+
+// runHeadless simulates the given number of steps as fast as possible and prints a summary to out
+func runHeadless(world *sim.World, ticks int64, out io.Writer) {
+	start := time.Now()
+	events := map[metrics.EventKind]int{}
+	for range ticks {
+		world.Tick()
+		for _, e := range world.DrainFlockEvents() {
+			events[e.Kind]++
+		}
+	}
+	elapsed := time.Since(start)
+
+	s := world.TickStats()
+	fmt.Fprintf(out, "simulated %d steps (%v of simulated time, now at tick %d) in %v, %.0f steps/s\n",
+		ticks, time.Duration(ticks)*world.Step(), s.Ticks, elapsed.Round(time.Millisecond), float64(ticks)/elapsed.Seconds())
+	fmt.Fprint(out, formatMetrics(world.Metrics()))
+	fmt.Fprintf(out, "flock events: %d formed, %d dissolved, %d splits, %d merges\n",
+		events[metrics.FlockBorn], events[metrics.FlockDissolved], events[metrics.FlockSplit], events[metrics.FlockMerged])
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/gopxl/pixel/v2"
+	"github.com/gopxl/pixel/v2/backends/opengl"
+	"github.com/gopxl/pixel/v2/ext/imdraw"
+	"github.com/gopxl/pixel/v2/ext/text"
+	"golang.org/x/image/colornames"
+)
+
+// formatMetrics lays out the metrics as a table with a row per species that has boids and one for all
+func formatMetrics(m metrics.Frame) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "metrics of tick %d\n", m.Tick)
+	fmt.Fprintf(&b, "%-9s %6s %6s %6s %7s %6s %7s %6s %6s\n",
+		"group", "boids", "polar", "mill", "nearest", "nbrs", "speed", "sd", "flocks")
+	row := func(name string, v metrics.Values) {
+		fmt.Fprintf(&b, "%-9s %6d %6.3f %6.3f %7.2f %6.2f %7.2f %6.2f %6d\n",
+			name, v.Count, v.Polarization, v.Milling, v.MeanNearest, v.MeanNeighbors,
+			v.SpeedMean, math.Sqrt(v.SpeedVar), v.Flocks)
+	}
+	for s, v := range m.Species {
+		if v.Count > 0 {
+			row(fmt.Sprintf("species %d", s), v)
+		}
+	}
+	row("all", m.Global)
+	return b.String()
+}
+
+// drawMetrics writes the metrics table in the top left corner of the window
+func drawMetrics(win *opengl.Window, txt *text.Text, m metrics.Frame) {
+	txt.Clear()
+	txt.Color = colornames.White
+	fmt.Fprint(txt, formatMetrics(m))
+	txt.Draw(win, pixel.IM.Moved(pixel.V(8, win.Bounds().H()-8-txt.LineHeight)))
+}
+
+// flockPalette colors flocks by ID
+var flockPalette = []color.RGBA{
+	colornames.Tomato, colornames.Gold, colornames.Limegreen, colornames.Deepskyblue,
+	colornames.Orchid, colornames.Orange, colornames.Turquoise, colornames.Hotpink,
+	colornames.Chartreuse, colornames.Slateblue, colornames.Khaki, colornames.Salmon,
+}
+
+// flockColor returns the color of a flock; boids outside flocks (ID 0) are dimmed
+func flockColor(id int64) color.RGBA {
+	if id == 0 {
+		return colornames.Dimgray
+	}
+	return flockPalette[id%int64(len(flockPalette))]
+}
+
+// drawFlocks outlines each flock and points from its centroid along its mean velocity
+func drawFlocks(imd *imdraw.IMDraw, flocks []metrics.Flock) {
+	for _, f := range flocks {
+		imd.Color = flockColor(f.ID)
+		imd.Push(pixel.V(f.Min.X, f.Min.Y), pixel.V(f.Max.X, f.Max.Y))
+		imd.Rectangle(1)
+		// where the flock will be in half a second
+		head := f.Centroid.Add(f.Velocity.Scale(0.5))
+		imd.Push(pixel.V(f.Centroid.X, f.Centroid.Y), pixel.V(head.X, head.Y))
+		imd.Line(1)
+	}
+}
+
+// logFlockEvents writes flock events to the log
+func logFlockEvents(events []metrics.Event) {
+	for _, e := range events {
+		switch e.Kind {
+		case metrics.FlockBorn:
+			log.Printf("tick %d: flock %d formed", e.Tick, e.To[0])
+		case metrics.FlockDissolved:
+			log.Printf("tick %d: flock %d dissolved", e.Tick, e.From[0])
+		default:
+			log.Printf("tick %d: flock %v %s into %v", e.Tick, e.From, e.Kind, e.To)
+		}
+	}
+}
@@ -2,50 +2,129 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"image/color"
 	"log"
 	"math"
-	"sync"
+	"os"
 	"time"
 
 	"github.com/OutOfStack/boids/config"
-	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/sim"
+	"github.com/OutOfStack/boids/vector"
 	"github.com/gopxl/pixel/v2"
 	"github.com/gopxl/pixel/v2/backends/opengl"
 	"github.com/gopxl/pixel/v2/ext/imdraw"
 	"golang.org/x/image/colornames"
 )
 
-var (
-	boids  []*Boid
-	qtree  *quadtree.QuadTree
-	rwLock = &sync.RWMutex{}
+const (
+	// snapshotPath is where the S key saves snapshots and the L key loads them from
+	snapshotPath = "snapshot.bin"
+	// rewindScrubSpeed is how fast the arrow keys move through the rewind history, as a multiple of real time
+	rewindScrubSpeed = 4
 )
 
 func main() {
-	cfg := config.GetConfig()
-	setSeed(cfg.Seed)
+	// subcommands have their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "record":
+			runRecord(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "play":
+			runPlay(os.Args[2:])
+			return
+		case "sweep":
+			runSweep(os.Args[2:])
+			return
+		case "optimize":
+			runOptimize(os.Args[2:])
+			return
+		}
+	}
+
+	headless := flag.Bool("headless", false, "simulate without a window, as fast as possible, and print a summary")
+	ticks := flag.Int64("ticks", 1000, "number of steps to simulate in headless mode")
+	loadSnapshot := flag.String("load-snapshot", "", "start from a snapshot file instead of config.json")
+	saveSnapshot := flag.String("save-snapshot", "", "write a snapshot when the run ends; .json for JSON, binary otherwise")
+	trajectoryPath := flag.String("trajectory", "", "write the state of every step to a trajectory file")
+	export := addExportFlags(flag.CommandLine)
+	flag.Parse()
 
-	// initialize boids
-	boids = make([]*Boid, cfg.BoidsCount)
-	for i := range cfg.BoidsCount {
-		boids[i] = createBoid(i)
+	world := newWorld(*loadSnapshot)
+	if *trajectoryPath != "" {
+		f, err := os.Create(*trajectoryPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if err = world.RecordTrajectory(f); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// build initial quadtree from snapshot positions
-	rebuildQuadTreeSnapshot()
+	stopExport, err := export.start(world)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// run simulation in a separate goroutine at fixed update rate
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go simulationLoop(ctx)
+	if *headless {
+		// keep standard output for the metrics when they go there
+		summary := os.Stdout
+		if export.toStdout() {
+			summary = os.Stderr
+		}
+		runHeadless(world, *ticks, summary)
+	} else {
+		// start the rendering loop
+		opengl.Run(func() { run(world) })
+	}
 
-	// start the rendering loop
-	opengl.Run(func() { run(cancel) })
+	if err = world.StopTrajectory(); err != nil {
+		log.Fatal(err)
+	}
+	if err = stopExport(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *saveSnapshot != "" {
+		if err = sim.SaveSnapshot(*saveSnapshot, world.Snapshot()); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// newWorld creates the world from a snapshot file if one is given, from config.json otherwise
+func newWorld(snapshot string) *sim.World {
+	if snapshot == "" {
+		return sim.NewWorld(config.GetConfig())
+	}
+	world, err := restoreWorld(snapshot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return world
 }
 
-// handles the rendering of boids
-func run(cancel context.CancelFunc) {
-	cfg := config.GetConfig()
+// handles the rendering of boids; returns once the window is closed and the simulation has stopped
+func run(world *sim.World) {
+	world.RLock()
+	cfg := world.Config()
+	world.RUnlock()
+
+	// run simulation in a separate goroutine in fixed steps
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		world.Run(ctx)
+		close(done)
+	}()
 
 	windowCfg := opengl.WindowConfig{
 		Title:  "Boids",
@@ -57,177 +136,164 @@ func run(cancel context.CancelFunc) {
 		log.Fatal(err)
 	}
 
-	imd := imdraw.New(nil)
+	r := newRenderer()
+	titleUpdated := time.Now()
+	// while rewinding the simulation is paused and the history is shown at viewTick
+	rewinding := false
+	var viewTick float64
+	last := time.Now()
 
 	// main render loop
 	for !win.Closed() {
+		now := time.Now()
+		elapsed := now.Sub(last)
+		last = now
+
+		r.handleKeys(win)
+		// the simulation only measures the flock while an overlay shows it or metrics are exported
+		world.SetMetricsEnabled(r.needMetrics())
+		if events := world.DrainFlockEvents(); r.showFlocks {
+			logFlockEvents(events)
+		}
+		// commands that act on the world are sent as inputs and run between steps, so they
+		// happen at a definite tick and can be recorded; D dumps the quadtree for offline analysis
+		if win.JustPressed(pixel.KeyD) {
+			world.Send(sim.Input{Kind: sim.InputDumpQuadTree})
+		}
+		// S saves a snapshot, L replaces the running world with the saved one
+		if win.JustPressed(pixel.KeyS) {
+			world.Send(sim.Input{Kind: sim.InputSaveSnapshot, Path: snapshotPath})
+		}
+		if win.JustPressed(pixel.KeyL) {
+			if data, err := os.ReadFile(snapshotPath); err != nil {
+				log.Printf("load snapshot: %v", err)
+			} else {
+				world.Send(sim.Input{Kind: sim.InputLoadSnapshot, Snapshot: data})
+			}
+		}
+
+		// Backspace enters and leaves the rewind history, Left and Right scrub through it,
+		// Enter resumes the simulation from the shown tick
+		if win.JustPressed(pixel.KeyBackspace) {
+			if first, newest, ok := world.HistorySpan(); !ok {
+				log.Print("rewinding is disabled, set rewind_seconds")
+			} else if !rewinding {
+				rewinding, viewTick = true, float64(newest)
+				world.SetPaused(true)
+				// flocks in the history are followed afresh
+				r.forget()
+				log.Printf("rewind: ticks %d to %d", first, newest)
+			} else {
+				rewinding = false
+				world.SetPaused(false)
+			}
+		}
+		if rewinding {
+			first, newest, _ := world.HistorySpan()
+			world.RLock()
+			scrub := elapsed.Seconds() / world.Step().Seconds() * rewindScrubSpeed
+			world.RUnlock()
+			if win.Pressed(pixel.KeyLeft) {
+				viewTick -= scrub
+			}
+			if win.Pressed(pixel.KeyRight) {
+				viewTick += scrub
+			}
+			viewTick = math.Max(float64(first), math.Min(viewTick, float64(newest)))
+			if win.JustPressed(pixel.KeyEnter) {
+				world.Send(sim.Input{Kind: sim.InputRewind, To: int64(viewTick)})
+				rewinding = false
+				world.SetPaused(false)
+			}
+		}
+
+		// report steps the simulation could not run on schedule
+		if rewinding {
+			win.SetTitle(fmt.Sprintf("Boids | rewind to tick %d | Enter resumes here", int64(viewTick)))
+		} else if time.Since(titleUpdated) >= time.Second {
+			s := world.TickStats()
+			win.SetTitle(fmt.Sprintf("Boids | tick %d | %d late | %d dropped", s.Ticks, s.Late, s.Dropped))
+			titleUpdated = time.Now()
+		}
+
 		win.Clear(colornames.Black)
-		rwLock.RLock()
-		for _, b := range boids {
-			// compute the angle of the boid's velocity for directional rendering
-			angle := math.Atan2(b.velocity.Y, b.velocity.X)
-
-			// calculate triangle vertices to represent the boid's direction
-			size := float64(4)
-			tip := pixel.V(b.position.X+size*math.Cos(angle),
-				b.position.Y+size*math.Sin(angle))
-			left := pixel.V(b.position.X+size*math.Cos(angle-2.3),
-				b.position.Y+size*math.Sin(angle-2.3))
-			right := pixel.V(b.position.X+size*math.Cos(angle+2.3),
-				b.position.Y+size*math.Sin(angle+2.3))
-
-			imd.Color = b.color
-			imd.Push(tip, left, right)
-			imd.Polygon(cfg.PolyThickness) // filled triangle
+		if rewinding {
+			drawHistory(win, r, world, int64(viewTick))
+		} else {
+			var m *metrics.Frame
+			if r.needMetrics() {
+				fr := world.Metrics()
+				m = &fr
+			}
+			world.RLock()
+			// draw boids between the last two steps so that motion is smooth at any refresh rate
+			alpha := world.Alpha()
+			s := &scene{
+				cfg: world.Config(), flock: world.Flock(), metrics: m,
+				position: func(i int) vector.Vec2 { return world.Interpolated(i, alpha) },
+				tree:     world.QuadTree,
+			}
+			r.draw(s)
+			world.RUnlock()
+			r.present(win, s)
 		}
-		rwLock.RUnlock()
-		imd.Draw(win)
-		imd.Clear()
 
 		win.Update()
 	}
 
-	// request simulation shutdown when window closes
+	// stop the simulation when the window closes
 	cancel()
+	<-done
 }
 
-// simulationLoop runs the two-phase update at a fixed tick rate
-func simulationLoop(ctx context.Context) {
-	cfg := config.GetConfig()
-	ticker := time.NewTicker(time.Duration(cfg.UpdateRateMs) * time.Millisecond)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			tickOnce()
-		}
+// drawHistory draws the boids as they were after a tick of the rewind history
+func drawHistory(win *opengl.Window, r *renderer, world *sim.World, tick int64) {
+	f, err := world.HistoryFlock(tick)
+	if err != nil {
+		log.Printf("rewind: %v", err)
+		return
 	}
+	world.RLock()
+	cfg := world.Config()
+	world.RUnlock()
+	s := &scene{cfg: cfg, flock: f, metrics: r.measure(cfg, tick, f)}
+	r.draw(s)
+	r.present(win, s)
 }
 
-// tickOnce performs: snapshot -> build qtree -> compute -> apply -> rebuild qtree for next queries
-func tickOnce() {
-	cfg := config.GetConfig()
-	// snapshot positions and velocities
-	rwLock.RLock()
-	positions := make([]pixel.Vec, len(boids))
-	velocities := make([]pixel.Vec, len(boids))
-	for i, b := range boids {
-		positions[i] = b.position
-		velocities[i] = b.velocity
-	}
-	rwLock.RUnlock()
-
-	// build quadtree from snapshot for neighbor queries, with wrap-around ghosts
-	buildQuadTreeWithGhosts(positions)
-
-	// compute accelerations and apply updates
-	newPositions := make([]pixel.Vec, len(boids))
-	newVelocities := make([]pixel.Vec, len(boids))
-
-	for i := range boids {
-		accel := calcAccelerationFor(i, positions, velocities)
-		// limit velocity and integrate
-		nv := velocities[i].Add(accel)
-		if nv.X > 1 {
-			nv.X = 1
-		} else if nv.X < -1 {
-			nv.X = -1
-		}
-		if nv.Y > 1 {
-			nv.Y = 1
-		} else if nv.Y < -1 {
-			nv.Y = -1
-		}
-		np := positions[i].Add(nv)
-		// wrap around
-		width, height := float64(cfg.Width), float64(cfg.Height)
-		if np.X < 0 {
-			np.X += width
-		} else if np.X >= width {
-			np.X -= width
-		}
-		if np.Y < 0 {
-			np.Y += height
-		} else if np.Y >= height {
-			np.Y -= height
-		}
-		newPositions[i] = np
-		newVelocities[i] = nv
+// restoreWorld creates a world from a snapshot file
+func restoreWorld(path string) (*sim.World, error) {
+	s, err := sim.LoadSnapshot(path)
+	if err != nil {
+		return nil, err
 	}
+	return sim.Restore(s)
+}
 
-	// apply
-	rwLock.Lock()
-	for i, b := range boids {
-		b.position = newPositions[i]
-		b.velocity = newVelocities[i]
-	}
-	rwLock.Unlock()
+// drawBoid draws a boid as a triangle pointing along its velocity
+func drawBoid(imd *imdraw.IMDraw, p vector.Vec2, vx, vy float64, col color.RGBA, thickness float64) {
+	// compute the angle of the boid's velocity for directional rendering
+	angle := math.Atan2(vy, vx)
 
-	// rebuild quadtree from updated positions for next frame queries
-	rebuildQuadTreeSnapshot()
-}
+	// calculate triangle vertices to represent the boid's direction
+	size := float64(4)
+	tip := pixel.V(p.X+size*math.Cos(angle),
+		p.Y+size*math.Sin(angle))
+	left := pixel.V(p.X+size*math.Cos(angle-2.3),
+		p.Y+size*math.Sin(angle-2.3))
+	right := pixel.V(p.X+size*math.Cos(angle+2.3),
+		p.Y+size*math.Sin(angle+2.3))
 
-// rebuildQuadTreeSnapshot builds a quadtree with current boid positions (no ghosts)
-func rebuildQuadTreeSnapshot() {
-	rwLock.RLock()
-	positions := make([]pixel.Vec, len(boids))
-	for i, b := range boids {
-		positions[i] = b.position
-	}
-	rwLock.RUnlock()
-	buildQuadTreeWithGhosts(positions)
+	imd.Color = col
+	imd.Push(tip, left, right)
+	imd.Polygon(thickness) // filled triangle
 }
 
-// buildQuadTreeWithGhosts builds qtree from given positions and inserts ghost objects near borders to emulate toroidal space
-func buildQuadTreeWithGhosts(positions []pixel.Vec) {
-	cfg := config.GetConfig()
-	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: float64(cfg.Width), Height: float64(cfg.Height)}, 0, cfg.QuadtreeMaxObj, cfg.QuadtreeMaxLvl)
-	width := float64(cfg.Width)
-	height := float64(cfg.Height)
-	r := cfg.ViewRadius
-
-	for i := range positions {
-		p := positions[i]
-		// insert original
-		qt.Insert(&quadtree.Object{ID: int64(i), Position: p})
-
-		// ghosts when within view radius of edges
-		nearLeft := p.X < r
-		nearRight := p.X > width-r
-		nearBottom := p.Y < r
-		nearTop := p.Y > height-r
-
-		if nearLeft {
-			qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(p.X+width, p.Y)})
-		}
-		if nearRight {
-			qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(p.X-width, p.Y)})
-		}
-		if nearBottom {
-			qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(p.X, p.Y+height)})
-		}
-		if nearTop {
-			qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(p.X, p.Y-height)})
-		}
-		// corners
-		if nearLeft && nearBottom {
-			qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(p.X+width, p.Y+height)})
-		}
-		if nearLeft && nearTop {
-			qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(p.X+width, p.Y-height)})
-		}
-		if nearRight && nearBottom {
-			qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(p.X-width, p.Y+height)})
-		}
-		if nearRight && nearTop {
-			qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(p.X-width, p.Y-height)})
-		}
+// drawObstacles fills the obstacles
+func drawObstacles(imd *imdraw.IMDraw, cfg *config.Config) {
+	imd.Color = colornames.Dimgray
+	for _, o := range cfg.Obstacles {
+		imd.Push(pixel.V(o.X, o.Y), pixel.V(o.X+o.Width, o.Y+o.Height))
+		imd.Rectangle(0)
 	}
-
-	// swap global qtree
-	rwLock.Lock()
-	qtree = qt
-	rwLock.Unlock()
 }
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format is the layout of an exported time series
+type Format string
+
+const (
+	// FormatCSV writes a header line with the column names, then one comma-separated row per tick
+	FormatCSV Format = "csv"
+	// FormatNDJSON writes one JSON object per tick and line
+	FormatNDJSON Format = "ndjson"
+)
+
+// DefaultFields are the columns exported when none are chosen
+var DefaultFields = []string{"polarization", "milling", "nearest", "neighbors", "speed", "speed_sd", "flocks"}
+
+// fieldValues are the metrics available for export, computed from the values of a group
+var fieldValues = map[string]func(v Values) float64{
+	"count":        func(v Values) float64 { return float64(v.Count) },
+	"polarization": func(v Values) float64 { return v.Polarization },
+	"milling":      func(v Values) float64 { return v.Milling },
+	"nearest":      func(v Values) float64 { return v.MeanNearest },
+	"neighbors":    func(v Values) float64 { return v.MeanNeighbors },
+	"speed":        func(v Values) float64 { return v.SpeedMean },
+	"speed_sd":     func(v Values) float64 { return math.Sqrt(v.SpeedVar) },
+	"flocks":       func(v Values) float64 { return float64(v.Flocks) },
+	"flock_size":   func(v Values) float64 { return v.MeanFlockSize },
+}
+
+// eventFields count the flock events of the exported tick; they are not split by species
+var eventFields = map[string]EventKind{
+	"born":      FlockBorn,
+	"dissolved": FlockDissolved,
+	"split":     FlockSplit,
+	"merged":    FlockMerged,
+}
+
+// FieldNames lists the metrics that can be exported, in a stable order
+func FieldNames() []string {
+	names := sortedKeys(fieldValues)
+	return append(names, sortedKeys(eventFields)...)
+}
+
+// column is one exported value
+type column struct {
+	name  string
+	value func(fr *Frame) float64
+}
+
+// Exporter writes chosen metrics as a time series, one row per call to Write. Every row starts
+// with the tick and the simulated time in seconds.
+type Exporter struct {
+	w       io.Writer
+	format  Format
+	columns []column
+	started bool
+	buf     []byte
+}
+
+// NewExporter creates an exporter writing the given fields to w. A field is a metric of all
+// boids, such as "polarization", or of one species when followed by an underscore and the
+// species number, such as "polarization_1".
+func NewExporter(w io.Writer, format Format, fields []string, species int) (*Exporter, error) {
+	if format != FormatCSV && format != FormatNDJSON {
+		return nil, fmt.Errorf("unknown metrics format %q", format)
+	}
+	if len(fields) == 0 {
+		fields = DefaultFields
+	}
+	e := &Exporter{w: w, format: format}
+	seen := map[string]bool{}
+	for _, name := range fields {
+		name = strings.TrimSpace(name)
+		value, err := Field(name, species)
+		if err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("metric %q is exported twice", name)
+		}
+		seen[name] = true
+		e.columns = append(e.columns, column{name: name, value: value})
+	}
+	return e, nil
+}
+
+// Field returns the function reading the named metric from a frame, for the given number of
+// species. Names are those accepted by NewExporter.
+func Field(name string, species int) (func(fr *Frame) float64, error) {
+	if kind, ok := eventFields[name]; ok {
+		return func(fr *Frame) float64 { return float64(countEvents(fr.Events, kind)) }, nil
+	}
+	if value, ok := fieldValues[name]; ok {
+		return func(fr *Frame) float64 { return value(fr.Global) }, nil
+	}
+	// a species suffix
+	i := strings.LastIndexByte(name, '_')
+	if i < 0 {
+		return nil, unknownField(name)
+	}
+	value, ok := fieldValues[name[:i]]
+	s, err := strconv.Atoi(name[i+1:])
+	if !ok || err != nil {
+		return nil, unknownField(name)
+	}
+	if s < 0 || s >= species {
+		return nil, fmt.Errorf("metric %q: no species %d", name, s)
+	}
+	return func(fr *Frame) float64 { return value(fr.Species[s]) }, nil
+}
+
+func unknownField(name string) error {
+	return fmt.Errorf("unknown metric %q, want one of %s, optionally followed by _<species>",
+		name, strings.Join(FieldNames(), ", "))
+}
+
+func countEvents(events []Event, kind EventKind) int {
+	n := 0
+	for _, e := range events {
+		if e.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Write writes the row of one frame, measured seconds of simulated time into the run. Each row
+// goes out in a single write so that readers at the other end of a pipe see whole lines.
+func (e *Exporter) Write(fr *Frame, seconds float64) error {
+	b := e.buf[:0]
+	switch e.format {
+	case FormatCSV:
+		if !e.started {
+			b = append(b, "tick,time"...)
+			for _, c := range e.columns {
+				b = append(b, ',')
+				b = append(b, c.name...)
+			}
+			b = append(b, '\n')
+		}
+		b = strconv.AppendInt(b, fr.Tick, 10)
+		b = append(b, ',')
+		b = e.appendValue(b, seconds)
+		for _, c := range e.columns {
+			b = append(b, ',')
+			b = e.appendValue(b, c.value(fr))
+		}
+	case FormatNDJSON:
+		b = append(b, `{"tick":`...)
+		b = strconv.AppendInt(b, fr.Tick, 10)
+		b = append(b, `,"time":`...)
+		b = e.appendValue(b, seconds)
+		for _, c := range e.columns {
+			// names are plain identifiers, so they need no escaping
+			b = append(b, `,"`...)
+			b = append(b, c.name...)
+			b = append(b, `":`...)
+			b = e.appendValue(b, c.value(fr))
+		}
+		b = append(b, '}')
+	}
+	b = append(b, '\n')
+	e.buf = b
+	e.started = true
+	if _, err := e.w.Write(b); err != nil {
+		return fmt.Errorf("export metrics: %w", err)
+	}
+	return nil
+}
+
+// appendValue formats a value as briefly as it round-trips; JSON has no NaN or infinities,
+// so those become null there
+func (e *Exporter) appendValue(b []byte, v float64) []byte {
+	if e.format == FormatNDJSON && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return append(b, "null"...)
+	}
+	return strconv.AppendFloat(b, v, 'g', -1, 64)
+}
@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/OutOfStack/boids/vector"
+)
+
+// Flock is a connected group of flockmates that keeps its ID from tick to tick as long as it
+// remains the main continuation of the same boids
+type Flock struct {
+	ID       int64
+	Species  uint8
+	Size     int
+	Centroid vector.Vec2
+	Velocity vector.Vec2 // mean velocity
+	// Min and Max bound the members, unwrapped around the flock so that a flock crossing a border
+	// stays in one piece; they may extend past the world edges
+	Min, Max vector.Vec2
+}
+
+// EventKind tells what happened to flocks
+type EventKind string
+
+// Flock events
+const (
+	// FlockBorn is a new flock formed from boids that did not belong to one
+	FlockBorn EventKind = "born"
+	// FlockDissolved is a flock whose boids no longer form one
+	FlockDissolved EventKind = "dissolved"
+	// FlockSplit is a flock that broke into several; From holds it, To all pieces including the
+	// one that keeps its ID
+	FlockSplit EventKind = "split"
+	// FlockMerged is several flocks that joined; From holds them, To the resulting flock, which
+	// keeps the ID of the one it took most boids from
+	FlockMerged EventKind = "merged"
+)
+
+// Event is a change in the set of flocks between two ticks
+type Event struct {
+	Tick int64     `json:"tick"`
+	Kind EventKind `json:"kind"`
+	From []int64   `json:"from,omitempty"`
+	To   []int64   `json:"to,omitempty"`
+}
+
+// tracker carries flock identities over from one tick to the next
+type tracker struct {
+	nextID     int64
+	prevIDs    []int64 // boid IDs at the previous tick, by slot
+	prevLabels []int64 // flock of each slot at the previous tick, 0 for none
+	prevFlocks []int64 // IDs of the flocks at the previous tick, in order
+
+	// scratch, kept to be reused from tick to tick
+	clusterOf  []int32            // cluster of each component root, -1 for none
+	prevBySlot []int64            // previous flock by current slot, when boids changed slots
+	byID       map[int64]int64    // previous flock by boid ID, when boids changed slots
+	clusters   []*cluster         // clusters of the current tick
+	pool       []*cluster         // every cluster allocated so far
+	members    []int32            // shared by the clusters, each taking its component's size
+	succ       map[int64]*cluster // main successor of each previous flock
+	inherited  map[int64]bool     // previous flocks whose ID was kept
+	pieces     map[int64][]int64  // new flocks split off each previous flock
+	merged     map[int64][]int64  // previous flocks absorbed by each flock
+}
+
+// cluster is a flock in the making
+type cluster struct {
+	members []int32
+	minID   int64 // smallest boid ID, for stable tie-breaking
+	overlap []share
+	best    int64 // previous flock most of the members come from, 0 if none
+	flock   Flock
+}
+
+// share counts the members a cluster has taken from a previous flock
+type share struct {
+	flock int64
+	n     int
+}
+
+// shareOf returns how many members the cluster took from previous flock p
+func (cl *cluster) shareOf(p int64) int {
+	for _, s := range cl.overlap {
+		if s.flock == p {
+			return s.n
+		}
+	}
+	return 0
+}
+
+// previous returns the flock each boid belonged to at the previous tick, by current slot
+func (t *tracker) previous(ids []int64) []int64 {
+	if slices.Equal(ids, t.prevIDs) {
+		return t.prevLabels
+	}
+	// boids changed slots since: go through their IDs
+	t.byID = reset(t.byID)
+	for i, id := range t.prevIDs {
+		if t.prevLabels[i] != 0 {
+			t.byID[id] = t.prevLabels[i]
+		}
+	}
+	t.prevBySlot = grow(t.prevBySlot, len(ids))
+	for i, id := range ids {
+		t.prevBySlot[i] = t.byID[id]
+	}
+	return t.prevBySlot
+}
+
+// cluster returns the k-th cluster of a tick, emptied, with room for size members
+func (t *tracker) cluster(k, size int) *cluster {
+	if k == len(t.pool) {
+		t.pool = append(t.pool, &cluster{})
+	}
+	cl := t.pool[k]
+	*cl = cluster{minID: math.MaxInt64, overlap: cl.overlap[:0]}
+	n := len(t.members)
+	t.members = t.members[:n+size]
+	cl.members = t.members[n : n : n+size]
+	return cl
+}
+
+// reset returns m emptied, or a new map if m is nil
+func reset[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return map[K]V{}
+	}
+	clear(m)
+	return m
+}
+
+// trackFlocks groups the components of at least minSize boids into flocks, matches them with the
+// flocks of the previous tick and reports the differences
+func (c *Calculator) trackFlocks(tick int64, in Input, minSize int, b *frameBuffers) ([]Flock, []Event, []int64) {
+	t := &c.tracker
+	n := len(in.X)
+	prevOf := t.previous(in.ID)
+
+	// components large enough to be flocks, in order of their smallest slot
+	t.clusterOf = grow(t.clusterOf, n)
+	for i := range t.clusterOf {
+		t.clusterOf[i] = -1
+	}
+	clusters := t.clusters[:0]
+	t.members = grow(t.members, n)[:0]
+	for i := range n {
+		r := c.uf.root(int32(i))
+		size := int(c.uf.size[r])
+		if size < max(minSize, 2) {
+			continue
+		}
+		if t.clusterOf[r] < 0 {
+			t.clusterOf[r] = int32(len(clusters))
+			clusters = append(clusters, t.cluster(len(clusters), size))
+		}
+		cl := clusters[t.clusterOf[r]]
+		cl.members = append(cl.members, int32(i))
+		cl.minID = min(cl.minID, in.ID[i])
+		if p := prevOf[i]; p != 0 {
+			k := slices.IndexFunc(cl.overlap, func(s share) bool { return s.flock == p })
+			if k < 0 {
+				cl.overlap = append(cl.overlap, share{flock: p})
+				k = len(cl.overlap) - 1
+			}
+			cl.overlap[k].n++
+		}
+	}
+
+	// each cluster's main source, and each previous flock's main successor
+	t.succ = reset(t.succ)
+	succ := t.succ
+	for _, cl := range clusters {
+		for _, s := range cl.overlap {
+			if cl.best == 0 || s.n > cl.shareOf(cl.best) || s.n == cl.shareOf(cl.best) && s.flock < cl.best {
+				cl.best = s.flock
+			}
+			o := succ[s.flock]
+			if o == nil || s.n > o.shareOf(s.flock) || s.n == o.shareOf(s.flock) &&
+				(len(cl.members) > len(o.members) || len(cl.members) == len(o.members) && cl.minID < o.minID) {
+				succ[s.flock] = cl
+			}
+		}
+	}
+
+	// a cluster keeps the ID of a previous flock when they are each other's best match
+	events := b.events[:0]
+	t.inherited = reset(t.inherited)
+	inherited := t.inherited
+	for _, cl := range clusters {
+		if cl.best != 0 && succ[cl.best] == cl {
+			cl.flock.ID = cl.best
+			inherited[cl.best] = true
+		}
+	}
+	// remaining clusters are new: pieces split off a flock or born from loose boids
+	t.pieces = reset(t.pieces)
+	pieces := t.pieces
+	for _, cl := range clusters {
+		if cl.flock.ID != 0 {
+			continue
+		}
+		t.nextID++
+		cl.flock.ID = t.nextID
+		if cl.best != 0 {
+			pieces[cl.best] = append(pieces[cl.best], cl.flock.ID)
+		} else {
+			events = append(events, Event{Tick: tick, Kind: FlockBorn, To: []int64{cl.flock.ID}})
+		}
+	}
+	for _, p := range sortedKeys(pieces) {
+		to := pieces[p]
+		if inherited[p] {
+			to = append([]int64{p}, to...)
+		}
+		events = append(events, Event{Tick: tick, Kind: FlockSplit, From: []int64{p}, To: to})
+	}
+	// previous flocks that lost their ID were absorbed by another or fell apart
+	t.merged = reset(t.merged)
+	merged := t.merged
+	for _, p := range t.prevFlocks {
+		if s, ok := succ[p]; ok && !inherited[p] {
+			merged[s.flock.ID] = append(merged[s.flock.ID], p)
+		}
+	}
+	for _, id := range sortedKeys(merged) {
+		from := merged[id]
+		if inherited[id] {
+			from = append([]int64{id}, from...)
+		}
+		events = append(events, Event{Tick: tick, Kind: FlockMerged, From: from, To: []int64{id}})
+	}
+	for _, p := range t.prevFlocks {
+		if _, ok := succ[p]; !ok {
+			events = append(events, Event{Tick: tick, Kind: FlockDissolved, From: []int64{p}})
+		}
+	}
+
+	// describe the flocks and remember who belongs where
+	slices.SortFunc(clusters, func(a, b *cluster) int { return cmp.Compare(a.flock.ID, b.flock.ID) })
+	labels := grow(b.labels, n)
+	clear(labels)
+	flocks := grow(b.flocks, len(clusters))
+	for k, cl := range clusters {
+		cl.describe(in)
+		flocks[k] = cl.flock
+		for _, i := range cl.members {
+			labels[i] = cl.flock.ID
+		}
+	}
+	t.prevIDs = append(t.prevIDs[:0], in.ID...)
+	t.prevLabels = labels
+	t.prevFlocks = t.prevFlocks[:0]
+	for _, f := range flocks {
+		t.prevFlocks = append(t.prevFlocks, f.ID)
+	}
+	t.clusters = clusters
+	b.labels, b.flocks, b.events = labels, flocks, events
+	return flocks, events, labels
+}
+
+// describe fills in the size, centroid, velocity and bounds of the cluster's flock
+func (cl *cluster) describe(in Input) {
+	f := &cl.flock
+	ref := cl.members[0]
+	f.Species = in.Species[ref]
+	f.Size = len(cl.members)
+	origin := vector.V(in.X[ref], in.Y[ref])
+	f.Min = vector.V(math.Inf(1), math.Inf(1))
+	f.Max = vector.V(math.Inf(-1), math.Inf(-1))
+	var offsets, velocity vector.Vec2
+	for _, i := range cl.members {
+		// measure from the first member along the shortest way on the torus
+		d := vector.V(wrap(in.X[i]-origin.X, in.Width), wrap(in.Y[i]-origin.Y, in.Height))
+		offsets = offsets.Add(d)
+		velocity = velocity.Add(vector.V(in.VX[i], in.VY[i]))
+		f.Min = vector.V(min(f.Min.X, d.X), min(f.Min.Y, d.Y))
+		f.Max = vector.V(max(f.Max.X, d.X), max(f.Max.Y, d.Y))
+	}
+	size := float64(f.Size)
+	mean := offsets.Scale(1 / size)
+	f.Velocity = velocity.Scale(1 / size)
+
+	// report the centroid inside the world and shift the bounds along with it
+	c := origin.Add(mean)
+	shift := vector.V(floorTo(c.X, in.Width), floorTo(c.Y, in.Height))
+	f.Centroid = c.Sub(shift)
+	f.Min = origin.Add(f.Min).Sub(shift)
+	f.Max = origin.Add(f.Max).Sub(shift)
+}
+
+// wrap maps an offset between two points of an axis of the given size to its shortest
+// equivalent; 0 means no wrapping
+func wrap(d, size float64) float64 {
+	switch {
+	case size <= 0:
+		return d
+	case d > size/2:
+		return d - size
+	case d < -size/2:
+		return d + size
+	}
+	return d
+}
+
+// floorTo returns the multiple of size to subtract from v to bring it into [0, size)
+func floorTo(v, size float64) float64 {
+	if size <= 0 {
+		return 0
+	}
+	return math.Floor(v/size) * size
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,245 @@
+// Package metrics computes order parameters that describe the collective state of a flock:
+// how aligned it is, how much it rotates, how dense it is and how many groups it forms.
+package metrics
+
+import (
+	"math"
+	"slices"
+)
+
+// Edge links two boids that see each other as flockmates
+type Edge struct {
+	A, B int32
+}
+
+// Input is the state of all boids at one tick together with what the neighbor search found
+type Input struct {
+	ID      []int64 // stable boid identifiers, to follow flocks over time
+	X, Y    []float64
+	VX, VY  []float64
+	Species []uint8
+	// Neighbors is the number of flockmates each boid saw
+	Neighbors []int32
+	// Nearest is the distance to each boid's nearest flockmate, +Inf when it saw none
+	Nearest []float64
+	// Edges are the flockmate pairs, in either direction
+	Edges []Edge
+	// Width and Height are the size of the toroidal world; 0 disables wrapping
+	Width, Height float64
+	// MinFlockSize is the smallest group counted as a flock; values below 2 mean 2
+	MinFlockSize int
+}
+
+// Values are the metrics of one group of boids
+type Values struct {
+	Count int
+	// Polarization is the length of the mean heading: 1 when all boids fly the same way,
+	// near 0 when headings are random
+	Polarization float64
+	// Milling is the rotation order parameter: the mean normalized angular momentum around the
+	// group's center of mass, 1 for a vortex and near 0 for random or straight motion.
+	// Positions are taken as they are, without unwrapping the toroidal world.
+	Milling float64
+	// MeanNearest is the mean distance to the nearest flockmate over the boids that have one
+	MeanNearest float64
+	// MeanNeighbors is the mean number of flockmates seen
+	MeanNeighbors float64
+	// SpeedMean and SpeedVar are the mean and the variance of the speed
+	SpeedMean, SpeedVar float64
+	// Flocks is the number of flocks: connected groups of at least Input.MinFlockSize flockmates
+	Flocks int
+	// MeanFlockSize is the mean number of boids in a flock, 0 without flocks
+	MeanFlockSize float64
+}
+
+// Frame holds the metrics of one tick, for all boids and per species
+type Frame struct {
+	Tick    int64
+	Global  Values
+	Species []Values // indexed by species
+	// Flocks are ordered by ID
+	Flocks []Flock
+	// Events are the changes in flocks since the previous tick
+	Events []Event
+	// IDs are the boid IDs by slot and Labels the flock of each slot, 0 for boids outside flocks
+	IDs    []int64
+	Labels []int64
+}
+
+// LabelsFor returns the flock of each boid for the given slot order, which may differ from the
+// one the frame was measured in when boids were reordered since
+func (fr *Frame) LabelsFor(ids []int64) []int64 {
+	if slices.Equal(ids, fr.IDs) {
+		return fr.Labels
+	}
+	byID := make(map[int64]int64, len(fr.IDs))
+	for i, id := range fr.IDs {
+		byID[id] = fr.Labels[i]
+	}
+	labels := make([]int64, len(ids))
+	for i, id := range ids {
+		labels[i] = byID[id]
+	}
+	return labels
+}
+
+// Clone returns a copy of the frame that shares nothing with it
+func (fr *Frame) Clone() Frame {
+	c := *fr
+	c.Species = slices.Clone(fr.Species)
+	c.Flocks = slices.Clone(fr.Flocks)
+	c.Events = slices.Clone(fr.Events)
+	c.IDs = slices.Clone(fr.IDs)
+	c.Labels = slices.Clone(fr.Labels)
+	return c
+}
+
+// Calculator computes metrics, reusing its buffers from tick to tick, and follows flocks over time
+type Calculator struct {
+	uf      unionFind
+	tracker tracker
+	groups  []accumulator
+	// frames alternate between two sets of buffers, so that the previous frame stays intact
+	// while the next one is computed
+	out  [2]frameBuffers
+	turn int
+}
+
+// frameBuffers hold the slices of a frame returned by Compute
+type frameBuffers struct {
+	species []Values
+	flocks  []Flock
+	events  []Event
+	ids     []int64
+	labels  []int64
+}
+
+// Compute returns the metrics of the input for the given number of species. The frame shares its
+// slices with the calculator: they stay valid during the next call and are reused by the one
+// after; Clone keeps them for longer.
+func (c *Calculator) Compute(tick int64, in Input, species int) Frame {
+	c.turn ^= 1
+	b := &c.out[c.turn]
+	b.species = grow(b.species, species)
+	fr := Frame{Tick: tick, Species: b.species}
+	n := len(in.X)
+	c.groups = grow(c.groups, species+1)
+	clear(c.groups)
+	groups := c.groups
+	global := &groups[species]
+
+	// first pass: headings, speeds, density and centers of mass
+	for i := range n {
+		b := boidTerms(in, i)
+		groups[in.Species[i]].add(&b)
+		global.add(&b)
+	}
+	// second pass: angular momentum around each group's center
+	for i := range n {
+		g := &groups[in.Species[i]]
+		g.rotation += rotation(in, i, g)
+		global.rotation += rotation(in, i, global)
+	}
+
+	// flocks are connected components of the flockmate graph; flockmates share a species
+	c.uf.reset(n)
+	for _, e := range in.Edges {
+		c.uf.union(e.A, e.B)
+	}
+	fr.Flocks, fr.Events, fr.Labels = c.trackFlocks(tick, in, in.MinFlockSize, b)
+	b.ids = append(b.ids[:0], in.ID...)
+	fr.IDs = b.ids
+	for _, f := range fr.Flocks {
+		groups[f.Species].flocks++
+		groups[f.Species].flockMembers += f.Size
+		global.flocks++
+		global.flockMembers += f.Size
+	}
+
+	for s := range fr.Species {
+		fr.Species[s] = groups[s].values()
+	}
+	fr.Global = global.values()
+	return fr
+}
+
+// accumulator sums the per-boid terms of one group
+type accumulator struct {
+	terms
+	count        int
+	withNearest  int
+	rotation     float64
+	flocks       int
+	flockMembers int
+}
+
+// terms are the per-boid quantities that are summed over a group
+type terms struct {
+	headX, headY  float64
+	x, y          float64
+	speed, speed2 float64
+	nearest       float64
+	neighbors     float64
+	hasNearest    bool
+}
+
+func boidTerms(in Input, i int) terms {
+	speed := math.Sqrt(in.VX[i]*in.VX[i] + in.VY[i]*in.VY[i])
+	t := terms{
+		x: in.X[i], y: in.Y[i],
+		speed: speed, speed2: speed * speed,
+		neighbors: float64(in.Neighbors[i]),
+	}
+	if speed > 0 {
+		t.headX, t.headY = in.VX[i]/speed, in.VY[i]/speed
+	}
+	if d := in.Nearest[i]; !math.IsInf(d, 1) {
+		t.nearest, t.hasNearest = d, true
+	}
+	return t
+}
+
+func (a *accumulator) add(t *terms) {
+	a.count++
+	a.headX += t.headX
+	a.headY += t.headY
+	a.x += t.x
+	a.y += t.y
+	a.speed += t.speed
+	a.speed2 += t.speed2
+	a.neighbors += t.neighbors
+	if t.hasNearest {
+		a.nearest += t.nearest
+		a.withNearest++
+	}
+}
+
+// rotation returns the normalized angular momentum of boid i around the center of group a
+func rotation(in Input, i int, a *accumulator) float64 {
+	rx, ry := in.X[i]-a.x/float64(a.count), in.Y[i]-a.y/float64(a.count)
+	rv := math.Sqrt((rx*rx + ry*ry) * (in.VX[i]*in.VX[i] + in.VY[i]*in.VY[i]))
+	if rv == 0 {
+		return 0
+	}
+	return (rx*in.VY[i] - ry*in.VX[i]) / rv
+}
+
+func (a *accumulator) values() Values {
+	v := Values{Count: a.count, Flocks: a.flocks}
+	if a.flocks > 0 {
+		v.MeanFlockSize = float64(a.flockMembers) / float64(a.flocks)
+	}
+	if a.count == 0 {
+		return v
+	}
+	n := float64(a.count)
+	v.Polarization = math.Hypot(a.headX, a.headY) / n
+	v.Milling = math.Abs(a.rotation) / n
+	v.MeanNeighbors = a.neighbors / n
+	v.SpeedMean = a.speed / n
+	v.SpeedVar = max(a.speed2/n-v.SpeedMean*v.SpeedMean, 0)
+	if a.withNearest > 0 {
+		v.MeanNearest = a.nearest / float64(a.withNearest)
+	}
+	return v
+}
@@ -0,0 +1,275 @@
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/OutOfStack/boids/metrics"
+)
+
+// input places n boids of the given species with positions and velocities from fn
+func input(n int, species func(i int) uint8, fn func(i int) (x, y, vx, vy float64)) metrics.Input {
+	in := metrics.Input{
+		ID: make([]int64, n),
+		X:  make([]float64, n), Y: make([]float64, n),
+		VX: make([]float64, n), VY: make([]float64, n),
+		Species:   make([]uint8, n),
+		Neighbors: make([]int32, n),
+		Nearest:   make([]float64, n),
+	}
+	for i := range n {
+		in.X[i], in.Y[i], in.VX[i], in.VY[i] = fn(i)
+		in.Species[i] = species(i)
+		in.Nearest[i] = math.Inf(1)
+		in.ID[i] = int64(i)
+	}
+	return in
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPolarizationAndMilling(t *testing.T) {
+	var calc metrics.Calculator
+	one := func(int) uint8 { return 0 }
+	rng := rand.New(rand.NewSource(1)) //nolint:gosec
+
+	// everyone flies east at different speeds
+	aligned := input(100, one, func(i int) (x, y, vx, vy float64) {
+		return rng.Float64() * 100, rng.Float64() * 100, float64(1 + i%5), 0
+	})
+	v := calc.Compute(0, aligned, 1).Global
+	if !near(v.Polarization, 1) || v.Milling > 0.2 {
+		t.Errorf("aligned: polarization %v, milling %v", v.Polarization, v.Milling)
+	}
+	if !near(v.SpeedMean, 3) || !near(v.SpeedVar, 2) {
+		t.Errorf("aligned: speed mean %v, variance %v, want 3 and 2", v.SpeedMean, v.SpeedVar)
+	}
+
+	// a vortex: boids on a ring flying counter-clockwise
+	vortex := input(100, one, func(i int) (x, y, vx, vy float64) {
+		a := 2 * math.Pi * float64(i) / 100
+		return 50 + 20*math.Cos(a), 50 + 20*math.Sin(a), -math.Sin(a), math.Cos(a)
+	})
+	v = calc.Compute(0, vortex, 1).Global
+	if v.Polarization > 1e-9 || !near(v.Milling, 1) {
+		t.Errorf("vortex: polarization %v, milling %v", v.Polarization, v.Milling)
+	}
+
+	// random headings
+	random := input(10000, one, func(int) (x, y, vx, vy float64) {
+		a := rng.Float64() * 2 * math.Pi
+		return rng.Float64() * 100, rng.Float64() * 100, math.Cos(a), math.Sin(a)
+	})
+	v = calc.Compute(0, random, 1).Global
+	if v.Polarization > 0.05 || v.Milling > 0.05 {
+		t.Errorf("random: polarization %v, milling %v", v.Polarization, v.Milling)
+	}
+}
+
+func TestSpeciesAndFlocks(t *testing.T) {
+	var calc metrics.Calculator
+	// species 0 flies east, species 1 west
+	in := input(6, func(i int) uint8 { return uint8(i % 2) }, func(i int) (x, y, vx, vy float64) {
+		return float64(i), 0, float64(1 - 2*(i%2)), 0
+	})
+	// species 0: 0-2-4 chained; species 1: 1-3 paired and 5 alone
+	in.Edges = []metrics.Edge{{A: 0, B: 2}, {A: 4, B: 2}, {A: 1, B: 3}}
+	in.Neighbors = []int32{1, 1, 2, 1, 1, 0}
+	in.Nearest = []float64{2, 2, 2, 2, 2, math.Inf(1)}
+
+	fr := calc.Compute(7, in, 3)
+	if fr.Tick != 7 || len(fr.Species) != 3 {
+		t.Fatalf("frame %+v", fr)
+	}
+	s0, s1, g := fr.Species[0], fr.Species[1], fr.Global
+	if s0.Count != 3 || s1.Count != 3 || g.Count != 6 || fr.Species[2].Count != 0 {
+		t.Errorf("counts %d, %d, %d", s0.Count, s1.Count, g.Count)
+	}
+	if !near(s0.Polarization, 1) || !near(s1.Polarization, 1) || g.Polarization > 1e-9 {
+		t.Errorf("polarization %v, %v, global %v", s0.Polarization, s1.Polarization, g.Polarization)
+	}
+	if s0.Flocks != 1 || s1.Flocks != 1 || g.Flocks != 2 {
+		t.Errorf("flocks %d, %d, global %d", s0.Flocks, s1.Flocks, g.Flocks)
+	}
+	if !near(g.MeanNeighbors, 1) || !near(s1.MeanNeighbors, 2.0/3) {
+		t.Errorf("mean neighbors %v, species 1 %v", g.MeanNeighbors, s1.MeanNeighbors)
+	}
+	// the lone boid has no nearest flockmate and is left out
+	if !near(s1.MeanNearest, 2) {
+		t.Errorf("species 1 mean nearest %v, want 2", s1.MeanNearest)
+	}
+}
+
+// chain links the boids in order
+func chain(ids ...int32) []metrics.Edge {
+	var edges []metrics.Edge
+	for i := 1; i < len(ids); i++ {
+		edges = append(edges, metrics.Edge{A: ids[i-1], B: ids[i]})
+	}
+	return edges
+}
+
+func TestFlockTracking(t *testing.T) {
+	var calc metrics.Calculator
+	in := input(9, func(int) uint8 { return 0 }, func(i int) (x, y, vx, vy float64) {
+		return float64(i), 0, 1, 0
+	})
+	steps := []struct {
+		edges  []metrics.Edge
+		flocks []int64
+		events []metrics.Event
+	}{
+		{
+			edges:  append(chain(0, 1, 2, 3, 4, 5), chain(6, 7, 8)...),
+			flocks: []int64{1, 2},
+			events: []metrics.Event{{Kind: metrics.FlockBorn, To: []int64{1}}, {Kind: metrics.FlockBorn, To: []int64{2}}},
+		},
+		{
+			edges:  append(chain(0, 1, 2, 3, 4, 5), chain(6, 7, 8)...),
+			flocks: []int64{1, 2},
+		},
+		{
+			// 1 splits in halves; the one with the smallest boid ID keeps the ID
+			edges:  append(append(chain(0, 1, 2), chain(3, 4, 5)...), chain(6, 7, 8)...),
+			flocks: []int64{1, 2, 3},
+			events: []metrics.Event{{Kind: metrics.FlockSplit, From: []int64{1}, To: []int64{1, 3}}},
+		},
+		{
+			// 3 and 2 join; on equal shares the older ID is kept
+			edges:  append(chain(0, 1, 2), chain(3, 4, 5, 6, 7, 8)...),
+			flocks: []int64{1, 2},
+			events: []metrics.Event{{Kind: metrics.FlockMerged, From: []int64{2, 3}, To: []int64{2}}},
+		},
+		{
+			edges:  chain(3, 4, 5, 6, 7, 8),
+			flocks: []int64{2},
+			events: []metrics.Event{{Kind: metrics.FlockDissolved, From: []int64{1}}},
+		},
+	}
+	for tick, step := range steps {
+		in.Edges = step.edges
+		fr := calc.Compute(int64(tick), in, 1)
+		var ids []int64
+		for _, f := range fr.Flocks {
+			ids = append(ids, f.ID)
+		}
+		if !slices.Equal(ids, step.flocks) {
+			t.Fatalf("tick %d: flocks %v, want %v", tick, ids, step.flocks)
+		}
+		if fr.Global.Flocks != len(step.flocks) {
+			t.Errorf("tick %d: %d flocks counted", tick, fr.Global.Flocks)
+		}
+		for i := range step.events {
+			step.events[i].Tick = int64(tick)
+		}
+		if !reflect.DeepEqual(fr.Events, step.events) {
+			t.Fatalf("tick %d: events %+v, want %+v", tick, fr.Events, step.events)
+		}
+	}
+}
+
+func TestComputeReusesBuffers(t *testing.T) {
+	var calc metrics.Calculator
+	rng := rand.New(rand.NewSource(3)) //nolint:gosec
+	a := input(200, func(i int) uint8 { return uint8(i % 2) }, func(int) (x, y, vx, vy float64) {
+		return rng.Float64() * 100, rng.Float64() * 100, rng.Float64(), rng.Float64()
+	})
+	a.Edges = append(chain(0, 2, 4, 6, 8), chain(1, 3, 5)...)
+	b := a
+	b.Edges = chain(10, 12, 14, 16)
+
+	// a frame stays intact while the next one is computed
+	first := calc.Compute(0, a, 2)
+	kept := first.Clone()
+	calc.Compute(1, b, 2)
+	if !reflect.DeepEqual(first, kept) {
+		t.Fatal("the previous frame changed while computing the next one")
+	}
+
+	// once the buffers are sized, measuring the same flocks again allocates nothing
+	tick := int64(2)
+	if allocs := testing.AllocsPerRun(10, func() {
+		calc.Compute(tick, b, 2)
+		tick++
+	}); allocs != 0 {
+		t.Errorf("Compute allocates %v times per call", allocs)
+	}
+}
+
+func TestFlockBoundsAcrossBorder(t *testing.T) {
+	var calc metrics.Calculator
+	// two boids on either side of the left border of a 100 wide world
+	in := input(2, func(int) uint8 { return 2 }, func(i int) (x, y, vx, vy float64) {
+		return []float64{1, 97}[i], 50, 3, float64(i)
+	})
+	in.Width, in.Height = 100, 100
+	in.Edges = chain(0, 1)
+	fr := calc.Compute(0, in, 3)
+	if len(fr.Flocks) != 1 {
+		t.Fatalf("%d flocks", len(fr.Flocks))
+	}
+	f := fr.Flocks[0]
+	if f.Species != 2 || f.Size != 2 || !near(f.Centroid.X, 99) || !near(f.Centroid.Y, 50) {
+		t.Errorf("flock %+v, want species 2, size 2 around (99, 50)", f)
+	}
+	if !near(f.Min.X, 97) || !near(f.Max.X, 101) || !near(f.Velocity.X, 3) || !near(f.Velocity.Y, 0.5) {
+		t.Errorf("flock %+v, want x bounds 97-101 and velocity (3, 0.5)", f)
+	}
+	if fr.Labels[0] != f.ID || fr.Labels[1] != f.ID || fr.Species[2].Flocks != 1 || fr.Global.MeanFlockSize != 2 {
+		t.Errorf("membership %v", fr.Labels)
+	}
+}
+
+func TestExport(t *testing.T) {
+	fr := &metrics.Frame{
+		Tick:    20,
+		Global:  metrics.Values{Count: 3, Polarization: 0.5, SpeedVar: 4, Flocks: 1},
+		Species: []metrics.Values{{Count: 1}, {Count: 2, Polarization: 0.25}},
+		Events:  []metrics.Event{{Kind: metrics.FlockSplit}, {Kind: metrics.FlockBorn}, {Kind: metrics.FlockSplit}},
+	}
+	fields := []string{"polarization", "speed_sd", "polarization_1", "split"}
+
+	var csv bytes.Buffer
+	e, err := metrics.NewExporter(&csv, metrics.FormatCSV, fields, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err = e.Write(fr, 0.2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "tick,time,polarization,speed_sd,polarization_1,split\n20,0.2,0.5,2,0.25,2\n20,0.2,0.5,2,0.25,2\n"
+	if csv.String() != want {
+		t.Errorf("csv:\n%s\nwant:\n%s", csv.String(), want)
+	}
+
+	var ndjson bytes.Buffer
+	if e, err = metrics.NewExporter(&ndjson, metrics.FormatNDJSON, fields, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Write(fr, 0.2); err != nil {
+		t.Fatal(err)
+	}
+	var row map[string]float64
+	if err = json.Unmarshal(ndjson.Bytes(), &row); err != nil {
+		t.Fatalf("%q: %v", ndjson.String(), err)
+	}
+	wantRow := map[string]float64{"tick": 20, "time": 0.2, "polarization": 0.5, "speed_sd": 2, "polarization_1": 0.25, "split": 2}
+	if !reflect.DeepEqual(row, wantRow) {
+		t.Errorf("ndjson row %v, want %v", row, wantRow)
+	}
+
+	for _, bad := range [][]string{{"polarisation"}, {"polarization_2"}, {"split_1"}, {"milling", "milling"}} {
+		if _, err = metrics.NewExporter(&csv, metrics.FormatCSV, bad, 2); err == nil {
+			t.Errorf("fields %q accepted", bad)
+		}
+	}
+}
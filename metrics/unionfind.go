@@ -0,0 +1,47 @@
+package metrics
+
+// unionFind is a disjoint-set forest over 0..n-1 with union by size and path halving
+type unionFind struct {
+	parent []int32
+	size   []int32
+}
+
+// reset makes every element of 0..n-1 a set of its own
+func (uf *unionFind) reset(n int) {
+	uf.parent = grow(uf.parent, n)
+	uf.size = grow(uf.size, n)
+	for i := range uf.parent {
+		uf.parent[i] = int32(i)
+		uf.size[i] = 1
+	}
+}
+
+// grow returns s resized to n, reusing its storage when possible; reused elements keep their values
+func grow[S ~[]E, E any](s S, n int) S {
+	if cap(s) < n {
+		return make(S, n)
+	}
+	return s[:n]
+}
+
+// root returns the representative of x's set
+func (uf *unionFind) root(x int32) int32 {
+	for uf.parent[x] != x {
+		uf.parent[x] = uf.parent[uf.parent[x]]
+		x = uf.parent[x]
+	}
+	return x
+}
+
+// union merges the sets of a and b
+func (uf *unionFind) union(a, b int32) {
+	ra, rb := uf.root(a), uf.root(b)
+	if ra == rb {
+		return
+	}
+	if uf.size[ra] < uf.size[rb] {
+		ra, rb = rb, ra
+	}
+	uf.parent[rb] = ra
+	uf.size[ra] += uf.size[rb]
+}
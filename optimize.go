@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/optimize"
+)
+
+// runOptimize searches config fields for the values that minimize an objective over the metrics
+// of headless runs, and writes the best configuration found
+func runOptimize(args []string) {
+	fs := flag.NewFlagSet("optimize", flag.ExitOnError)
+	var params []string
+	fs.Func("param", "numeric config field to search and its range, as field=min:max (repeatable)", func(spec string) error {
+		params = append(params, spec)
+		return nil
+	})
+	objective := fs.String("objective", "",
+		"comma-separated terms to minimize, [weight*]metric[=|>=|<= target] or -metric to maximize, "+
+			"e.g. polarization>=0.9,flock_size=50")
+	repeats := fs.Int("repeats", 2, "runs per point, with seeds counting up from the configured one")
+	ticks := fs.Int64("ticks", 2000, "steps per run")
+	warmup := fs.Int64("warmup", 500, "steps before metrics are averaged")
+	maxEvals := fs.Int("max-evals", 100, "number of points to simulate at most")
+	fTol := fs.Float64("ftol", 1e-4, "stop once the objective differs by at most this much across the simplex")
+	xTol := fs.Float64("xtol", 1e-3, "and its points are this close, as a fraction of each range")
+	workers := fs.Int("workers", runtime.NumCPU(), "simulations to run at once")
+	out := fs.String("out", "best.json", "file for the best configuration found")
+	checkpoint := fs.String("checkpoint", "optimize.json", "file the search is saved to after every iteration")
+	resume := fs.Bool("resume", false, "continue the search saved in the checkpoint; its parameters, objective and run settings are kept")
+	_ = fs.Parse(args) // exits on error
+
+	var search *optimize.Search
+	var err error
+	if *resume {
+		search, err = optimize.LoadSearch(*checkpoint)
+		if err == nil && (len(params) > 0 || *objective != "") {
+			log.Print("resuming: -param and -objective are taken from the checkpoint")
+		}
+	} else {
+		search, err = optimize.NewSearch(*config.GetConfig(), params, *objective, *repeats, *ticks, *warmup)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Ctrl-C stops the search; the checkpoint keeps the last complete iteration
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fields := search.Fields()
+	err = search.Run(ctx, *workers, *maxEvals, *fTol, *xTol, func(s *optimize.Search) error {
+		if err := s.Save(*checkpoint); err != nil {
+			return err
+		}
+		best, value, err := s.Best()
+		if err != nil {
+			return err
+		}
+		values := make([]string, len(fields))
+		for i, f := range fields {
+			values[i] = f + "=" + best.Values[i]
+		}
+		log.Printf("iteration %d, %d evaluations: objective %.6g at %s", s.Iterations, s.Evaluations, value, strings.Join(values, " "))
+		return nil
+	})
+	if errors.Is(err, context.Canceled) {
+		log.Printf("stopped; resume with -resume -checkpoint %s", *checkpoint)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	best, value, err := search.Best()
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := json.MarshalIndent(best.Config, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(*out, append(data, '\n'), 0o644); err != nil { //nolint:gosec
+		log.Fatal(err)
+	}
+	fmt.Printf("best objective %.6g after %d evaluations, written to %s\n", value, search.Evaluations, *out)
+}
@@ -0,0 +1,176 @@
+package optimize
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// Nelder–Mead coefficients: reflection, expansion, contraction and shrinking
+const (
+	reflectBy  = 1
+	expandBy   = 2
+	contractBy = 0.5
+	shrinkBy   = 0.5
+)
+
+// Simplex is the state of a Nelder–Mead search in the unit cube: n+1 points in n dimensions and
+// the objective at each, best first after every iteration
+type Simplex struct {
+	Points [][]float64 `json:"points"`
+	Values []float64   `json:"values"`
+}
+
+// evaluator returns the objective at each of a batch of points in the unit cube
+type evaluator func(points [][]float64) ([]float64, error)
+
+// newSimplex starts a search around x0, stepping each coordinate by step in turn
+func newSimplex(x0 []float64, step float64, eval evaluator) (Simplex, error) {
+	points := [][]float64{clamp(x0)}
+	for i := range x0 {
+		p := slices.Clone(x0)
+		// step inward from the upper border
+		if p[i]+step <= 1 {
+			p[i] += step
+		} else {
+			p[i] -= step
+		}
+		points = append(points, clamp(p))
+	}
+	values, err := eval(points)
+	if err != nil {
+		return Simplex{}, err
+	}
+	s := Simplex{Points: points, Values: values}
+	s.sort()
+	return s, nil
+}
+
+// iterate replaces the worst point with a better one along the line through the centroid of the
+// others, or shrinks the simplex toward the best point when there is none
+func (s *Simplex) iterate(eval evaluator) error {
+	n := len(s.Points) - 1
+	best, worst := s.Values[0], s.Values[n]
+	centroid := make([]float64, n)
+	for _, p := range s.Points[:n] {
+		for i, x := range p {
+			centroid[i] += x / float64(n)
+		}
+	}
+	along := func(by float64, from []float64) []float64 {
+		p := make([]float64, n)
+		for i := range p {
+			p[i] = centroid[i] + by*(from[i]-centroid[i])
+		}
+		return clamp(p)
+	}
+	one := func(p []float64) (float64, error) {
+		v, err := eval([][]float64{p})
+		if err != nil {
+			return 0, err
+		}
+		return v[0], nil
+	}
+
+	reflected := along(-reflectBy, s.Points[n])
+	fr, err := one(reflected)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fr < best:
+		expanded := along(-expandBy, s.Points[n])
+		fe, err := one(expanded)
+		if err != nil {
+			return err
+		}
+		if fe < fr {
+			s.replaceWorst(expanded, fe)
+		} else {
+			s.replaceWorst(reflected, fr)
+		}
+	case fr < s.Values[n-1]:
+		s.replaceWorst(reflected, fr)
+	default:
+		// contract toward the better of the worst point and its reflection
+		from, limit := s.Points[n], worst
+		if fr < worst {
+			from, limit = reflected, fr
+		}
+		contracted := along(contractBy, from)
+		fc, err := one(contracted)
+		if err != nil {
+			return err
+		}
+		if fc < limit {
+			s.replaceWorst(contracted, fc)
+		} else if err = s.shrink(eval); err != nil {
+			return err
+		}
+	}
+	s.sort()
+	return nil
+}
+
+// shrink moves every point halfway toward the best one
+func (s *Simplex) shrink(eval evaluator) error {
+	b := s.Points[0]
+	moved := make([][]float64, 0, len(s.Points)-1)
+	for _, p := range s.Points[1:] {
+		q := make([]float64, len(p))
+		for i := range q {
+			q[i] = b[i] + shrinkBy*(p[i]-b[i])
+		}
+		moved = append(moved, q)
+	}
+	values, err := eval(moved)
+	if err != nil {
+		return err
+	}
+	copy(s.Points[1:], moved)
+	copy(s.Values[1:], values)
+	return nil
+}
+
+func (s *Simplex) replaceWorst(p []float64, v float64) {
+	n := len(s.Points) - 1
+	s.Points[n], s.Values[n] = p, v
+}
+
+// sort orders the points from best to worst; ties keep their order
+func (s *Simplex) sort() {
+	order := make([]int, len(s.Points))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(s.Values[a], s.Values[b]) })
+	points, values := make([][]float64, len(order)), make([]float64, len(order))
+	for k, i := range order {
+		points[k], values[k] = s.Points[i], s.Values[i]
+	}
+	s.Points, s.Values = points, values
+}
+
+// converged reports whether the objective differs by at most fTol across the simplex and its
+// points lie within xTol of the best one in every coordinate
+func (s *Simplex) converged(fTol, xTol float64) bool {
+	if s.Values[len(s.Values)-1]-s.Values[0] > fTol {
+		return false
+	}
+	for _, p := range s.Points[1:] {
+		for i, x := range p {
+			if math.Abs(x-s.Points[0][i]) > xTol {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// clamp keeps a point inside the unit cube
+func clamp(p []float64) []float64 {
+	for i, x := range p {
+		p[i] = min(max(x, 0), 1)
+	}
+	return p
+}
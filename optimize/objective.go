@@ -0,0 +1,110 @@
+package optimize
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/sim"
+)
+
+// Op is how a term compares a metric with its target
+type Op string
+
+const (
+	// OpMin lowers the metric, or raises it when the term is negated
+	OpMin Op = ""
+	// OpEqual pulls the metric toward the target
+	OpEqual Op = "="
+	// OpAtLeast and OpAtMost only penalize a metric on the wrong side of the target
+	OpAtLeast Op = ">="
+	OpAtMost  Op = "<="
+)
+
+// Term is one part of an objective
+type Term struct {
+	Weight float64
+	Metric string // named as for metrics.NewExporter
+	Op     Op
+	Target float64
+}
+
+// Objective is a sum of terms to minimize
+type Objective []Term
+
+// ParseObjective parses comma-separated terms of the form [weight*]metric[op target], with op one
+// of =, >= and <=, e.g. "polarization>=0.9,2*flock_size=50". A metric without a target is
+// minimized, and maximized when preceded by a minus sign.
+func ParseObjective(spec string) (Objective, error) {
+	var o Objective
+	for s := range strings.SplitSeq(spec, ",") {
+		s = strings.TrimSpace(s)
+		t := Term{Weight: 1}
+		if w, rest, ok := strings.Cut(s, "*"); ok {
+			var err error
+			if t.Weight, err = strconv.ParseFloat(strings.TrimSpace(w), 64); err != nil {
+				return nil, fmt.Errorf("objective term %q: weight: %w", s, err)
+			}
+			s = strings.TrimSpace(rest)
+		}
+		if i := strings.IndexAny(s, "<>="); i >= 0 {
+			t.Op = OpEqual
+			if strings.HasPrefix(s[i:], string(OpAtLeast)) {
+				t.Op = OpAtLeast
+			} else if strings.HasPrefix(s[i:], string(OpAtMost)) {
+				t.Op = OpAtMost
+			} else if s[i] != '=' {
+				return nil, fmt.Errorf("objective term %q: want =, >= or <=", s)
+			}
+			var err error
+			if t.Target, err = strconv.ParseFloat(strings.TrimSpace(s[i+len(t.Op):]), 64); err != nil {
+				return nil, fmt.Errorf("objective term %q: target: %w", s, err)
+			}
+			s = s[:i]
+		} else if m, ok := strings.CutPrefix(s, "-"); ok {
+			t.Weight, s = -t.Weight, m
+		}
+		t.Metric = strings.TrimSpace(s)
+		if _, err := metrics.Field(t.Metric, len(sim.SpeciesColors)); err != nil {
+			return nil, err
+		}
+		o = append(o, t)
+	}
+	return o, nil
+}
+
+// Fields are the metrics the objective needs, in the order Value expects them
+func (o Objective) Fields() []string {
+	fields := make([]string, len(o))
+	for i, t := range o {
+		fields[i] = t.Metric
+	}
+	return fields
+}
+
+// Value returns the objective for the metrics of its terms. Misses are squared and taken relative
+// to the target, so that terms on different scales weigh alike.
+func (o Objective) Value(values []float64) float64 {
+	var sum float64
+	for i, t := range o {
+		v := values[i]
+		scale := math.Abs(t.Target)
+		if scale == 0 {
+			scale = 1
+		}
+		miss := (v - t.Target) / scale
+		switch t.Op {
+		case OpMin:
+			sum += t.Weight * v
+			continue
+		case OpAtLeast:
+			miss = min(miss, 0)
+		case OpAtMost:
+			miss = max(miss, 0)
+		}
+		sum += t.Weight * miss * miss
+	}
+	return sum
+}
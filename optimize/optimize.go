@@ -0,0 +1,239 @@
+// Package optimize searches configuration values for a flock that behaves as described by an
+// objective over its metrics, using the Nelder–Mead method on headless simulations. Searches
+// can be saved after every iteration and resumed.
+package optimize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/sweep"
+)
+
+const (
+	// searchVersion is the version of the checkpoint layout written by this code
+	searchVersion = 1
+	// initialStep is the size of the starting simplex, as a fraction of each range
+	initialStep = 0.25
+)
+
+// Search is a parameter search and its progress. It is saved as JSON for resuming.
+type Search struct {
+	Version int           `json:"version"`
+	Base    config.Config `json:"base"`
+	// Params are the fields searched, as field=min:max
+	Params []string `json:"params"`
+	// Objective is minimized; its metrics are averaged over the ticks after the warmup and the
+	// repeats, each run with its own seed. Every point uses the same seeds, so that differences
+	// between points come from the parameters alone.
+	Objective string `json:"objective"`
+	Repeats   int    `json:"repeats"`
+	Ticks     int64  `json:"ticks"`
+	Warmup    int64  `json:"warmup"`
+
+	Simplex     Simplex `json:"simplex"` // empty until the search starts
+	Iterations  int     `json:"iterations"`
+	Evaluations int     `json:"evaluations"` // points simulated
+	// Cache holds the objective at the points simulated so far, keyed by their parameter values
+	Cache map[string]float64 `json:"cache"`
+
+	params    []sweep.Param
+	objective Objective
+}
+
+// NewSearch prepares a search over the given fields, each as field=min:max, starting from the
+// middle of the ranges
+func NewSearch(base config.Config, params []string, objective string, repeats int, ticks, warmup int64) (*Search, error) {
+	s := &Search{
+		Version: searchVersion, Base: base, Params: params, Objective: objective,
+		Repeats: repeats, Ticks: ticks, Warmup: warmup,
+	}
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadSearch reads a search saved by Save
+func LoadSearch(path string) (*Search, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Search{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("decode search: %w", err)
+	}
+	if s.Version != searchVersion {
+		return nil, fmt.Errorf("unsupported search version %d", s.Version)
+	}
+	if err = s.init(); err != nil {
+		return nil, err
+	}
+	if n := len(s.Simplex.Points); n != 0 && (n != len(s.params)+1 || len(s.Simplex.Values) != n) {
+		return nil, errors.New("search simplex does not match its parameters")
+	}
+	return s, nil
+}
+
+// init parses the parameters and the objective
+func (s *Search) init() error {
+	if len(s.Params) == 0 {
+		return errors.New("optimize: no parameters to search")
+	}
+	if s.Repeats < 1 || s.Ticks < 1 || s.Warmup < 0 || s.Warmup >= s.Ticks {
+		return errors.New("optimize: need at least one repeat and more ticks than warmup")
+	}
+	s.params = s.params[:0]
+	for _, spec := range s.Params {
+		p, err := sweep.ParseParam(spec)
+		if err != nil {
+			return err
+		}
+		if p.Values != nil || !sweep.Numeric(p.Field) || p.Min >= p.Max {
+			return fmt.Errorf("parameter %q: want a numeric field and a range, field=min:max", spec)
+		}
+		s.params = append(s.params, p)
+	}
+	var err error
+	if s.objective, err = ParseObjective(s.Objective); err != nil {
+		return err
+	}
+	if s.Cache == nil {
+		s.Cache = map[string]float64{}
+	}
+	return nil
+}
+
+// Save writes the search to path, replacing the file only once it is complete
+func (s *Search) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Run iterates until the simplex converges within fTol and xTol (a fraction of each range) or
+// maxEvals points have been simulated, running up to workers simulations at once. after, if not
+// nil, is called after every iteration; an error from it stops the search.
+func (s *Search) Run(ctx context.Context, workers, maxEvals int, fTol, xTol float64, after func(*Search) error) error {
+	eval := func(points [][]float64) ([]float64, error) {
+		return s.evaluate(ctx, points, workers)
+	}
+	if len(s.Simplex.Points) == 0 {
+		x0 := make([]float64, len(s.params))
+		for i := range x0 {
+			x0[i] = 0.5
+		}
+		var err error
+		if s.Simplex, err = newSimplex(x0, initialStep, eval); err != nil {
+			return err
+		}
+		if after != nil {
+			if err = after(s); err != nil {
+				return err
+			}
+		}
+	}
+	for s.Evaluations < maxEvals && !s.Simplex.converged(fTol, xTol) {
+		if err := s.Simplex.iterate(eval); err != nil {
+			return err
+		}
+		s.Iterations++
+		if after != nil {
+			if err := after(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// evaluate returns the objective at points of the unit cube, simulating those not in the cache
+func (s *Search) evaluate(ctx context.Context, points [][]float64, workers int) ([]float64, error) {
+	values := make([]float64, len(points))
+	keys := make([]string, len(points))
+	var todo []sweep.Point
+	var todoKeys []string
+	for i, x := range points {
+		p, err := s.point(x)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = strings.Join(p.Values, ",")
+		if _, ok := s.Cache[keys[i]]; !ok && !slices.Contains(todoKeys, keys[i]) {
+			todo = append(todo, p)
+			todoKeys = append(todoKeys, keys[i])
+		}
+	}
+
+	if len(todo) > 0 {
+		plan := &sweep.Plan{
+			Base: s.Base, Params: s.params, Repeats: s.Repeats, Ticks: s.Ticks, Warmup: s.Warmup,
+			Fields: s.objective.Fields(),
+		}
+		results, err := sweep.RunPoints(ctx, plan, todo, workers, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, r := range results {
+			s.Cache[todoKeys[k]] = s.objective.Value(r.Mean)
+		}
+		s.Evaluations += len(todo)
+	}
+	for i, key := range keys {
+		values[i] = s.Cache[key]
+	}
+	return values, nil
+}
+
+// point maps a point of the unit cube to parameter values and the configuration they give
+func (s *Search) point(x []float64) (sweep.Point, error) {
+	p := sweep.Point{Config: s.Base, Values: make([]string, len(s.params))}
+	for i, param := range s.params {
+		if err := sweep.Set(&p.Config, param.Field, sweep.FormatValue(param.Min+x[i]*(param.Max-param.Min))); err != nil {
+			return sweep.Point{}, err
+		}
+		// integer fields are rounded, so neighboring points may share their values
+		p.Values[i] = sweep.Get(&p.Config, param.Field)
+	}
+	return p, p.Config.Validate()
+}
+
+// Best returns the best point found so far and its objective
+func (s *Search) Best() (sweep.Point, float64, error) {
+	if len(s.Simplex.Points) == 0 {
+		return sweep.Point{}, 0, errors.New("optimize: the search has not started")
+	}
+	p, err := s.point(s.Simplex.Points[0])
+	return p, s.Simplex.Values[0], err
+}
+
+// Fields are the names of the searched fields, in the order of point values
+func (s *Search) Fields() []string {
+	fields := make([]string, len(s.params))
+	for i, p := range s.params {
+		fields[i] = p.Field
+	}
+	return fields
+}
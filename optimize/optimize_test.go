@@ -0,0 +1,129 @@
+package optimize_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/optimize"
+)
+
+func TestObjective(t *testing.T) {
+	o, err := optimize.ParseObjective("polarization>=0.9, 2*flock_size=50, nearest<=3, -milling, speed_sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := optimize.Objective{
+		{Weight: 1, Metric: "polarization", Op: optimize.OpAtLeast, Target: 0.9},
+		{Weight: 2, Metric: "flock_size", Op: optimize.OpEqual, Target: 50},
+		{Weight: 1, Metric: "nearest", Op: optimize.OpAtMost, Target: 3},
+		{Weight: -1, Metric: "milling"},
+		{Weight: 1, Metric: "speed_sd"},
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Fatalf("parsed %+v", o)
+	}
+	if got := o.Fields(); !reflect.DeepEqual(got, []string{"polarization", "flock_size", "nearest", "milling", "speed_sd"}) {
+		t.Errorf("fields %v", got)
+	}
+
+	// met bounds cost nothing, misses are relative to the target
+	if v := o.Value([]float64{0.95, 50, 2, 0, 0}); v != 0 {
+		t.Errorf("objective at the targets %v", v)
+	}
+	if v, want := o.Value([]float64{0.45, 40, 6, 0.5, 3}), 0.25+2*0.04+1-0.5+3; math.Abs(v-want) > 1e-12 {
+		t.Errorf("objective %v, want %v", v, want)
+	}
+
+	for _, spec := range []string{"", "polarisation=1", "polarization=x", "a*milling", "milling<1", "milling=>1"} {
+		if _, err = optimize.ParseObjective(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func testSearch(t *testing.T) *optimize.Search {
+	t.Helper()
+	base := config.Config{
+		Width: 120, Height: 90, BoidsCount: 50, ViewRadius: 7, AdjRate: 0.3,
+		QuadtreeMaxObj: 10, QuadtreeMaxLvl: 5, UpdateRateMs: 10, Seed: 1,
+	}
+	// the boid count is the only field that matters; the objective is exact at 60 boids
+	s, err := optimize.NewSearch(base, []string{"boids_count=20:100", "adj_rate=0.1:0.5"}, "count=60", 2, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSearchFindsTarget(t *testing.T) {
+	s := testSearch(t)
+	if err := s.Run(context.Background(), 2, 100, 1e-9, 1e-3, nil); err != nil {
+		t.Fatal(err)
+	}
+	best, value, err := s.Best()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best.Config.BoidsCount != 60 || value != 0 || best.Values[0] != "60" {
+		t.Errorf("best %v with objective %v after %d evaluations", best.Values, value, s.Evaluations)
+	}
+	if s.Evaluations > 100 || s.Evaluations != len(s.Cache) {
+		t.Errorf("%d evaluations, %d cached", s.Evaluations, len(s.Cache))
+	}
+}
+
+func TestSearchResumes(t *testing.T) {
+	whole := testSearch(t)
+	if err := whole.Run(context.Background(), 2, 30, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// stop after a few iterations, then continue from the checkpoint
+	path := filepath.Join(t.TempDir(), "search.json")
+	part := testSearch(t)
+	stop := context.Canceled
+	err := part.Run(context.Background(), 2, 30, 0, 0, func(s *optimize.Search) error {
+		if err := s.Save(path); err != nil {
+			return err
+		}
+		if s.Iterations == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("search stopped with %v", err)
+	}
+	resumed, err := optimize.LoadSearch(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Iterations != 3 {
+		t.Fatalf("resumed at iteration %d", resumed.Iterations)
+	}
+	if err = resumed.Run(context.Background(), 2, 30, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resumed.Simplex, whole.Simplex) || resumed.Evaluations != whole.Evaluations ||
+		resumed.Iterations != whole.Iterations {
+		t.Errorf("resumed search ended at iteration %d after %d evaluations, uninterrupted at %d after %d",
+			resumed.Iterations, resumed.Evaluations, whole.Iterations, whole.Evaluations)
+	}
+}
+
+func TestNewSearchRejects(t *testing.T) {
+	var base config.Config
+	for _, params := range [][]string{nil, {"neighbor_mode=0:1"}, {"adj_rate=0.1,0.2"}, {"adj_rate=0.5:0.1"}} {
+		if _, err := optimize.NewSearch(base, params, "milling", 1, 10, 0); err == nil {
+			t.Errorf("parameters %q accepted", params)
+		}
+	}
+	if _, err := optimize.NewSearch(base, []string{"adj_rate=0:1"}, "milling", 1, 10, 10); err == nil {
+		t.Error("warmup as long as the run accepted")
+	}
+}
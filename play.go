@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/OutOfStack/boids/sim"
+	"github.com/OutOfStack/boids/trajectory"
+	"github.com/gopxl/pixel/v2"
+	"github.com/gopxl/pixel/v2/backends/opengl"
+	"github.com/gopxl/pixel/v2/ext/imdraw"
+	"golang.org/x/image/colornames"
+)
+
+const (
+	// timelineHeight is the height of the scrubber bar at the bottom of the playback window
+	timelineHeight = 12
+	// playback speed limits, as multiples of real time
+	minPlaySpeed = 1.0 / 16
+	maxPlaySpeed = 16
+)
+
+// runPlay shows a recorded trajectory in the renderer
+func runPlay(args []string) {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: boids play <trajectory>")
+	}
+	_ = fs.Parse(args) // exits on error
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r, err := trajectory.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	if r.Len() == 0 {
+		log.Fatalf("%s holds no frames", fs.Arg(0))
+	}
+	for _, sp := range r.Header().Species {
+		if int(sp) >= len(sim.SpeciesColors) {
+			log.Fatalf("%s: unknown species %d", fs.Arg(0), sp)
+		}
+	}
+	opengl.Run(func() { play(r) })
+}
+
+// playback is the position and motion of the playhead, in frames
+type playback struct {
+	pos     float64
+	frames  int
+	speed   float64 // multiple of real time
+	reverse bool
+	paused  bool
+}
+
+// advance moves the playhead by the frames shown in elapsed time and pauses at either end
+func (p *playback) advance(elapsed, step time.Duration) {
+	if p.paused {
+		return
+	}
+	d := elapsed.Seconds() / step.Seconds() * p.speed
+	if p.reverse {
+		d = -d
+	}
+	p.pos += d
+	last := float64(p.frames - 1)
+	if p.reverse && p.pos <= 0 || !p.reverse && p.pos >= last {
+		p.pos = math.Max(0, math.Min(p.pos, last))
+		p.paused = true
+	}
+}
+
+// stepBy moves the playhead by whole frames and pauses playback
+func (p *playback) stepBy(n int) {
+	p.paused = true
+	p.pos = float64(min(max(p.frame()+n, 0), p.frames-1))
+}
+
+// frame returns the frame under the playhead
+func (p *playback) frame() int {
+	return int(p.pos)
+}
+
+// handles the playback window
+func play(r *trajectory.Reader) {
+	h := r.Header()
+	cfg := &h.Config
+	step := h.Step
+	if step <= 0 {
+		step = 10 * time.Millisecond
+	}
+
+	windowCfg := opengl.WindowConfig{
+		Title:  "Boids playback",
+		Bounds: pixel.R(0, 0, float64(cfg.Width), float64(cfg.Height)),
+		VSync:  true,
+	}
+	win, err := opengl.NewWindow(windowCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rd := newRenderer()
+	pb := &playback{frames: r.Len(), speed: 1}
+	last := time.Now()
+
+	for !win.Closed() {
+		now := time.Now()
+		elapsed := now.Sub(last)
+		last = now
+
+		// Space plays and pauses, R reverses, Up and Down change the speed,
+		// Left and Right step one frame; the overlays toggle as in the live window
+		if win.JustPressed(pixel.KeySpace) {
+			// playing on from the end it stopped at starts over
+			if pb.paused && !pb.reverse && pb.frame() == pb.frames-1 {
+				pb.pos = 0
+			} else if pb.paused && pb.reverse && pb.pos == 0 {
+				pb.pos = float64(pb.frames - 1)
+			}
+			pb.paused = !pb.paused
+		}
+		if win.JustPressed(pixel.KeyR) {
+			pb.reverse = !pb.reverse
+		}
+		if win.JustPressed(pixel.KeyUp) {
+			pb.speed = math.Min(pb.speed*2, maxPlaySpeed)
+		}
+		if win.JustPressed(pixel.KeyDown) {
+			pb.speed = math.Max(pb.speed/2, minPlaySpeed)
+		}
+		if win.JustPressed(pixel.KeyRight) || win.Repeated(pixel.KeyRight) {
+			pb.stepBy(1)
+		}
+		if win.JustPressed(pixel.KeyLeft) || win.Repeated(pixel.KeyLeft) {
+			pb.stepBy(-1)
+		}
+		rd.handleKeys(win)
+		// clicking or dragging on the timeline jumps there
+		if m := win.MousePosition(); win.Pressed(pixel.MouseButtonLeft) && m.Y < timelineHeight {
+			pb.pos = math.Round(m.X / float64(cfg.Width) * float64(pb.frames-1))
+			pb.pos = math.Max(0, math.Min(pb.pos, float64(pb.frames-1)))
+		} else {
+			pb.advance(elapsed, step)
+		}
+
+		f, err := r.Frame(pb.frame())
+		if err != nil {
+			log.Fatal(err)
+		}
+		state := "playing"
+		if pb.paused {
+			state = "paused"
+		} else if pb.reverse {
+			state = "reverse"
+		}
+		win.SetTitle(fmt.Sprintf("Boids playback | tick %d | %s | %gx", f.Tick, state, pb.speed))
+
+		win.Clear(colornames.Black)
+		// the recording keeps neither the index nor the metrics, they are computed from the frame
+		flock := &sim.Flock{X: f.X, Y: f.Y, VX: f.VX, VY: f.VY, ID: h.IDs, Species: h.Species}
+		s := &scene{cfg: cfg, flock: flock, metrics: rd.measure(cfg, f.Tick, flock)}
+		rd.draw(s)
+		drawTimeline(rd.imd, float64(cfg.Width), pb.pos/math.Max(float64(pb.frames-1), 1))
+		rd.present(win, s)
+
+		win.Update()
+	}
+}
+
+// drawTimeline draws the scrubber bar with the played part filled up to progress in [0, 1]
+func drawTimeline(imd *imdraw.IMDraw, width, progress float64) {
+	imd.Color = colornames.Darkslategray
+	imd.Push(pixel.V(0, 0), pixel.V(width, timelineHeight))
+	imd.Rectangle(0)
+	imd.Color = colornames.Lightsteelblue
+	imd.Push(pixel.V(0, 2), pixel.V(width*progress, timelineHeight-2))
+	imd.Rectangle(0)
+}
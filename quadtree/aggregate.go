@@ -0,0 +1,142 @@
+package quadtree
+
+import (
+	"github.com/OutOfStack/boids/vector"
+)
+
+// Aggregate summarizes the objects of one group stored in a subtree
+type Aggregate struct {
+	Count    int
+	Center   vector.Vec2 // centre of mass (mean position)
+	Velocity vector.Vec2 // mean velocity
+}
+
+// ComputeAggregates recomputes the aggregates of every node bottom-up, one per group of objects,
+// and returns the root's. group reports the group of an object, in [0, groups); it may be nil to
+// put all objects in one group. velocity reports the velocity of an object; it may be nil when
+// only counts and centres are needed. Aggregates are not maintained by Insert, Update or Remove,
+// so they must be recomputed after the tree changes.
+func (qt *QuadTree) ComputeAggregates(groups int, group func(*Object) int, velocity func(*Object) vector.Vec2) []Aggregate {
+	if group == nil {
+		groups = 1
+	}
+	qt.group, qt.velocity = group, velocity
+	// position and velocity sums first, turned into means once the children are added
+	if cap(qt.aggs) < groups {
+		qt.aggs = make([]Aggregate, groups)
+	}
+	qt.aggs = qt.aggs[:groups]
+	clear(qt.aggs)
+	for _, obj := range qt.objects {
+		a := &qt.aggs[0]
+		if group != nil {
+			a = &qt.aggs[group(obj)]
+		}
+		a.Count++
+		a.Center = a.Center.Add(obj.Position)
+		if velocity != nil {
+			a.Velocity = a.Velocity.Add(velocity(obj))
+		}
+	}
+
+	if qt.divided {
+		for i := range NumQuadrants {
+			for g, child := range qt.nodes[i].ComputeAggregates(groups, group, velocity) {
+				a := &qt.aggs[g]
+				a.Count += child.Count
+				a.Center = a.Center.Add(child.Center.Scale(float64(child.Count)))
+				a.Velocity = a.Velocity.Add(child.Velocity.Scale(float64(child.Count)))
+			}
+		}
+	}
+
+	for g := range qt.aggs {
+		if n := qt.aggs[g].Count; n > 0 {
+			qt.aggs[g].Center = qt.aggs[g].Center.Scale(1 / float64(n))
+			qt.aggs[g].Velocity = qt.aggs[g].Velocity.Scale(1 / float64(n))
+		}
+	}
+	return qt.aggs
+}
+
+// Aggregate returns the aggregate of a group of this node computed by the last
+// ComputeAggregates call
+func (qt *QuadTree) Aggregate(group int) Aggregate {
+	if group >= len(qt.aggs) {
+		return Aggregate{}
+	}
+	return qt.aggs[group]
+}
+
+// BarnesHutFunc walks the tree Barnes–Hut style and calls fn with aggregates approximating the
+// objects of a group, as assigned by ComputeAggregates, as seen from center, until fn returns
+// false. A node of size s whose centre of mass lies at distance d is reported as a single body
+// when s/d < theta and no part of it lies within radius of center; otherwise it is opened, leaves
+// down to their objects, which are reported as aggregates of one. Every object of the group is
+// covered by exactly one report, and objects within radius, such as one at center itself, are
+// always reported on their own. theta 0 reports every object on its own. With wrap enabled
+// distances are measured on the torus; reported centres stay in tree coordinates.
+// ComputeAggregates must have been called on this node since the tree last changed.
+func (qt *QuadTree) BarnesHutFunc(center vector.Vec2, theta, radius float64, group int, fn func(Aggregate) bool) {
+	w := barnesHutWalk{
+		center: center, theta2: theta * theta, radius2: radius * radius,
+		group: group, groupOf: qt.group, velocity: qt.velocity, fn: fn,
+	}
+	if qt.wrap {
+		w.width, w.height = qt.bounds.Width, qt.bounds.Height
+	}
+	w.visit(qt)
+}
+
+// barnesHutWalk is the state of a BarnesHutFunc call
+type barnesHutWalk struct {
+	center          vector.Vec2
+	theta2, radius2 float64
+	width, height   float64
+	group           int
+	groupOf         func(*Object) int         // nil when all objects are in group 0
+	velocity        func(*Object) vector.Vec2 // nil when velocities are not aggregated
+	fn              func(Aggregate) bool
+}
+
+// visit walks the subtree and reports whether the walk should continue
+func (w *barnesHutWalk) visit(qt *QuadTree) bool {
+	agg := qt.Aggregate(w.group)
+	if agg.Count == 0 {
+		return true
+	}
+
+	// the nearest point of the node, to keep it whole only when all of it is beyond radius
+	gx := axisDist(w.center.X, qt.bounds.X, qt.bounds.X+qt.bounds.Width, w.width)
+	gy := axisDist(w.center.Y, qt.bounds.Y, qt.bounds.Y+qt.bounds.Height, w.height)
+	if gx*gx+gy*gy > w.radius2 {
+		dx := wrapAxis(agg.Center.X-w.center.X, w.width)
+		dy := wrapAxis(agg.Center.Y-w.center.Y, w.height)
+		size := max(qt.bounds.Width, qt.bounds.Height)
+		// s/d < theta, compared squared to avoid the square root
+		if size*size < w.theta2*(dx*dx+dy*dy) {
+			return w.fn(agg)
+		}
+	}
+
+	for _, obj := range qt.objects {
+		if w.groupOf != nil && w.groupOf(obj) != w.group {
+			continue
+		}
+		agg := Aggregate{Count: 1, Center: obj.Position}
+		if w.velocity != nil {
+			agg.Velocity = w.velocity(obj)
+		}
+		if !w.fn(agg) {
+			return false
+		}
+	}
+	if qt.divided {
+		for i := range NumQuadrants {
+			if !w.visit(qt.nodes[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package quadtree
+
+import (
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// mortonLevels is the number of tree levels a Morton code describes: 2 bits per level in a uint64
+const mortonLevels = 32
+
+// Build creates a quadtree holding objects without inserting them one by one. Objects are
+// sorted by Morton (Z-order) code, so the objects of every node form a contiguous run and each
+// node is created exactly once, with no Split cascades. The result is equivalent to calling
+// NewQuadTree(bounds, 0, maxObj, maxLvl) and inserting every object: the same nodes hold the
+// same objects, though objects within a node may be ordered differently.
+func Build(bounds Bounds, maxObj, maxLvl int, objects []*Object) *QuadTree {
+	return build(bounds, maxObj, maxLvl, objects, false)
+}
+
+// BuildParallel is like Build but computes Morton codes on all cores and builds the four
+// top-level quadrants concurrently. It pays off for large object counts.
+func BuildParallel(bounds Bounds, maxObj, maxLvl int, objects []*Object) *QuadTree {
+	return build(bounds, maxObj, maxLvl, objects, true)
+}
+
+func build(bounds Bounds, maxObj, maxLvl int, objects []*Object, parallel bool) *QuadTree {
+	root := NewQuadTree(bounds, 0, maxObj, maxLvl)
+	if len(objects) == 0 {
+		return root
+	}
+	root.leaves = make(map[int64]*QuadTree, len(objects))
+
+	levels := max(min(maxLvl, mortonLevels), 0)
+	objs := slices.Clone(objects)
+	codes := make([]uint64, len(objs))
+	if parallel {
+		workers := runtime.GOMAXPROCS(0)
+		chunk := (len(objs) + workers - 1) / workers
+		var wg sync.WaitGroup
+		for start := 0; start < len(objs); start += chunk {
+			end := min(start+chunk, len(objs))
+			wg.Go(func() {
+				mortonCodes(bounds, levels, objs[start:end], codes[start:end])
+			})
+		}
+		wg.Wait()
+	} else {
+		mortonCodes(bounds, levels, objs, codes)
+	}
+	objs, codes = radixSort(objs, codes, 2*levels)
+
+	if parallel && root.shouldDivide(len(objs)) {
+		root.divide()
+		var wg sync.WaitGroup
+		for i, run := range root.runs(objs, codes, levels) {
+			wg.Go(func() {
+				root.nodes[i].fill(run.objs, run.codes, levels)
+			})
+		}
+		wg.Wait()
+	} else {
+		root.fill(objs, codes, levels)
+	}
+
+	// the ID index is shared by all nodes, so it is filled sequentially once the tree exists
+	root.registerLeaves()
+	return root
+}
+
+// mortonCodes computes the Morton code of each object by descending the quadrants exactly as
+// Insert would route it, so that codes agree with GetIndex even on quadrant boundaries
+func mortonCodes(bounds Bounds, levels int, objs []*Object, codes []uint64) {
+	// quadrant index by [top][right], matching quadrantOf
+	quadrant := [2][2]uint64{{2, 3}, {1, 0}}
+	for i, obj := range objs {
+		p := obj.Position
+		x, y, w, h := bounds.X, bounds.Y, bounds.Width, bounds.Height
+		var code uint64
+		for range levels {
+			// same arithmetic as quadrantOf and quadrantBounds
+			w, h = w/2, h/2
+			right, top := 0, 0
+			if p.X >= x+w {
+				right = 1
+				x += w
+			}
+			if p.Y >= y+h {
+				top = 1
+				y += h
+			}
+			code = code<<2 | quadrant[top][right]
+		}
+		codes[i] = code
+	}
+}
+
+// radixSort stably sorts objects by their codes, which are bits wide, and returns both slices
+func radixSort(objs []*Object, codes []uint64, bits int) ([]*Object, []uint64) {
+	tmpObjs := make([]*Object, len(objs))
+	tmpCodes := make([]uint64, len(codes))
+	for shift := 0; shift < bits; shift += 8 {
+		var counts [257]int
+		for _, c := range codes {
+			counts[(c>>shift)&0xff+1]++
+		}
+		for i := 1; i < len(counts); i++ {
+			counts[i] += counts[i-1]
+		}
+		for i, c := range codes {
+			d := (c >> shift) & 0xff
+			tmpObjs[counts[d]] = objs[i]
+			tmpCodes[counts[d]] = c
+			counts[d]++
+		}
+		objs, tmpObjs = tmpObjs, objs
+		codes, tmpCodes = tmpCodes, codes
+	}
+	return objs, codes
+}
+
+// shouldDivide reports whether a node holding n objects is divided after incremental insertion
+func (qt *QuadTree) shouldDivide(n int) bool {
+	return n > qt.maxObj && qt.level < qt.maxLvl
+}
+
+// divide creates the four children without redistributing any objects
+func (qt *QuadTree) divide() {
+	for i := range NumQuadrants {
+		qt.nodes[i] = qt.newChild(quadrantBounds(qt.bounds, i))
+	}
+	qt.divided = true
+}
+
+// run is a contiguous range of Morton-sorted objects
+type run struct {
+	objs  []*Object
+	codes []uint64
+}
+
+// runs splits this node's sorted objects into the runs belonging to each quadrant
+func (qt *QuadTree) runs(objs []*Object, codes []uint64, levels int) [NumQuadrants]run {
+	if qt.level >= levels {
+		// deeper than Morton codes reach: order by quadrant directly, codes are no longer used
+		sort.SliceStable(objs, func(a, b int) bool {
+			return quadrantOf(qt.bounds, objs[a].Position) < quadrantOf(qt.bounds, objs[b].Position)
+		})
+	}
+
+	var result [NumQuadrants]run
+	start := 0
+	for i := range NumQuadrants {
+		end := start
+		for end < len(objs) && qt.digit(objs[end], codes[end], levels) == i {
+			end++
+		}
+		result[i] = run{objs: objs[start:end], codes: codes[start:end]}
+		start = end
+	}
+	return result
+}
+
+// digit returns the quadrant of this node an object belongs to
+func (qt *QuadTree) digit(obj *Object, code uint64, levels int) int {
+	if qt.level >= levels {
+		return quadrantOf(qt.bounds, obj.Position)
+	}
+	return int((code >> (2 * (levels - 1 - qt.level))) & 3) //nolint:gosec // masked to [0, 3]
+}
+
+// fill builds the subtree of this node from its Morton-sorted objects
+func (qt *QuadTree) fill(objs []*Object, codes []uint64, levels int) {
+	if !qt.shouldDivide(len(objs)) {
+		qt.objects = append(qt.objects, objs...)
+		return
+	}
+	qt.divide()
+	for i, run := range qt.runs(objs, codes, levels) {
+		qt.nodes[i].fill(run.objs, run.codes, levels)
+	}
+}
+
+// registerLeaves records the node holding each object in the shared ID index
+func (qt *QuadTree) registerLeaves() {
+	for _, obj := range qt.objects {
+		qt.leaves[obj.ID] = qt
+	}
+	if qt.divided {
+		for i := range NumQuadrants {
+			qt.nodes[i].registerLeaves()
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package quadtree
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Stats describes the shape of a quadtree
+type Stats struct {
+	Nodes          int // total number of nodes
+	Leaves         int // number of undivided nodes
+	EmptyLeaves    int // leaves holding no objects
+	Objects        int // total number of objects
+	MaxDepth       int // deepest node level relative to this node
+	MaxNodeObjects int // largest number of objects held by a single node
+	MaxLvlLeaves   int // leaves at the maximum level, which can never split
+	// StuckObjects counts objects in leaves at the maximum level that hold more than maxObj
+	// objects: they would split if allowed, and every query reaching them scans them all
+	StuckObjects int
+	// LeafOccupancy is a histogram: LeafOccupancy[n] is the number of leaves holding n objects
+	LeafOccupancy []int
+}
+
+// Stats collects shape statistics of the tree rooted at this node
+func (qt *QuadTree) Stats() Stats {
+	var s Stats
+	qt.Walk(func(node *QuadTree) bool {
+		n := len(node.objects)
+		s.Nodes++
+		s.Objects += n
+		s.MaxDepth = max(s.MaxDepth, node.level-qt.level)
+		s.MaxNodeObjects = max(s.MaxNodeObjects, n)
+		if node.divided {
+			return true
+		}
+
+		s.Leaves++
+		if n == 0 {
+			s.EmptyLeaves++
+		}
+		if node.level >= node.maxLvl {
+			s.MaxLvlLeaves++
+			if n > node.maxObj {
+				s.StuckObjects += n
+			}
+		}
+		for len(s.LeafOccupancy) <= n {
+			s.LeafOccupancy = append(s.LeafOccupancy, 0)
+		}
+		s.LeafOccupancy[n]++
+		return true
+	})
+	return s
+}
+
+// Walk visits this node and its descendants depth-first, parents before children, in quadrant
+// order. Returning false from fn skips the children of the visited node.
+func (qt *QuadTree) Walk(fn func(node *QuadTree) bool) {
+	if !fn(qt) || !qt.divided {
+		return
+	}
+	for i := range NumQuadrants {
+		qt.nodes[i].Walk(fn)
+	}
+}
+
+// Bounds returns the area covered by the node
+func (qt *QuadTree) Bounds() Bounds {
+	return qt.bounds
+}
+
+// Level returns the depth of the node, 0 for a root created with level 0
+func (qt *QuadTree) Level() int {
+	return qt.level
+}
+
+// IsLeaf reports whether the node is undivided
+func (qt *QuadTree) IsLeaf() bool {
+	return !qt.divided
+}
+
+// Objects returns the objects held directly by the node. The slice must not be modified.
+func (qt *QuadTree) Objects() []*Object {
+	return qt.objects
+}
+
+// jsonNode is the JSON representation of a node
+type jsonNode struct {
+	Bounds   jsonBounds   `json:"bounds"`
+	Level    int          `json:"level"`
+	Objects  []jsonObject `json:"objects,omitempty"`
+	Children []*jsonNode  `json:"children,omitempty"`
+}
+
+type jsonBounds struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+type jsonObject struct {
+	ID int64   `json:"id"`
+	X  float64 `json:"x"`
+	Y  float64 `json:"y"`
+}
+
+func (qt *QuadTree) toJSON() *jsonNode {
+	n := &jsonNode{
+		Bounds: jsonBounds{X: qt.bounds.X, Y: qt.bounds.Y, Width: qt.bounds.Width, Height: qt.bounds.Height},
+		Level:  qt.level,
+	}
+	for _, obj := range qt.objects {
+		n.Objects = append(n.Objects, jsonObject{ID: obj.ID, X: obj.Position.X, Y: obj.Position.Y})
+	}
+	if qt.divided {
+		for i := range NumQuadrants {
+			n.Children = append(n.Children, qt.nodes[i].toJSON())
+		}
+	}
+	return n
+}
+
+// WriteJSON writes the tree structure with its objects as nested JSON nodes;
+// children are listed in quadrant order: northeast, northwest, southwest, southeast
+func (qt *QuadTree) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(qt.toJSON())
+}
+
+// quadrantNames labels child edges in DOT output
+var quadrantNames = [NumQuadrants]string{"NE", "NW", "SW", "SE"}
+
+// WriteDOT writes the tree structure as a Graphviz digraph; each node is labeled with its
+// level, object count and bounds
+func (qt *QuadTree) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph quadtree {")
+	fmt.Fprintln(bw, "  node [shape=box, fontsize=10];")
+
+	ids := make(map[*QuadTree]int)
+	qt.Walk(func(node *QuadTree) bool {
+		id := len(ids)
+		ids[node] = id
+		b := node.bounds
+		style := ""
+		if node.divided {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(bw, "  n%d [label=\"L%d n=%d\\n(%.1f, %.1f) %.1fx%.1f\"%s];\n",
+			id, node.level, len(node.objects), b.X, b.Y, b.Width, b.Height, style)
+		if node.parent != nil {
+			if pid, ok := ids[node.parent]; ok {
+				fmt.Fprintf(bw, "  n%d -> n%d [label=%q];\n", pid, id, quadrantNames[node.parent.childIndex(node)])
+			}
+		}
+		return true
+	})
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// childIndex returns the quadrant index of child
+func (qt *QuadTree) childIndex(child *QuadTree) int {
+	for i := range NumQuadrants {
+		if qt.nodes[i] == child {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,187 @@
+package quadtree
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/OutOfStack/boids/vector"
+)
+
+// SetWrap makes circle and nearest-neighbor queries treat the tree bounds as a torus, so that
+// objects near opposite edges are considered close to each other. Objects are then expected
+// to lie within the bounds; ghost copies would be reported twice.
+func (qt *QuadTree) SetWrap(wrap bool) {
+	qt.wrap = wrap
+}
+
+// QueryKNN returns up to k objects nearest to center, ordered by increasing distance.
+// Objects sharing an ID (e.g. wrap-around ghosts) are reported once, by their nearest copy.
+// The search is best-first: nodes are visited in order of their distance to center and
+// the traversal stops as soon as no unvisited node can hold a closer object.
+func (qt *QuadTree) QueryKNN(center vector.Vec2, k int) []*Object {
+	return qt.QueryKNNFunc(center, k, nil)
+}
+
+// QueryKNNFunc is like QueryKNN but only counts objects for which keep returns true; nil keeps all
+func (qt *QuadTree) QueryKNNFunc(center vector.Vec2, k int, keep func(*Object) bool) []*Object {
+	if k <= 0 {
+		return nil
+	}
+
+	var width, height float64
+	if qt.wrap {
+		width, height = qt.bounds.Width, qt.bounds.Height
+	}
+
+	nodes := &nodeQueue{{node: qt, dist2: 0}}
+	best := &knnResult{k: k}
+
+	for nodes.Len() > 0 {
+		item := heap.Pop(nodes).(nodeItem) //nolint:forcetypeassert
+		if best.full() && item.dist2 > best.worst() {
+			break
+		}
+
+		node := item.node
+		for _, obj := range node.objects {
+			if keep != nil && !keep(obj) {
+				continue
+			}
+			dx := wrapAxis(obj.Position.X-center.X, width)
+			dy := wrapAxis(obj.Position.Y-center.Y, height)
+			best.offer(obj, dx*dx+dy*dy)
+		}
+
+		if node.divided {
+			for i := range NumQuadrants {
+				child := node.nodes[i]
+				dx := axisDist(center.X, child.bounds.X, child.bounds.X+child.bounds.Width, width)
+				dy := axisDist(center.Y, child.bounds.Y, child.bounds.Y+child.bounds.Height, height)
+				d2 := dx*dx + dy*dy
+				if best.full() && d2 > best.worst() {
+					continue
+				}
+				heap.Push(nodes, nodeItem{node: child, dist2: d2})
+			}
+		}
+	}
+
+	return best.sorted()
+}
+
+// wrapAxis maps an offset to its shortest equivalent on a periodic axis; period 0 disables wrapping
+func wrapAxis(d, period float64) float64 {
+	if period <= 0 {
+		return d
+	}
+	d = math.Mod(d, period)
+	if d > period/2 {
+		d -= period
+	} else if d < -period/2 {
+		d += period
+	}
+	return d
+}
+
+// axisDist returns the distance from c to the interval [lo, hi], optionally on a periodic axis
+func axisDist(c, lo, hi, period float64) float64 {
+	d := intervalDist(c, lo, hi)
+	if period > 0 {
+		d = min(d, intervalDist(c-period, lo, hi), intervalDist(c+period, lo, hi))
+	}
+	return d
+}
+
+func intervalDist(c, lo, hi float64) float64 {
+	switch {
+	case c < lo:
+		return lo - c
+	case c > hi:
+		return c - hi
+	default:
+		return 0
+	}
+}
+
+// nodeItem is a quadtree node queued for visiting with its squared distance to the query point
+type nodeItem struct {
+	node  *QuadTree
+	dist2 float64
+}
+
+// nodeQueue is a min-heap of nodes by distance
+type nodeQueue []nodeItem
+
+func (q nodeQueue) Len() int           { return len(q) }
+func (q nodeQueue) Less(i, j int) bool { return q[i].dist2 < q[j].dist2 }
+func (q nodeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x any)        { *q = append(*q, x.(nodeItem)) } //nolint:forcetypeassert
+func (q *nodeQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
+
+// objItem is a candidate object with its squared distance to the query point
+type objItem struct {
+	obj   *Object
+	dist2 float64
+}
+
+// knnResult is a bounded max-heap keeping the k closest objects seen so far
+type knnResult struct {
+	k     int
+	items []objItem
+}
+
+func (r *knnResult) Len() int           { return len(r.items) }
+func (r *knnResult) Less(i, j int) bool { return r.items[i].dist2 > r.items[j].dist2 }
+func (r *knnResult) Swap(i, j int)      { r.items[i], r.items[j] = r.items[j], r.items[i] }
+func (r *knnResult) Push(x any)         { r.items = append(r.items, x.(objItem)) } //nolint:forcetypeassert
+func (r *knnResult) Pop() any {
+	n := len(r.items)
+	item := r.items[n-1]
+	r.items = r.items[:n-1]
+	return item
+}
+
+func (r *knnResult) full() bool {
+	return len(r.items) >= r.k
+}
+
+// worst returns the squared distance of the farthest kept object
+func (r *knnResult) worst() float64 {
+	return r.items[0].dist2
+}
+
+// offer considers obj for the result, deduplicating by ID and keeping the closer copy
+func (r *knnResult) offer(obj *Object, dist2 float64) {
+	for i := range r.items {
+		if r.items[i].obj.ID == obj.ID {
+			if dist2 < r.items[i].dist2 {
+				r.items[i] = objItem{obj: obj, dist2: dist2}
+				heap.Fix(r, i)
+			}
+			return
+		}
+	}
+	if !r.full() {
+		heap.Push(r, objItem{obj: obj, dist2: dist2})
+		return
+	}
+	if dist2 < r.worst() {
+		r.items[0] = objItem{obj: obj, dist2: dist2}
+		heap.Fix(r, 0)
+	}
+}
+
+// sorted drains the heap into a slice ordered by increasing distance
+func (r *knnResult) sorted() []*Object {
+	result := make([]*Object, len(r.items))
+	for i := len(r.items) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(r).(objItem).obj //nolint:forcetypeassert
+	}
+	return result
+}
@@ -0,0 +1,242 @@
+package quadtree
+
+import (
+	"github.com/OutOfStack/boids/vector"
+)
+
+// DefaultLooseFactor is the usual loose factor: each node accepts objects extending up to
+// half its size beyond its bounds on every side
+const DefaultLooseFactor = 2.0
+
+// LooseObject is an object with an extent, stored in a LooseQuadTree
+type LooseObject struct {
+	ID int64
+	// Bounds is the axis-aligned extent of the object
+	Bounds Bounds
+	// Radius is set for circular objects, which are inscribed in Bounds; overlap tests then
+	// use the exact circle. 0 means the object is the rectangle Bounds.
+	Radius float64
+}
+
+// NewCircleObject creates a circular object of the given radius around center
+func NewCircleObject(id int64, center vector.Vec2, radius float64) *LooseObject {
+	return &LooseObject{
+		ID:     id,
+		Bounds: Bounds{X: center.X - radius, Y: center.Y - radius, Width: radius * 2, Height: radius * 2},
+		Radius: radius,
+	}
+}
+
+// NewRectObject creates a rectangular object covering bounds
+func NewRectObject(id int64, bounds Bounds) *LooseObject {
+	return &LooseObject{ID: id, Bounds: bounds}
+}
+
+// Center returns the centre of the object's extent
+func (o *LooseObject) Center() vector.Vec2 {
+	return vector.V(o.Bounds.X+o.Bounds.Width/2, o.Bounds.Y+o.Bounds.Height/2)
+}
+
+// intersectsBounds reports whether the object's extent overlaps b
+func (o *LooseObject) intersectsBounds(b *Bounds) bool {
+	if o.Radius > 0 {
+		return circleIntersectsBounds(o.Center(), o.Radius, b)
+	}
+	return o.Bounds.Intersects(b)
+}
+
+// intersectsCircle reports whether the object's extent overlaps the circle
+func (o *LooseObject) intersectsCircle(center vector.Vec2, radius float64) bool {
+	if o.Radius > 0 {
+		d := o.Center().Sub(center)
+		r := o.Radius + radius
+		return d.X*d.X+d.Y*d.Y <= r*r
+	}
+	return circleIntersectsBounds(center, radius, &o.Bounds)
+}
+
+// circleIntersectsBounds reports whether a circle overlaps the closed rectangle b
+func circleIntersectsBounds(center vector.Vec2, radius float64, b *Bounds) bool {
+	dx := intervalDist(center.X, b.X, b.X+b.Width)
+	dy := intervalDist(center.Y, b.Y, b.Y+b.Height)
+	return dx*dx+dy*dy <= radius*radius
+}
+
+// containsBounds reports whether b fully contains other
+func (b *Bounds) containsBounds(other *Bounds) bool {
+	return other.X >= b.X && other.X+other.Width <= b.X+b.Width &&
+		other.Y >= b.Y && other.Y+other.Height <= b.Y+b.Height
+}
+
+// LooseQuadTree is a loose quadtree node for objects with extent. Every node accepts objects
+// within its bounds enlarged by the loose factor, so an object is stored in the smallest node
+// whose loose bounds fully contain it rather than straddling a split line high up the tree.
+// Children are created on demand down to maxLvl.
+type LooseQuadTree struct {
+	bounds  Bounds // tight bounds, used to route objects by their centre
+	loose   Bounds // bounds enlarged by the loose factor, what the node's objects lie within
+	objects []*LooseObject
+	nodes   [4]*LooseQuadTree // nil until an object needs the quadrant
+	parent  *LooseQuadTree
+	level   int
+	maxLvl  int
+	factor  float64
+	// nodesOf maps object IDs to the node holding them; shared by all nodes of a tree
+	nodesOf map[int64]*LooseQuadTree
+}
+
+// NewLooseQuadTree creates a loose quadtree; factor is clamped to at least 1, where the tree
+// degenerates into a regular quadtree storing straddling objects in inner nodes
+func NewLooseQuadTree(bounds Bounds, maxLvl int, factor float64) *LooseQuadTree {
+	factor = max(factor, 1)
+	return &LooseQuadTree{
+		bounds:  bounds,
+		loose:   looseBounds(bounds, factor),
+		maxLvl:  maxLvl,
+		factor:  factor,
+		nodesOf: make(map[int64]*LooseQuadTree),
+	}
+}
+
+// looseBounds enlarges b by factor around its centre
+func looseBounds(b Bounds, factor float64) Bounds {
+	w, h := b.Width*factor, b.Height*factor
+	return Bounds{X: b.X - (w-b.Width)/2, Y: b.Y - (h-b.Height)/2, Width: w, Height: h}
+}
+
+// child returns the node of quadrant idx, creating it if needed
+func (lt *LooseQuadTree) child(idx int) *LooseQuadTree {
+	if lt.nodes[idx] == nil {
+		b := quadrantBounds(lt.bounds, idx)
+		lt.nodes[idx] = &LooseQuadTree{
+			bounds:  b,
+			loose:   looseBounds(b, lt.factor),
+			parent:  lt,
+			level:   lt.level + 1,
+			maxLvl:  lt.maxLvl,
+			factor:  lt.factor,
+			nodesOf: lt.nodesOf,
+		}
+	}
+	return lt.nodes[idx]
+}
+
+// Insert adds an object to the smallest node whose loose bounds fully contain it.
+// Objects that do not fit the root's loose bounds are kept at the root.
+func (lt *LooseQuadTree) Insert(obj *LooseObject) {
+	node := lt
+	for node.level < node.maxLvl {
+		idx := quadrantOf(node.bounds, obj.Center())
+		b := quadrantBounds(node.bounds, idx)
+		lb := looseBounds(b, node.factor)
+		if !lb.containsBounds(&obj.Bounds) {
+			break
+		}
+		node = node.child(idx)
+	}
+	node.objects = append(node.objects, obj)
+	node.nodesOf[obj.ID] = node
+}
+
+// Remove removes the object with the given ID and prunes nodes left empty
+func (lt *LooseQuadTree) Remove(id int64) bool {
+	node, ok := lt.nodesOf[id]
+	if !ok {
+		return false
+	}
+	for i, obj := range node.objects {
+		if obj.ID == id {
+			node.objects[i] = node.objects[len(node.objects)-1]
+			node.objects = node.objects[:len(node.objects)-1]
+			break
+		}
+	}
+	delete(lt.nodesOf, id)
+
+	// drop nodes that hold nothing, bottom-up
+	for node.parent != nil && node.empty() {
+		p := node.parent
+		for i := range NumQuadrants {
+			if p.nodes[i] == node {
+				p.nodes[i] = nil
+			}
+		}
+		node = p
+	}
+	return true
+}
+
+// empty reports whether the node holds no objects and has no children
+func (lt *LooseQuadTree) empty() bool {
+	if len(lt.objects) > 0 {
+		return false
+	}
+	for _, child := range lt.nodes {
+		if child != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of objects in the tree
+func (lt *LooseQuadTree) Len() int {
+	return len(lt.nodesOf)
+}
+
+// Query returns all objects whose extent overlaps the specified range
+func (lt *LooseQuadTree) Query(rang *Bounds) []*LooseObject {
+	result := make([]*LooseObject, 0)
+	lt.QueryFunc(rang, func(obj *LooseObject) bool {
+		result = append(result, obj)
+		return true
+	})
+	return result
+}
+
+// QueryFunc calls fn for each object whose extent overlaps the specified range until fn returns false
+func (lt *LooseQuadTree) QueryFunc(rang *Bounds, fn func(*LooseObject) bool) {
+	lt.visit(func(node *LooseQuadTree) bool {
+		return node.loose.Intersects(rang) || node.parent == nil
+	}, func(obj *LooseObject) bool {
+		return !obj.intersectsBounds(rang) || fn(obj)
+	})
+}
+
+// QueryCircle returns all objects whose extent overlaps a circular range
+func (lt *LooseQuadTree) QueryCircle(center vector.Vec2, radius float64) []*LooseObject {
+	result := make([]*LooseObject, 0)
+	lt.QueryCircleFunc(center, radius, func(obj *LooseObject) bool {
+		result = append(result, obj)
+		return true
+	})
+	return result
+}
+
+// QueryCircleFunc calls fn for each object whose extent overlaps a circular range until fn returns false
+func (lt *LooseQuadTree) QueryCircleFunc(center vector.Vec2, radius float64, fn func(*LooseObject) bool) {
+	lt.visit(func(node *LooseQuadTree) bool {
+		return circleIntersectsBounds(center, radius, &node.loose) || node.parent == nil
+	}, func(obj *LooseObject) bool {
+		return !obj.intersectsCircle(center, radius) || fn(obj)
+	})
+}
+
+// visit walks the nodes accepted by enter and calls fn for their objects until it returns false.
+// The root is always entered since it also keeps objects outside its loose bounds.
+func (lt *LooseQuadTree) visit(enter func(*LooseQuadTree) bool, fn func(*LooseObject) bool) bool {
+	if !enter(lt) {
+		return true
+	}
+	for _, obj := range lt.objects {
+		if !fn(obj) {
+			return false
+		}
+	}
+	for _, child := range lt.nodes {
+		if child != nil && !child.visit(enter, fn) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,9 @@
 package quadtree
 
 import (
-	"github.com/gopxl/pixel/v2"
+	"iter"
+
+	"github.com/OutOfStack/boids/vector"
 )
 
 const (
@@ -18,7 +20,7 @@ type Bounds struct {
 }
 
 // Contains checks if a point is within the bounds
-func (b *Bounds) Contains(p pixel.Vec) bool {
+func (b *Bounds) Contains(p vector.Vec2) bool {
 	return p.X >= b.X && p.X < b.X+b.Width &&
 		p.Y >= b.Y && p.Y < b.Y+b.Height
 }
@@ -34,18 +36,25 @@ func (b *Bounds) Intersects(other *Bounds) bool {
 // Object represents an object in the quadtree
 type Object struct {
 	ID       int64
-	Position pixel.Vec
+	Position vector.Vec2
 }
 
 // QuadTree represents a quadtree node
 type QuadTree struct {
-	bounds  Bounds
-	objects []*Object
-	nodes   [4]*QuadTree
-	level   int
-	divided bool
-	maxObj  int
-	maxLvl  int
+	bounds   Bounds
+	objects  []*Object
+	nodes    [4]*QuadTree
+	parent   *QuadTree
+	level    int
+	divided  bool
+	maxObj   int
+	maxLvl   int
+	wrap     bool                      // distance queries started at this node treat its bounds as a torus
+	aggs     []Aggregate               // summary of the subtree by group, see ComputeAggregates
+	group    func(*Object) int         // grouping of the last ComputeAggregates call on this node
+	velocity func(*Object) vector.Vec2 // velocities of the last ComputeAggregates call on this node
+	// leaves maps object IDs to the node holding them; shared by all nodes of a tree
+	leaves map[int64]*QuadTree
 }
 
 // NewQuadTree creates a new quadtree
@@ -57,11 +66,28 @@ func NewQuadTree(bounds Bounds, level int, maxObj, maxLvl int) *QuadTree {
 		divided: false,
 		maxObj:  maxObj,
 		maxLvl:  maxLvl,
+		leaves:  make(map[int64]*QuadTree),
+	}
+}
+
+// newChild creates a child node sharing this node's ID index
+func (qt *QuadTree) newChild(bounds Bounds) *QuadTree {
+	return &QuadTree{
+		bounds:  bounds,
+		objects: make([]*Object, 0, qt.maxObj),
+		parent:  qt,
+		level:   qt.level + 1,
+		maxObj:  qt.maxObj,
+		maxLvl:  qt.maxLvl,
+		leaves:  qt.leaves,
 	}
 }
 
 // Clear removes all objects from the quadtree
 func (qt *QuadTree) Clear() {
+	for _, obj := range qt.objects {
+		delete(qt.leaves, obj.ID)
+	}
 	qt.objects = make([]*Object, 0, qt.maxObj)
 
 	if qt.divided {
@@ -73,28 +99,39 @@ func (qt *QuadTree) Clear() {
 	}
 }
 
+// quadrantBounds returns the bounds of the given quadrant of b
+func quadrantBounds(b Bounds, idx int) Bounds {
+	subWidth := b.Width / 2
+	subHeight := b.Height / 2
+	x := b.X
+	y := b.Y
+
+	switch idx {
+	case 0: // northeast
+		return Bounds{X: x + subWidth, Y: y + subHeight, Width: subWidth, Height: subHeight}
+	case 1: // northwest
+		return Bounds{X: x, Y: y + subHeight, Width: subWidth, Height: subHeight}
+	case 2: // southwest
+		return Bounds{X: x, Y: y, Width: subWidth, Height: subHeight}
+	default: // southeast
+		return Bounds{X: x + subWidth, Y: y, Width: subWidth, Height: subHeight}
+	}
+}
+
 // Split divides the node into four quadrants
 func (qt *QuadTree) Split() {
-	subWidth := qt.bounds.Width / 2
-	subHeight := qt.bounds.Height / 2
-	x := qt.bounds.X
-	y := qt.bounds.Y
-
 	// create four children nodes
-	qt.nodes[0] = NewQuadTree(Bounds{X: x + subWidth, Y: y + subHeight, Width: subWidth, Height: subHeight}, qt.level+1, qt.maxObj, qt.maxLvl) // northeast
-	qt.nodes[1] = NewQuadTree(Bounds{X: x, Y: y + subHeight, Width: subWidth, Height: subHeight}, qt.level+1, qt.maxObj, qt.maxLvl)            // northwest
-	qt.nodes[2] = NewQuadTree(Bounds{X: x, Y: y, Width: subWidth, Height: subHeight}, qt.level+1, qt.maxObj, qt.maxLvl)                        // southwest
-	qt.nodes[3] = NewQuadTree(Bounds{X: x + subWidth, Y: y, Width: subWidth, Height: subHeight}, qt.level+1, qt.maxObj, qt.maxLvl)             // southeast
+	for i := range NumQuadrants {
+		qt.nodes[i] = qt.newChild(quadrantBounds(qt.bounds, i))
+	}
 
 	qt.divided = true
 
-	// redistribute existing objects to children
+	// redistribute existing objects to children; GetIndex also places objects lying
+	// outside the bounds (e.g. wrap-around ghosts) instead of dropping them
 	for _, obj := range qt.objects {
-		for i := range NumQuadrants {
-			if qt.nodes[i].bounds.Contains(obj.Position) {
-				qt.nodes[i].Insert(obj)
-				break
-			}
+		if idx := qt.GetIndex(obj); idx != -1 {
+			qt.nodes[idx].Insert(obj)
 		}
 	}
 
@@ -104,23 +141,28 @@ func (qt *QuadTree) Split() {
 
 // GetIndex determines which node the object belongs to
 func (qt *QuadTree) GetIndex(obj *Object) int {
+	return quadrantOf(qt.bounds, obj.Position)
+}
+
+// quadrantOf determines which quadrant of b the point p belongs to
+func quadrantOf(b Bounds, p vector.Vec2) int {
 	idx := -1
-	midX := qt.bounds.X + qt.bounds.Width/2
-	midY := qt.bounds.Y + qt.bounds.Height/2
+	midX := b.X + b.Width/2
+	midY := b.Y + b.Height/2
 
 	// object can completely fit within the top quadrants
-	topQuadrant := obj.Position.Y >= midY
+	topQuadrant := p.Y >= midY
 	// object can completely fit within the bottom quadrants
-	bottomQuadrant := obj.Position.Y < midY
+	bottomQuadrant := p.Y < midY
 
 	// object can completely fit within the left quadrants
-	if obj.Position.X < midX {
+	if p.X < midX {
 		if topQuadrant {
 			idx = 1 // northwest
 		} else if bottomQuadrant {
 			idx = 2 // southwest
 		}
-	} else if obj.Position.X >= midX { // object can completely fit within the right quadrants
+	} else if p.X >= midX { // object can completely fit within the right quadrants
 		if topQuadrant {
 			idx = 0 // northeast
 		} else if bottomQuadrant {
@@ -144,6 +186,7 @@ func (qt *QuadTree) Insert(obj *Object) {
 
 	// add the object to this node
 	qt.objects = append(qt.objects, obj)
+	qt.leaves[obj.ID] = qt
 
 	// check if we need to split the node
 	if len(qt.objects) > qt.maxObj && qt.level < qt.maxLvl {
@@ -169,93 +212,208 @@ func (qt *QuadTree) Insert(obj *Object) {
 	}
 }
 
-// Update updates an object's position in the quadtree
-func (qt *QuadTree) Update(id int64, newPosition pixel.Vec) {
-	// remove the object
-	qt.Remove(id)
+// Update moves an object to a new position, inserting it if the ID is unknown.
+// Objects are located through the ID index, so the cost does not depend on the tree size:
+// an object that stays within its node is moved in place, otherwise it is reinserted from
+// the nearest ancestor containing the new position and emptied nodes are merged.
+// IDs are expected to be unique; with duplicates only the last inserted copy is tracked.
+func (qt *QuadTree) Update(id int64, newPosition vector.Vec2) {
+	node, ok := qt.leaves[id]
+	if !ok {
+		qt.Insert(&Object{
+			ID:       id,
+			Position: newPosition,
+		})
+		return
+	}
 
-	// insert it with the new position
-	qt.Insert(&Object{
-		ID:       id,
-		Position: newPosition,
-	})
+	i := node.indexOf(id)
+	obj := node.objects[i]
+	if node.bounds.Contains(newPosition) {
+		obj.Position = newPosition
+		return
+	}
+
+	node = node.removeAt(i)
+	obj.Position = newPosition
+	for node.parent != nil && !node.bounds.Contains(newPosition) {
+		node = node.parent
+	}
+	node.Insert(obj)
 }
 
 // Remove removes an object from the quadtree
 func (qt *QuadTree) Remove(id int64) bool {
-	// check if the object is in this node
+	node, ok := qt.leaves[id]
+	if !ok {
+		return false
+	}
+	node.removeAt(node.indexOf(id))
+	return true
+}
+
+// indexOf returns the position of the object with the given ID in this node
+func (qt *QuadTree) indexOf(id int64) int {
 	for i, obj := range qt.objects {
 		if obj.ID == id {
-			// remove the object (swap with last element and truncate)
-			qt.objects[i] = qt.objects[len(qt.objects)-1]
-			qt.objects = qt.objects[:len(qt.objects)-1]
-			return true
+			return i
 		}
 	}
+	return -1
+}
 
-	// if this node is divided, check the children
-	if qt.divided {
-		for i := range NumQuadrants {
-			if qt.nodes[i].Remove(id) {
-				return true
-			}
+// removeAt removes the i-th object of this node, merges ancestors that became underfull
+// and returns the node now covering this node's area
+func (qt *QuadTree) removeAt(i int) *QuadTree {
+	delete(qt.leaves, qt.objects[i].ID)
+	// remove the object (swap with last element and truncate)
+	qt.objects[i] = qt.objects[len(qt.objects)-1]
+	qt.objects = qt.objects[:len(qt.objects)-1]
+
+	node := qt
+	for node.parent != nil && node.parent.canMerge() {
+		node = node.parent
+		node.merge()
+	}
+	return node
+}
+
+// canMerge reports whether all children are leaves holding no more objects than a single node may
+func (qt *QuadTree) canMerge() bool {
+	if !qt.divided {
+		return false
+	}
+	total := len(qt.objects)
+	for _, child := range qt.nodes {
+		if child.divided {
+			return false
 		}
+		total += len(child.objects)
 	}
+	return total <= qt.maxObj
+}
 
-	return false
+// merge pulls the children's objects into this node and drops the children
+func (qt *QuadTree) merge() {
+	for i, child := range qt.nodes {
+		for _, obj := range child.objects {
+			qt.objects = append(qt.objects, obj)
+			qt.leaves[obj.ID] = qt
+		}
+		qt.nodes[i] = nil
+	}
+	qt.divided = false
 }
 
 // Query returns all objects in the specified range
 func (qt *QuadTree) Query(rang *Bounds) []*Object {
-	result := make([]*Object, 0)
+	return qt.AppendQuery(make([]*Object, 0), rang)
+}
+
+// AppendQuery appends all objects in the specified range to dst and returns the extended slice
+func (qt *QuadTree) AppendQuery(dst []*Object, rang *Bounds) []*Object {
+	qt.QueryFunc(rang, func(obj *Object) bool {
+		dst = append(dst, obj)
+		return true
+	})
+	return dst
+}
 
-	// if the range doesn't intersect this node, return empty
+// QueryFunc calls fn for each object in the specified range until fn returns false.
+// It performs no allocations of its own.
+func (qt *QuadTree) QueryFunc(rang *Bounds, fn func(*Object) bool) {
+	qt.queryFunc(rang, fn)
+}
+
+// queryFunc visits objects in range and reports whether the traversal should continue
+func (qt *QuadTree) queryFunc(rang *Bounds, fn func(*Object) bool) bool {
+	// if the range doesn't intersect this node, there is nothing to visit
 	if !qt.bounds.Intersects(rang) {
-		return result
+		return true
 	}
 
-	// add objects from this node that are within the range
+	// visit objects from this node that are within the range
 	for _, obj := range qt.objects {
-		if rang.Contains(obj.Position) {
-			result = append(result, obj)
+		if rang.Contains(obj.Position) && !fn(obj) {
+			return false
 		}
 	}
 
-	// if this node is divided, query the children
+	// if this node is divided, visit the children
 	if qt.divided {
 		for i := range NumQuadrants {
-			childResults := qt.nodes[i].Query(rang)
-			result = append(result, childResults...)
+			if !qt.nodes[i].queryFunc(rang, fn) {
+				return false
+			}
 		}
 	}
 
-	return result
+	return true
 }
 
 // QueryCircle returns all objects within a circular range
-func (qt *QuadTree) QueryCircle(center pixel.Vec, radius float64) []*Object {
+func (qt *QuadTree) QueryCircle(center vector.Vec2, radius float64) []*Object {
+	return qt.AppendQueryCircle(make([]*Object, 0), center, radius)
+}
+
+// AppendQueryCircle appends all objects within a circular range to dst and returns the extended slice
+func (qt *QuadTree) AppendQueryCircle(dst []*Object, center vector.Vec2, radius float64) []*Object {
+	qt.QueryCircleFunc(center, radius, func(obj *Object) bool {
+		dst = append(dst, obj)
+		return true
+	})
+	return dst
+}
+
+// QueryCircleSeq returns an iterator over all objects within a circular range
+func (qt *QuadTree) QueryCircleSeq(center vector.Vec2, radius float64) iter.Seq[*Object] {
+	return func(yield func(*Object) bool) {
+		qt.QueryCircleFunc(center, radius, yield)
+	}
+}
+
+// QueryCircleFunc calls fn for each object within a circular range until fn returns false.
+// With wrap enabled the circle also covers its periodic images across the bounds
+// (radius must stay below half the bounds size). It performs no allocations of its own.
+func (qt *QuadTree) QueryCircleFunc(center vector.Vec2, radius float64, fn func(*Object) bool) {
+	if !qt.wrap {
+		qt.queryCircle(center, radius, fn)
+		return
+	}
+
+	// query each periodic image of the circle that overlaps the bounds
+	w, h := qt.bounds.Width, qt.bounds.Height
+	for _, sy := range [3]float64{0, -h, h} {
+		for _, sx := range [3]float64{0, -w, w} {
+			c := vector.V(center.X+sx, center.Y+sy)
+			if (sx != 0 || sy != 0) && !qt.bounds.Intersects(&Bounds{X: c.X - radius, Y: c.Y - radius, Width: radius * 2, Height: radius * 2}) {
+				continue
+			}
+			if !qt.queryCircle(c, radius, fn) {
+				return
+			}
+		}
+	}
+}
+
+// queryCircle visits objects within a circular range and reports whether the traversal should continue
+func (qt *QuadTree) queryCircle(center vector.Vec2, radius float64, fn func(*Object) bool) bool {
 	// create a bounding box for the circle
-	rang := &Bounds{
+	rang := Bounds{
 		X:      center.X - radius,
 		Y:      center.Y - radius,
 		Width:  radius * 2,
 		Height: radius * 2,
 	}
+	r2 := radius * radius
 
-	// get all objects in the bounding box
-	candidates := qt.Query(rang)
-	result := make([]*Object, 0, len(candidates))
-
-	// filter objects by distance
-	for _, obj := range candidates {
+	// visit objects in the bounding box, filtering them by distance
+	return qt.queryFunc(&rang, func(obj *Object) bool {
 		dx := obj.Position.X - center.X
 		dy := obj.Position.Y - center.Y
-		distSquared := dx*dx + dy*dy
-
-		if distSquared <= radius*radius {
-			result = append(result, obj)
+		if dx*dx+dy*dy <= r2 {
+			return fn(obj)
 		}
-	}
-
-	return result
+		return true
+	})
 }
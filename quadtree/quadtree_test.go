@@ -1,18 +1,25 @@
 package quadtree_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/OutOfStack/boids/quadtree"
-	"github.com/gopxl/pixel/v2"
+	"github.com/OutOfStack/boids/vector"
 )
 
 func TestBounds(t *testing.T) {
 	b := &quadtree.Bounds{X: 0, Y: 0, Width: 10, Height: 10}
-	if !b.Contains(pixel.V(5, 5)) {
+	if !b.Contains(vector.V(5, 5)) {
 		t.Fatal("Contains should be true")
 	}
-	if b.Contains(pixel.V(10, 10)) { // edge is exclusive on high side
+	if b.Contains(vector.V(10, 10)) { // edge is exclusive on high side
 		t.Fatal("Contains should be false on high edge")
 	}
 	c := &quadtree.Bounds{X: 8, Y: 8, Width: 5, Height: 5}
@@ -25,7 +32,7 @@ func TestInsertSplitQuery(t *testing.T) {
 	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 4, 5)
 	// insert many objects to trigger split
 	for i := range 50 {
-		qt.Insert(&quadtree.Object{ID: int64(i), Position: pixel.V(float64(i*2), float64(i*2))})
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: vector.V(float64(i*2), float64(i*2))})
 	}
 	// query a small range
 	r := &quadtree.Bounds{X: 0, Y: 0, Width: 10, Height: 10}
@@ -34,7 +41,7 @@ func TestInsertSplitQuery(t *testing.T) {
 		t.Fatal("expected some results in query")
 	}
 	// circle query around (0,0)
-	cres := qt.QueryCircle(pixel.V(0, 0), 5)
+	cres := qt.QueryCircle(vector.V(0, 0), 5)
 	if len(cres) == 0 {
 		t.Fatal("expected circle query results")
 	}
@@ -42,7 +49,7 @@ func TestInsertSplitQuery(t *testing.T) {
 
 func TestRemove(t *testing.T) {
 	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 10, Height: 10}, 0, 4, 5)
-	qt.Insert(&quadtree.Object{ID: 1, Position: pixel.V(5, 5)})
+	qt.Insert(&quadtree.Object{ID: 1, Position: vector.V(5, 5)})
 	if !qt.Remove(1) {
 		t.Fatal("expected remove to return true")
 	}
@@ -50,3 +57,759 @@ func TestRemove(t *testing.T) {
 		t.Fatal("expected remove to return false when absent")
 	}
 }
+
+func TestQueryKNN(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 4, 6)
+	rng := rand.New(rand.NewSource(42)) //nolint:gosec
+	points := make([]vector.Vec2, 300)
+	for i := range points {
+		points[i] = vector.V(rng.Float64()*100, rng.Float64()*100)
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: points[i]})
+	}
+
+	center := vector.V(37, 61)
+	const k = 7
+	res := qt.QueryKNN(center, k)
+	if len(res) != k {
+		t.Fatalf("expected %d results, got %d", k, len(res))
+	}
+
+	// brute-force reference
+	ids := make([]int, len(points))
+	for i := range ids {
+		ids[i] = i
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return points[ids[a]].Sub(center).Len() < points[ids[b]].Sub(center).Len()
+	})
+	for i, obj := range res {
+		if obj.ID != int64(ids[i]) {
+			t.Fatalf("result %d: got ID %d, want %d", i, obj.ID, ids[i])
+		}
+	}
+
+	if got := qt.QueryKNN(center, 1000); len(got) != len(points) {
+		t.Fatalf("expected all %d objects when k exceeds count, got %d", len(points), len(got))
+	}
+	if got := qt.QueryKNN(center, 0); len(got) != 0 {
+		t.Fatal("expected no results for k=0")
+	}
+}
+
+func TestQueryKNNWrap(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 2, 5)
+	qt.Insert(&quadtree.Object{ID: 1, Position: vector.V(99, 50)}) // 2 units away across the left edge
+	qt.Insert(&quadtree.Object{ID: 2, Position: vector.V(5, 50)})  // 4 units away
+	qt.Insert(&quadtree.Object{ID: 3, Position: vector.V(50, 50)})
+	qt.Insert(&quadtree.Object{ID: 4, Position: vector.V(60, 50)})
+
+	center := vector.V(1, 50)
+	if res := qt.QueryKNN(center, 1); res[0].ID != 2 {
+		t.Fatalf("without wrap expected ID 2, got %d", res[0].ID)
+	}
+
+	qt.SetWrap(true)
+	res := qt.QueryKNN(center, 2)
+	if res[0].ID != 1 || res[1].ID != 2 {
+		t.Fatalf("with wrap expected IDs [1 2], got [%d %d]", res[0].ID, res[1].ID)
+	}
+}
+
+func TestQueryKNNDedupesGhosts(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 4, 5)
+	qt.Insert(&quadtree.Object{ID: 1, Position: vector.V(98, 50)})
+	qt.Insert(&quadtree.Object{ID: 1, Position: vector.V(-2, 50)}) // ghost of ID 1
+	qt.Insert(&quadtree.Object{ID: 2, Position: vector.V(10, 50)})
+
+	res := qt.QueryKNN(vector.V(1, 50), 2)
+	if len(res) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(res))
+	}
+	if res[0].ID != 1 || res[0].Position.X != -2 {
+		t.Fatalf("expected nearest copy of ID 1 first, got ID %d at %v", res[0].ID, res[0].Position)
+	}
+	if res[1].ID != 2 {
+		t.Fatalf("expected ID 2 second, got %d", res[1].ID)
+	}
+}
+
+func TestQueryCircleVariants(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 4, 6)
+	rng := rand.New(rand.NewSource(7)) //nolint:gosec
+	for i := range 500 {
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: vector.V(rng.Float64()*100, rng.Float64()*100)})
+	}
+	center, radius := vector.V(40, 40), 15.0
+
+	want := qt.QueryCircle(center, radius)
+	if len(want) == 0 {
+		t.Fatal("expected circle query results")
+	}
+
+	buf := make([]*quadtree.Object, 0, 8)
+	buf = qt.AppendQueryCircle(buf[:0], center, radius)
+	var fromFunc []*quadtree.Object
+	qt.QueryCircleFunc(center, radius, func(obj *quadtree.Object) bool {
+		fromFunc = append(fromFunc, obj)
+		return true
+	})
+	var fromSeq []*quadtree.Object
+	for obj := range qt.QueryCircleSeq(center, radius) {
+		fromSeq = append(fromSeq, obj)
+	}
+
+	for name, got := range map[string][]*quadtree.Object{"append": buf, "func": fromFunc, "seq": fromSeq} {
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d objects, want %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: object %d differs", name, i)
+			}
+		}
+	}
+}
+
+func TestQueryCircleFuncEarlyExit(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 2, 5)
+	for i := range 20 {
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: vector.V(50+float64(i)/10, 50)})
+	}
+
+	visited := 0
+	qt.QueryCircleFunc(vector.V(50, 50), 10, func(*quadtree.Object) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Fatalf("expected traversal to stop after 3 objects, visited %d", visited)
+	}
+
+	visited = 0
+	for range qt.QueryCircleSeq(vector.V(50, 50), 10) {
+		visited++
+		if visited == 5 {
+			break
+		}
+	}
+	if visited != 5 {
+		t.Fatalf("expected iteration to stop after 5 objects, visited %d", visited)
+	}
+}
+
+// boidWorkload builds a tree shaped like the default simulation: 2000 boids in an 800x600 world
+func boidWorkload() (*quadtree.QuadTree, []vector.Vec2) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 800, Height: 600}, 0, 10, 5)
+	rng := rand.New(rand.NewSource(1)) //nolint:gosec
+	points := make([]vector.Vec2, 2000)
+	for i := range points {
+		points[i] = vector.V(rng.Float64()*800, rng.Float64()*600)
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: points[i]})
+	}
+	return qt, points
+}
+
+func BenchmarkQueryCircle(b *testing.B) {
+	qt, points := boidWorkload()
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, p := range points {
+			_ = qt.QueryCircle(p, 7)
+		}
+	}
+}
+
+func BenchmarkAppendQueryCircle(b *testing.B) {
+	qt, points := boidWorkload()
+	buf := make([]*quadtree.Object, 0, 64)
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, p := range points {
+			buf = qt.AppendQueryCircle(buf[:0], p, 7)
+		}
+	}
+}
+
+func BenchmarkQueryCircleFunc(b *testing.B) {
+	qt, points := boidWorkload()
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, p := range points {
+			n := 0
+			qt.QueryCircleFunc(p, 7, func(*quadtree.Object) bool {
+				n++
+				return true
+			})
+		}
+	}
+}
+
+func BenchmarkQueryCircleSeq(b *testing.B) {
+	qt, points := boidWorkload()
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, p := range points {
+			n := 0
+			for range qt.QueryCircleSeq(p, 7) {
+				n++
+			}
+		}
+	}
+}
+
+func TestSplitKeepsOutOfBoundsObjects(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 2, 5)
+	qt.Insert(&quadtree.Object{ID: 1, Position: vector.V(-3, 50)}) // ghost left of the bounds
+	for i := range 10 {
+		qt.Insert(&quadtree.Object{ID: int64(i + 2), Position: vector.V(float64(i*10+5), 50)})
+	}
+
+	s := qt.Stats()
+	if s.Objects != 11 {
+		t.Fatalf("expected 11 objects after splits, got %d", s.Objects)
+	}
+	if s.Nodes <= 1 || s.Leaves == 0 || s.MaxDepth == 0 {
+		t.Fatalf("expected a divided tree, got %+v", s)
+	}
+	if res := qt.QueryCircle(vector.V(0, 50), 4); len(res) != 1 || res[0].ID != 1 {
+		t.Fatalf("expected ghost to be found, got %d results", len(res))
+	}
+}
+
+func TestUpdateIncremental(t *testing.T) {
+	const n = 300
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 4, 6)
+	rng := rand.New(rand.NewSource(11)) //nolint:gosec
+	points := make([]vector.Vec2, n)
+	for i := range points {
+		points[i] = vector.V(rng.Float64()*100, rng.Float64()*100)
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: points[i]})
+	}
+	spread := qt.Stats()
+
+	for round := range 30 {
+		for i := range points {
+			// mostly small steps that stay within a leaf, sometimes a jump across the world
+			step := 1.0
+			if rng.Intn(10) == 0 {
+				step = 100
+			}
+			p := points[i].Add(vector.V((rng.Float64()*2-1)*step, (rng.Float64()*2-1)*step))
+			p.X = math.Min(math.Max(p.X, 0), 99.999)
+			p.Y = math.Min(math.Max(p.Y, 0), 99.999)
+			points[i] = p
+			qt.Update(int64(i), p)
+		}
+
+		if s := qt.Stats(); s.Objects != n {
+			t.Fatalf("round %d: expected %d objects, got %d", round, n, s.Objects)
+		}
+		center := vector.V(rng.Float64()*100, rng.Float64()*100)
+		want := 0
+		for _, p := range points {
+			if p.Sub(center).Len() <= 12 {
+				want++
+			}
+		}
+		got := qt.QueryCircle(center, 12)
+		if len(got) != want {
+			t.Fatalf("round %d: circle query returned %d objects, want %d", round, len(got), want)
+		}
+		for _, obj := range got {
+			if obj.Position != points[obj.ID] {
+				t.Fatalf("round %d: object %d at %v, want %v", round, obj.ID, obj.Position, points[obj.ID])
+			}
+		}
+	}
+
+	// gather everything into one corner: emptied subtrees must be merged away
+	for i := range points {
+		qt.Update(int64(i), vector.V(1+float64(i%10)/10, 1+float64(i/10)/100))
+	}
+	if s := qt.Stats(); s.Nodes >= spread.Nodes || s.Objects != n {
+		t.Fatalf("expected merged tree smaller than %d nodes with %d objects, got %+v", spread.Nodes, n, s)
+	}
+
+	// unknown IDs are inserted
+	qt.Update(n, vector.V(50, 50))
+	if s := qt.Stats(); s.Objects != n+1 {
+		t.Fatalf("expected update of unknown ID to insert, got %d objects", s.Objects)
+	}
+
+	// removing everything collapses the tree to its root
+	for i := range n + 1 {
+		if !qt.Remove(int64(i)) {
+			t.Fatalf("expected remove of %d to succeed", i)
+		}
+	}
+	if s := qt.Stats(); s.Nodes != 1 || s.Objects != 0 {
+		t.Fatalf("expected empty root after removing all objects, got %+v", s)
+	}
+}
+
+func TestQueryCircleWrap(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 2, 5)
+	qt.Insert(&quadtree.Object{ID: 1, Position: vector.V(98, 99)}) // diagonal neighbor across the corner
+	qt.Insert(&quadtree.Object{ID: 2, Position: vector.V(50, 50)})
+	qt.Insert(&quadtree.Object{ID: 3, Position: vector.V(3, 60)})
+
+	if res := qt.QueryCircle(vector.V(1, 1), 5); len(res) != 0 {
+		t.Fatalf("without wrap expected no results, got %d", len(res))
+	}
+	qt.SetWrap(true)
+	res := qt.QueryCircle(vector.V(1, 1), 5)
+	if len(res) != 1 || res[0].ID != 1 {
+		t.Fatalf("with wrap expected only ID 1, got %d results", len(res))
+	}
+}
+
+// BenchmarkRebuild measures keeping the tree current by building it from scratch each tick
+func BenchmarkRebuild(b *testing.B) {
+	_, points := boidWorkload()
+	rng := rand.New(rand.NewSource(2)) //nolint:gosec
+	b.ReportAllocs()
+	for b.Loop() {
+		jitter(points, rng)
+		qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 800, Height: 600}, 0, 10, 5)
+		for i, p := range points {
+			qt.Insert(&quadtree.Object{ID: int64(i), Position: p})
+		}
+	}
+}
+
+// BenchmarkIncrementalUpdate measures keeping the tree current by moving each boid in place
+func BenchmarkIncrementalUpdate(b *testing.B) {
+	qt, points := boidWorkload()
+	rng := rand.New(rand.NewSource(2)) //nolint:gosec
+	b.ReportAllocs()
+	for b.Loop() {
+		jitter(points, rng)
+		for i, p := range points {
+			qt.Update(int64(i), p)
+		}
+	}
+}
+
+// jitter moves points by up to one unit per axis, like a simulation tick
+func jitter(points []vector.Vec2, rng *rand.Rand) {
+	for i, p := range points {
+		p = p.Add(vector.V(rng.Float64()*2-1, rng.Float64()*2-1))
+		points[i] = vector.V(math.Mod(p.X+800, 800), math.Mod(p.Y+600, 600))
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 4, 6)
+	rng := rand.New(rand.NewSource(5)) //nolint:gosec
+	const n, groups = 400, 3
+	var posSum, velSum [groups]vector.Vec2
+	positions := make([]vector.Vec2, n)
+	velocities := make([]vector.Vec2, n)
+	for i := range n {
+		positions[i] = vector.V(rng.Float64()*100, rng.Float64()*100)
+		velocities[i] = vector.V(rng.Float64()*2-1, rng.Float64()*2-1)
+		posSum[i%groups] = posSum[i%groups].Add(positions[i])
+		velSum[i%groups] = velSum[i%groups].Add(velocities[i])
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: positions[i]})
+	}
+
+	root := qt.ComputeAggregates(groups, func(obj *quadtree.Object) int {
+		return int(obj.ID % groups)
+	}, func(obj *quadtree.Object) vector.Vec2 {
+		return velocities[obj.ID]
+	})
+	for g := range groups {
+		if root[g] != qt.Aggregate(g) {
+			t.Fatal("expected returned aggregates to be stored on the root")
+		}
+		want := (n + groups - 1 - g) / groups
+		if root[g].Count != want {
+			t.Fatalf("group %d: expected count %d, got %d", g, want, root[g].Count)
+		}
+		if d := root[g].Center.Sub(posSum[g].Scale(1 / float64(want))).Len(); d > 1e-9 {
+			t.Fatalf("group %d: centre of mass off by %f", g, d)
+		}
+		if d := root[g].Velocity.Sub(velSum[g].Scale(1 / float64(want))).Len(); d > 1e-9 {
+			t.Fatalf("group %d: mean velocity off by %f", g, d)
+		}
+	}
+
+	// every object of the group must be covered by exactly one reported aggregate, whatever theta,
+	// and objects within the radius must be reported on their own
+	center, radius := vector.V(10, 90), 15.0
+	near := 0
+	for i, p := range positions {
+		if i%groups == 1 && p.Dist(center) <= radius {
+			near++
+		}
+	}
+	reports := make(map[float64]int)
+	for _, theta := range []float64{0, 0.5, 1, 5} {
+		count, singles, massX, momentum := 0, 0, 0.0, vector.Vec2{}
+		qt.BarnesHutFunc(center, theta, radius, 1, func(agg quadtree.Aggregate) bool {
+			count += agg.Count
+			massX += agg.Center.X * float64(agg.Count)
+			momentum = momentum.Add(agg.Velocity.Scale(float64(agg.Count)))
+			if agg.Count == 1 && agg.Center.Dist(center) <= radius {
+				singles++
+			}
+			reports[theta]++
+			return true
+		})
+		if count != root[1].Count {
+			t.Fatalf("theta %.1f: aggregates cover %d objects, want %d", theta, count, root[1].Count)
+		}
+		if math.Abs(massX-posSum[1].X) > 1e-6 {
+			t.Fatalf("theta %.1f: aggregated mass moment %f, want %f", theta, massX, posSum[1].X)
+		}
+		if d := momentum.Sub(velSum[1]).Len(); d > 1e-6 {
+			t.Fatalf("theta %.1f: aggregated momentum off by %f", theta, d)
+		}
+		if singles != near {
+			t.Fatalf("theta %.1f: %d objects within the radius reported on their own, want %d", theta, singles, near)
+		}
+	}
+	if reports[0] != root[1].Count {
+		t.Fatalf("theta 0: %d reports, want one per object", reports[0])
+	}
+	if reports[5] >= reports[0.5] || reports[0.5] > reports[0] {
+		t.Fatalf("expected larger theta to report fewer aggregates, got %v", reports)
+	}
+}
+
+func TestBuildMatchesInsert(t *testing.T) {
+	bounds := quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}
+	rng := rand.New(rand.NewSource(9)) //nolint:gosec
+	objs := make([]*quadtree.Object, 0, 1200)
+	for i := range 1000 {
+		objs = append(objs, &quadtree.Object{ID: int64(i), Position: vector.V(rng.Float64()*100, rng.Float64()*100)})
+	}
+	// clustered points, points on quadrant boundaries and points outside the bounds
+	for i := range 200 {
+		p := vector.V(25+rng.Float64()*0.01, 50)
+		if i%4 == 0 {
+			p = vector.V(-rng.Float64()*5, 100+rng.Float64()*5)
+		}
+		objs = append(objs, &quadtree.Object{ID: int64(1000 + i), Position: p})
+	}
+
+	for _, lvl := range []int{0, 3, 6, 40} {
+		inserted := quadtree.NewQuadTree(bounds, 0, 8, lvl)
+		for _, obj := range objs {
+			inserted.Insert(obj)
+		}
+		want := inserted.Stats()
+
+		for name, built := range map[string]*quadtree.QuadTree{
+			"build":    quadtree.Build(bounds, 8, lvl, objs),
+			"parallel": quadtree.BuildParallel(bounds, 8, lvl, objs),
+		} {
+			if got := built.Stats(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s maxLvl=%d: stats %+v, want %+v", name, lvl, got, want)
+			}
+			for range 20 {
+				r := &quadtree.Bounds{X: rng.Float64()*110 - 10, Y: rng.Float64()*110 - 10, Width: rng.Float64() * 40, Height: rng.Float64() * 40}
+				if a, b := len(built.Query(r)), len(inserted.Query(r)); a != b {
+					t.Fatalf("%s maxLvl=%d: query returned %d objects, want %d", name, lvl, a, b)
+				}
+			}
+			// the ID index must be usable right away
+			built.Update(0, vector.V(99, 99))
+			if !built.Remove(1) || built.Stats().Objects != len(objs)-1 {
+				t.Fatalf("%s maxLvl=%d: update/remove after build failed", name, lvl)
+			}
+		}
+	}
+
+	if s := quadtree.Build(bounds, 8, 5, nil).Stats(); s.Nodes != 1 || s.Objects != 0 {
+		t.Fatalf("expected empty root for no objects, got %+v", s)
+	}
+}
+
+// largeWorkload returns 20k uniformly spread objects for construction benchmarks
+func largeWorkload() []*quadtree.Object {
+	rng := rand.New(rand.NewSource(1)) //nolint:gosec
+	objs := make([]*quadtree.Object, 20000)
+	for i := range objs {
+		objs[i] = &quadtree.Object{ID: int64(i), Position: vector.V(rng.Float64()*1600, rng.Float64()*1200)}
+	}
+	return objs
+}
+
+func BenchmarkConstructInsert(b *testing.B) {
+	objs := largeWorkload()
+	b.ReportAllocs()
+	for b.Loop() {
+		qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 1600, Height: 1200}, 0, 10, 8)
+		for _, obj := range objs {
+			qt.Insert(obj)
+		}
+	}
+}
+
+func BenchmarkConstructBuild(b *testing.B) {
+	objs := largeWorkload()
+	b.ReportAllocs()
+	for b.Loop() {
+		quadtree.Build(quadtree.Bounds{X: 0, Y: 0, Width: 1600, Height: 1200}, 10, 8, objs)
+	}
+}
+
+func BenchmarkConstructBuildParallel(b *testing.B) {
+	objs := largeWorkload()
+	b.ReportAllocs()
+	for b.Loop() {
+		quadtree.BuildParallel(quadtree.Bounds{X: 0, Y: 0, Width: 1600, Height: 1200}, 10, 8, objs)
+	}
+}
+
+func TestStatsAndWalk(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 4, 3)
+	rng := rand.New(rand.NewSource(4)) //nolint:gosec
+	for i := range 100 {
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: vector.V(rng.Float64()*100, rng.Float64()*100)})
+	}
+	// a dense cluster piles up in a leaf at the maximum level
+	for i := range 20 {
+		qt.Insert(&quadtree.Object{ID: int64(100 + i), Position: vector.V(1+float64(i)*0.01, 1)})
+	}
+
+	s := qt.Stats()
+	if s.Objects != 120 || s.MaxDepth != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.StuckObjects < 20 || s.MaxLvlLeaves == 0 {
+		t.Fatalf("expected clustered objects to be stuck at max level, got %+v", s)
+	}
+	leaves, objects := 0, 0
+	for n, c := range s.LeafOccupancy {
+		leaves += c
+		objects += n * c
+	}
+	if leaves != s.Leaves || objects != s.Objects || s.LeafOccupancy[0] != s.EmptyLeaves {
+		t.Fatalf("occupancy histogram %v does not match stats %+v", s.LeafOccupancy, s)
+	}
+
+	nodes, walked := 0, 0
+	qt.Walk(func(node *quadtree.QuadTree) bool {
+		nodes++
+		walked += len(node.Objects())
+		b := node.Bounds()
+		if b.Width != 100/math.Pow(2, float64(node.Level())) {
+			t.Fatalf("node at level %d has width %f", node.Level(), b.Width)
+		}
+		return true
+	})
+	if nodes != s.Nodes || walked != s.Objects {
+		t.Fatalf("walk visited %d nodes and %d objects, want %d and %d", nodes, walked, s.Nodes, s.Objects)
+	}
+
+	// returning false prunes the children
+	visited := 0
+	qt.Walk(func(node *quadtree.QuadTree) bool {
+		visited++
+		return node.Level() < 1
+	})
+	if visited != 5 {
+		t.Fatalf("expected root and its 4 children only, visited %d", visited)
+	}
+}
+
+func TestWriteJSONAndDOT(t *testing.T) {
+	qt := quadtree.NewQuadTree(quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}, 0, 2, 4)
+	for i := range 30 {
+		qt.Insert(&quadtree.Object{ID: int64(i), Position: vector.V(float64(i*3), float64(i*2))})
+	}
+	s := qt.Stats()
+
+	var buf bytes.Buffer
+	if err := qt.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	type node struct {
+		Level    int               `json:"level"`
+		Objects  []json.RawMessage `json:"objects"`
+		Children []*node           `json:"children"`
+	}
+	var root node
+	if err := json.Unmarshal(buf.Bytes(), &root); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	var count func(n *node) (int, int)
+	count = func(n *node) (int, int) {
+		nodes, objs := 1, len(n.Objects)
+		for _, c := range n.Children {
+			cn, co := count(c)
+			nodes, objs = nodes+cn, objs+co
+		}
+		return nodes, objs
+	}
+	if nodes, objs := count(&root); nodes != s.Nodes || objs != s.Objects {
+		t.Fatalf("JSON has %d nodes and %d objects, want %d and %d", nodes, objs, s.Nodes, s.Objects)
+	}
+
+	buf.Reset()
+	if err := qt.WriteDOT(&buf); err != nil {
+		t.Fatalf("WriteDOT: %v", err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "digraph quadtree {") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("malformed DOT output:\n%s", dot)
+	}
+	if edges := strings.Count(dot, "->"); edges != s.Nodes-1 {
+		t.Fatalf("expected %d edges, got %d", s.Nodes-1, edges)
+	}
+}
+
+func TestLooseQuadTree(t *testing.T) {
+	bounds := quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}
+	lt := quadtree.NewLooseQuadTree(bounds, 5, quadtree.DefaultLooseFactor)
+	rng := rand.New(rand.NewSource(11)) //nolint:gosec
+	objects := make([]*quadtree.LooseObject, 200)
+	for i := range objects {
+		// mix of circles and rectangles of varying size, some sticking out of the bounds
+		if i%2 == 0 {
+			objects[i] = quadtree.NewCircleObject(int64(i), vector.V(rng.Float64()*110-5, rng.Float64()*110-5), rng.Float64()*8)
+		} else {
+			objects[i] = quadtree.NewRectObject(int64(i), quadtree.Bounds{
+				X: rng.Float64()*100 - 5, Y: rng.Float64()*100 - 5, Width: rng.Float64() * 20, Height: rng.Float64() * 4,
+			})
+		}
+		lt.Insert(objects[i])
+	}
+	if lt.Len() != len(objects) {
+		t.Fatalf("expected %d objects, got %d", len(objects), lt.Len())
+	}
+
+	// brute-force overlap with the exact object shapes
+	overlapsCircle := func(o *quadtree.LooseObject, c vector.Vec2, r float64) bool {
+		if o.Radius > 0 {
+			return o.Center().Sub(c).Len() <= o.Radius+r
+		}
+		dx := math.Max(math.Max(o.Bounds.X-c.X, 0), c.X-(o.Bounds.X+o.Bounds.Width))
+		dy := math.Max(math.Max(o.Bounds.Y-c.Y, 0), c.Y-(o.Bounds.Y+o.Bounds.Height))
+		return dx*dx+dy*dy <= r*r
+	}
+	ids := func(res []*quadtree.LooseObject) []int64 {
+		out := make([]int64, len(res))
+		for i, o := range res {
+			out[i] = o.ID
+		}
+		sort.Slice(out, func(a, b int) bool { return out[a] < out[b] })
+		return out
+	}
+
+	for q := range 50 {
+		c := vector.V(rng.Float64()*100, rng.Float64()*100)
+		r := rng.Float64() * 15
+		var want []int64
+		for _, o := range objects {
+			if overlapsCircle(o, c, r) {
+				want = append(want, o.ID)
+			}
+		}
+		if got := ids(lt.QueryCircle(c, r)); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("circle query %d: got %v, want %v", q, got, want)
+		}
+
+		rang := quadtree.Bounds{X: c.X, Y: c.Y, Width: r, Height: r / 2}
+		want = want[:0]
+		for _, o := range objects {
+			ok := o.Bounds.Intersects(&rang)
+			if o.Radius > 0 {
+				rc := vector.V(math.Max(rang.X, math.Min(o.Center().X, rang.X+rang.Width)),
+					math.Max(rang.Y, math.Min(o.Center().Y, rang.Y+rang.Height)))
+				ok = rc.Sub(o.Center()).Len() <= o.Radius
+			}
+			if ok {
+				want = append(want, o.ID)
+			}
+		}
+		if got := ids(lt.Query(&rang)); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("range query %d: got %v, want %v", q, got, want)
+		}
+	}
+
+	for _, o := range objects[:100] {
+		if !lt.Remove(o.ID) {
+			t.Fatalf("failed to remove %d", o.ID)
+		}
+	}
+	if lt.Remove(0) {
+		t.Fatal("removed an object twice")
+	}
+	if got := lt.Query(&quadtree.Bounds{X: -50, Y: -50, Width: 200, Height: 200}); len(got) != 100 || lt.Len() != 100 {
+		t.Fatalf("expected 100 objects after removal, got %d", len(got))
+	}
+}
+
+func TestRayCast(t *testing.T) {
+	bounds := quadtree.Bounds{X: 0, Y: 0, Width: 100, Height: 100}
+	lt := quadtree.NewLooseQuadTree(bounds, 5, quadtree.DefaultLooseFactor)
+	lt.Insert(quadtree.NewRectObject(1, quadtree.Bounds{X: 40, Y: 10, Width: 10, Height: 20}))
+	lt.Insert(quadtree.NewCircleObject(2, vector.V(70, 20), 5))
+	lt.Insert(quadtree.NewRectObject(3, quadtree.Bounds{X: 10, Y: 80, Width: 5, Height: 5}))
+
+	near := func(a, b vector.Vec2) bool { return a.Sub(b).Len() < 1e-9 }
+
+	hit, ok := lt.RayCast(vector.V(0, 20), vector.V(2, 0), 100)
+	if !ok || hit.Object.ID != 1 || math.Abs(hit.Dist-40) > 1e-9 || !near(hit.Normal, vector.V(-1, 0)) {
+		t.Fatalf("expected rect 1 at distance 40 facing -X, got %+v (%v)", hit, ok)
+	}
+	hit, ok = lt.RayCast(vector.V(100, 20), vector.V(-1, 0), 100)
+	if !ok || hit.Object.ID != 2 || math.Abs(hit.Dist-25) > 1e-9 || !near(hit.Normal, vector.V(1, 0)) {
+		t.Fatalf("expected circle 2 at distance 25 facing +X, got %+v (%v)", hit, ok)
+	}
+	if _, ok = lt.RayCast(vector.V(0, 20), vector.V(1, 0), 39); ok {
+		t.Fatal("expected no hit short of the first object")
+	}
+	if hit, ok = lt.RayCast(vector.V(45, 15), vector.V(0, 1), 10); !ok || hit.Object.ID != 1 || hit.Dist != 0 {
+		t.Fatalf("expected a hit at distance 0 from inside rect 1, got %+v (%v)", hit, ok)
+	}
+	if _, ok = lt.RayCast(vector.V(0, 20), vector.V(0, 0), 100); ok {
+		t.Fatal("expected no hit for a zero direction")
+	}
+
+	hits := lt.SegmentQuery(vector.V(0, 20), vector.V(100, 20))
+	if len(hits) != 2 || hits[0].Object.ID != 1 || hits[1].Object.ID != 2 {
+		t.Fatalf("expected objects 1 and 2 in order, got %+v", hits)
+	}
+	if lt.SegmentBlocked(vector.V(0, 50), vector.V(100, 50)) {
+		t.Fatal("expected a clear segment")
+	}
+
+	// random scene against a linear scan of single-object trees
+	rng := rand.New(rand.NewSource(5)) //nolint:gosec
+	lt = quadtree.NewLooseQuadTree(bounds, 5, quadtree.DefaultLooseFactor)
+	singles := make([]*quadtree.LooseQuadTree, 0, 150)
+	for i := range 150 {
+		var o *quadtree.LooseObject
+		if i%2 == 0 {
+			o = quadtree.NewCircleObject(int64(i), vector.V(rng.Float64()*100, rng.Float64()*100), rng.Float64()*4)
+		} else {
+			o = quadtree.NewRectObject(int64(i), quadtree.Bounds{X: rng.Float64() * 100, Y: rng.Float64() * 100, Width: rng.Float64() * 6, Height: rng.Float64() * 6})
+		}
+		lt.Insert(o)
+		s := quadtree.NewLooseQuadTree(bounds, 0, 1)
+		s.Insert(o)
+		singles = append(singles, s)
+	}
+	for q := range 200 {
+		origin := vector.V(rng.Float64()*100, rng.Float64()*100)
+		dir := vector.V(rng.Float64()*2-1, rng.Float64()*2-1)
+		maxDist := rng.Float64() * 60
+		want := math.Inf(1)
+		for _, s := range singles {
+			if h, ok := s.RayCast(origin, dir, maxDist); ok {
+				want = math.Min(want, h.Dist)
+			}
+		}
+		hit, ok := lt.RayCast(origin, dir, maxDist)
+		switch {
+		case math.IsInf(want, 1) && ok:
+			t.Fatalf("ray %d: unexpected hit %+v", q, hit)
+		case !math.IsInf(want, 1) && (!ok || math.Abs(hit.Dist-want) > 1e-9):
+			t.Fatalf("ray %d: got distance %v (%v), want %v", q, hit.Dist, ok, want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package quadtree
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/OutOfStack/boids/vector"
+)
+
+// RayHit describes where a ray or segment enters an object
+type RayHit struct {
+	Object *LooseObject
+	// Dist is the distance from the ray origin to Point; 0 if the origin lies inside the object
+	Dist  float64
+	Point vector.Vec2
+	// Normal is the unit surface normal at Point, facing the ray origin
+	Normal vector.Vec2
+}
+
+// RayCast returns the first object hit by the ray from origin along dir within maxDist.
+// Nodes are visited front to back and the traversal stops at the nearest hit.
+func (lt *LooseQuadTree) RayCast(origin, dir vector.Vec2, maxDist float64) (RayHit, bool) {
+	var first RayHit
+	found := false
+	lt.RayCastFunc(origin, dir, maxDist, func(hit RayHit) bool {
+		first, found = hit, true
+		return false
+	})
+	return first, found
+}
+
+// SegmentQuery returns all objects crossed by the segment from a to b, ordered by distance from a
+func (lt *LooseQuadTree) SegmentQuery(a, b vector.Vec2) []RayHit {
+	result := make([]RayHit, 0)
+	d := b.Sub(a)
+	lt.RayCastFunc(a, d, d.Len(), func(hit RayHit) bool {
+		result = append(result, hit)
+		return true
+	})
+	return result
+}
+
+// SegmentBlocked reports whether any object crosses the segment from a to b
+func (lt *LooseQuadTree) SegmentBlocked(a, b vector.Vec2) bool {
+	d := b.Sub(a)
+	_, hit := lt.RayCast(a, d, d.Len())
+	return hit
+}
+
+// RayCastFunc calls fn for each object hit by the ray from origin along dir within maxDist,
+// in order of increasing hit distance, until fn returns false. Nodes and hits share one
+// priority queue keyed by distance, so a hit is reported only once no unvisited node can
+// hold a closer one.
+func (lt *LooseQuadTree) RayCastFunc(origin, dir vector.Vec2, maxDist float64, fn func(RayHit) bool) {
+	l := dir.Len()
+	if l == 0 || maxDist < 0 {
+		return
+	}
+	dir = dir.Scale(1 / l)
+
+	// the root is always entered since it also keeps objects outside its loose bounds
+	queue := &rayQueue{{node: lt}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(rayItem) //nolint:forcetypeassert
+		if item.node == nil {
+			if !fn(item.hit) {
+				return
+			}
+			continue
+		}
+
+		for _, obj := range item.node.objects {
+			if hit, ok := obj.intersectRay(origin, dir, maxDist); ok {
+				heap.Push(queue, rayItem{hit: hit, dist: hit.Dist})
+			}
+		}
+		for _, child := range item.node.nodes {
+			if child == nil {
+				continue
+			}
+			if tmin, _, ok := rayBounds(origin, dir, maxDist, &child.loose); ok {
+				heap.Push(queue, rayItem{node: child, dist: tmin})
+			}
+		}
+	}
+}
+
+// intersectRay returns where a ray with unit direction dir enters the object within maxDist
+func (o *LooseObject) intersectRay(origin, dir vector.Vec2, maxDist float64) (RayHit, bool) {
+	if o.Radius > 0 {
+		return o.intersectRayCircle(origin, dir, maxDist)
+	}
+	tmin, normal, ok := rayBounds(origin, dir, maxDist, &o.Bounds)
+	if !ok {
+		return RayHit{}, false
+	}
+	if tmin == 0 {
+		// origin inside: push back against the direction of travel
+		normal = dir.Neg()
+	}
+	return RayHit{Object: o, Dist: tmin, Point: origin.Add(dir.Scale(tmin)), Normal: normal}, true
+}
+
+func (o *LooseObject) intersectRayCircle(origin, dir vector.Vec2, maxDist float64) (RayHit, bool) {
+	c := o.Center()
+	m := origin.Sub(c)
+	cc := m.Dot(m) - o.Radius*o.Radius
+	if cc <= 0 {
+		// origin inside: the normal points out of the circle through the origin
+		normal := dir.Neg()
+		if l := m.Len(); l > 0 {
+			normal = m.Scale(1 / l)
+		}
+		return RayHit{Object: o, Dist: 0, Point: origin, Normal: normal}, true
+	}
+	bb := m.Dot(dir)
+	disc := bb*bb - cc
+	if bb > 0 || disc < 0 {
+		return RayHit{}, false
+	}
+	t := -bb - math.Sqrt(disc)
+	if t > maxDist {
+		return RayHit{}, false
+	}
+	p := origin.Add(dir.Scale(t))
+	return RayHit{Object: o, Dist: t, Point: p, Normal: p.Sub(c).Scale(1 / o.Radius)}, true
+}
+
+// rayBounds clips a ray with unit direction dir against b using the slab method. It returns the
+// entry distance, clamped to 0 when the origin is inside, and the normal of the entered face.
+func rayBounds(origin, dir vector.Vec2, maxDist float64, b *Bounds) (float64, vector.Vec2, bool) {
+	tmin, tmax := 0.0, maxDist
+	var normal vector.Vec2
+	axes := [2]struct{ o, d, lo, hi float64 }{
+		{origin.X, dir.X, b.X, b.X + b.Width},
+		{origin.Y, dir.Y, b.Y, b.Y + b.Height},
+	}
+	for i, a := range axes {
+		if a.d == 0 {
+			if a.o < a.lo || a.o > a.hi {
+				return 0, vector.Vec2{}, false
+			}
+			continue
+		}
+		t1, t2 := (a.lo-a.o)/a.d, (a.hi-a.o)/a.d
+		sign := -1.0
+		if t1 > t2 {
+			t1, t2 = t2, t1
+			sign = 1
+		}
+		if t1 > tmin {
+			tmin = t1
+			normal = vector.Vec2{}
+			if i == 0 {
+				normal.X = sign
+			} else {
+				normal.Y = sign
+			}
+		}
+		tmax = min(tmax, t2)
+		if tmin > tmax {
+			return 0, vector.Vec2{}, false
+		}
+	}
+	return tmin, normal, true
+}
+
+// rayItem is either a node to visit or a confirmed hit, keyed by distance along the ray
+type rayItem struct {
+	node *LooseQuadTree
+	hit  RayHit
+	dist float64
+}
+
+// rayQueue is a min-heap of ray items by distance; at equal distance hits come before nodes
+type rayQueue []rayItem
+
+func (q rayQueue) Len() int { return len(q) }
+func (q rayQueue) Less(i, j int) bool {
+	if q[i].dist != q[j].dist {
+		return q[i].dist < q[j].dist
+	}
+	return q[i].node == nil && q[j].node != nil
+}
+func (q rayQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *rayQueue) Push(x any)   { *q = append(*q, x.(rayItem)) } //nolint:forcetypeassert
+func (q *rayQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
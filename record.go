@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/OutOfStack/boids/sim"
+	"github.com/gopxl/pixel/v2/backends/opengl"
+)
+
+// runRecord runs the simulation like the default mode while recording the seed, config, inputs
+// and periodic state hashes to a file
+func runRecord(args []string) {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	out := fs.String("out", "boids.rec", "recording file to write")
+	hashEvery := fs.Int64("hash-every", 1, "ticks between state hashes; 1 pinpoints the exact tick a replay diverges at")
+	headless := fs.Bool("headless", false, "simulate without a window, as fast as possible")
+	ticks := fs.Int64("ticks", 1000, "number of steps to simulate in headless mode")
+	loadSnapshot := fs.String("load-snapshot", "", "start from a snapshot file instead of config.json")
+	_ = fs.Parse(args) // exits on error
+
+	world := newWorld(*loadSnapshot)
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err = world.Record(f, *hashEvery); err != nil {
+		log.Fatal(err)
+	}
+
+	if *headless {
+		runHeadless(world, *ticks, os.Stdout)
+	} else {
+		opengl.Run(func() { run(world) })
+	}
+
+	if err = world.StopRecording(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("recording written to %s", *out)
+}
+
+// runReplay re-runs a recording and reports the first tick at which the state diverges
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: boids replay <recording>")
+	}
+	_ = fs.Parse(args) // exits on error
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	res, err := sim.Replay(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if res.Diverged {
+		fmt.Printf("diverged at tick %d (state hash %x, recorded %x); last match at tick %d\n",
+			res.DivergedTick, res.Got, res.Want, res.LastMatch)
+		os.Exit(1)
+	}
+	fmt.Printf("replay matches the recording: %d state hashes up to tick %d\n", res.Checkpoints, res.Ticks)
+}
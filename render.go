@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/sim"
+	"github.com/OutOfStack/boids/vector"
+	"github.com/gopxl/pixel/v2"
+	"github.com/gopxl/pixel/v2/backends/opengl"
+	"github.com/gopxl/pixel/v2/ext/imdraw"
+	"github.com/gopxl/pixel/v2/ext/text"
+)
+
+// scene is one frame to draw, whether live, played back or rewound
+type scene struct {
+	cfg   *config.Config
+	flock *sim.Flock
+	// position returns where boid i is drawn, nil for its position in the flock
+	position func(i int) vector.Vec2
+	// tree returns the quadtree to outline, nil when another index is in use; without it one is
+	// built from the positions
+	tree func() *quadtree.QuadTree
+	// metrics of the frame, nil when no overlay needs them
+	metrics *metrics.Frame
+}
+
+// renderer draws scenes with the overlays toggled by the keyboard, the same in every window
+type renderer struct {
+	imd *imdraw.IMDraw
+	hud *text.Text
+
+	showQuadTree bool
+	showMetrics  bool
+	showFlocks   bool
+	colorByFlock bool
+
+	// measurer computes the metrics of frames that were not simulated here; nil until needed
+	measurer *sim.Measurer
+	measured metrics.Frame
+	hasFrame bool
+}
+
+func newRenderer() *renderer {
+	return &renderer{imd: imdraw.New(nil), hud: text.New(pixel.ZV, text.Atlas7x13)}
+}
+
+// handleKeys toggles the overlays: Q the quadtree, M the metrics table, F the flock outlines and
+// event log, C colors boids by flock
+func (r *renderer) handleKeys(win *opengl.Window) {
+	if win.JustPressed(pixel.KeyQ) {
+		r.showQuadTree = !r.showQuadTree
+	}
+	if win.JustPressed(pixel.KeyM) {
+		r.showMetrics = !r.showMetrics
+	}
+	if win.JustPressed(pixel.KeyF) {
+		r.showFlocks = !r.showFlocks
+	}
+	if win.JustPressed(pixel.KeyC) {
+		r.colorByFlock = !r.colorByFlock
+	}
+}
+
+// needMetrics reports whether an overlay shows metrics
+func (r *renderer) needMetrics() bool {
+	return r.showMetrics || r.showFlocks || r.colorByFlock
+}
+
+// measure returns the metrics of a frame that was not simulated here, nil when no overlay needs
+// them. Frames are measured once, and flocks are followed from one measured frame to the next
+// until forget is called.
+func (r *renderer) measure(cfg *config.Config, tick int64, f *sim.Flock) *metrics.Frame {
+	if !r.needMetrics() {
+		return nil
+	}
+	if r.hasFrame && r.measured.Tick == tick {
+		return &r.measured
+	}
+	if r.measurer == nil {
+		r.measurer = sim.NewMeasurer(cfg)
+	}
+	r.measured, r.hasFrame = r.measurer.Measure(tick, f), true
+	if r.showFlocks {
+		logFlockEvents(r.measured.Events)
+	}
+	return &r.measured
+}
+
+// forget drops the measured frames, for example when a different timeline is shown
+func (r *renderer) forget() {
+	r.measurer, r.hasFrame = nil, false
+}
+
+// draw queues the scene; present shows it
+func (r *renderer) draw(s *scene) {
+	imd := r.imd
+	drawObstacles(imd, s.cfg)
+	if r.showQuadTree {
+		if s.tree != nil {
+			drawQuadTree(imd, s.tree())
+		} else {
+			// the state does not come with its index
+			drawQuadTree(imd, rebuildQuadTree(s.cfg, s.flock.X, s.flock.Y))
+		}
+	}
+	if r.showFlocks && s.metrics != nil {
+		drawFlocks(imd, s.metrics.Flocks)
+	}
+	f := s.flock
+	var labels []int64
+	if r.colorByFlock && s.metrics != nil {
+		labels = s.metrics.LabelsFor(f.ID)
+	}
+	for i := range f.Len() {
+		col := sim.SpeciesColors[f.Species[i]]
+		if labels != nil {
+			col = flockColor(labels[i])
+		}
+		p := f.Position(i)
+		if s.position != nil {
+			p = s.position(i)
+		}
+		drawBoid(imd, p, f.VX[i], f.VY[i], col, s.cfg.PolyThickness)
+	}
+}
+
+// present draws what was queued, then the metrics table over it
+func (r *renderer) present(win *opengl.Window, s *scene) {
+	r.imd.Draw(win)
+	r.imd.Clear()
+	if r.showMetrics && s.metrics != nil {
+		drawMetrics(win, r.hud, *s.metrics)
+	}
+}
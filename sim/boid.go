@@ -0,0 +1,237 @@
+package sim
+
+import (
+	"image/color"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/vector"
+	"golang.org/x/image/colornames"
+)
+
+// SpeciesColors are the colors boids of each species are drawn in; boids only flock with their own species
+var SpeciesColors = []color.RGBA{
+	colornames.Gray,
+	colornames.Darkorange,
+	colornames.Cornflowerblue,
+	colornames.Yellowgreen,
+}
+
+// Adds a new boid with random position and velocity.
+// The boid's species is chosen based on its id for visual variety
+func (w *World) spawn(f *Flock, bID int64) {
+	var species uint8
+	switch {
+	case bID%7 == 0:
+		species = 1
+	case bID%11 == 0:
+		species = 2
+	case bID%17 == 0:
+		species = 3
+	}
+
+	f.add(bID,
+		// random initial position within simulation bounds.
+		vector.V(
+			w.rng.Float64()*float64(w.cfg.Width),
+			w.rng.Float64()*float64(w.cfg.Height)),
+		// random initial velocity in the range [-1, 1] of the top speed for both X and Y
+		vector.V(
+			w.rng.Float64()*2-1.0,
+			w.rng.Float64()*2-1.0).Scale(w.maxSpeed()),
+		species)
+}
+
+// Computes the steering acceleration for boid i based on the current flock state and the spatial index built from it.
+func (w *World) accelerationFor(i int, s *visitScratch) vector.Vec2 {
+	cfg := w.cfg
+	f := w.flock
+	selfPos := f.Position(i)
+	// steering works on velocities relative to the top speed
+	speed := w.maxSpeed()
+	selfVel := f.Velocity(i).Scale(1 / speed)
+
+	width, height := float64(cfg.Width), float64(cfg.Height)
+	topological := cfg.NeighborMode == config.NeighborModeTopological
+	occlusion := cfg.LineOfSight && w.obstacles != nil
+
+	avgPosition, avgVelocity, separation := vector.Vec2{}, vector.Vec2{}, vector.Vec2{}
+	count := 0.0
+	// distance to the nearest flockmate, for the metrics
+	nearest := math.Inf(1)
+
+	mark := s.next()
+
+	// collect nearby boids, deduping ghosts by ID
+	s.ids = s.ids[:0]
+	visit := func(id int64) {
+		if id != int64(i) && s.stamp[id] != mark {
+			s.stamp[id] = mark
+			s.ids = append(s.ids, id)
+		}
+	}
+
+	// process a nearby boid
+	consider := func(id int64) {
+		otherPos := f.Position(int(id))
+		otherVel := f.Velocity(int(id)).Scale(1 / speed)
+
+		// consider only boids of the same species
+		if f.Species[id] == f.Species[i] {
+			if topological {
+				// topological neighbors may sit across the border; use their nearest periodic image
+				otherPos = selfPos.Add(wrapOffset(otherPos.Sub(selfPos), width, height))
+			}
+			dx := otherPos.X - selfPos.X
+			dy := otherPos.Y - selfPos.Y
+			dist2 := dx*dx + dy*dy
+			r2 := cfg.ViewRadius * cfg.ViewRadius
+			if (topological || dist2 < r2) && dist2 > 0 {
+				// flockmates hidden behind an obstacle are invisible
+				if occlusion && w.obstacles.SegmentBlocked(selfPos, otherPos) {
+					return
+				}
+				d := math.Sqrt(dist2)
+				count++
+				nearest = min(nearest, d)
+				// the flockmate graph is undirected; metric neighborhoods are symmetric,
+				// so each pair is kept once
+				if w.measuring && (topological || int64(i) < id) {
+					s.edges = append(s.edges, metrics.Edge{A: int32(i), B: int32(id)})
+				}
+				avgVelocity = avgVelocity.Add(otherVel)
+				avgPosition = avgPosition.Add(otherPos)
+				// separation: steer away from neighbors
+				sep := vector.DivisionV(selfPos.Sub(otherPos), d)
+				separation = separation.Add(sep)
+			}
+		}
+	}
+
+	// query the spatial index for nearby boids (including ghosts); the quadtree tuner weighs the
+	// time it takes
+	var queryStart time.Time
+	if w.tuner != nil {
+		queryStart = time.Now()
+	}
+	switch {
+	case topological:
+		// k nearest flockmates regardless of distance: other boids of the same species that are
+		// in sight, so that every boid has exactly k of them whenever there are enough
+		sameSpecies := func(obj *quadtree.Object) bool {
+			if obj.ID == int64(i) || f.Species[obj.ID] != f.Species[i] {
+				return false
+			}
+			if !occlusion {
+				return true
+			}
+			otherPos := selfPos.Add(wrapOffset(f.Position(int(obj.ID)).Sub(selfPos), width, height))
+			return !w.obstacles.SegmentBlocked(selfPos, otherPos)
+		}
+		for _, obj := range w.index.QueryKNNFunc(selfPos, cfg.NeighborK, sameSpecies) {
+			visit(obj.ID)
+		}
+	case w.neighbors != nil:
+		// cached candidates within view radius + skin, filtered by view radius in visit
+		for _, id := range w.neighbors.of(i) {
+			visit(id)
+		}
+	default:
+		w.index.QueryCircleFunc(selfPos, cfg.ViewRadius, func(obj *quadtree.Object) bool {
+			visit(obj.ID)
+			return true
+		})
+	}
+	if w.tuner != nil {
+		s.queryTime += time.Since(queryStart)
+	}
+	// sums depend on the order of terms; a fixed order keeps the result independent of how
+	// the index happens to store the boids, so runs are reproducible
+	slices.Sort(s.ids)
+	for _, id := range s.ids {
+		consider(id)
+	}
+
+	w.nbCount[i], w.nbNearest[i] = int32(count), nearest
+
+	// start with border bounce acceleration to avoid edges
+	accel := vector.V(w.borderBounce(selfPos.X, width), w.borderBounce(selfPos.Y, height))
+	if count > 0 {
+		avgPosition, avgVelocity = vector.DivisionV(avgPosition, count), vector.DivisionV(avgVelocity, count)
+		accelAlignment := avgVelocity.Sub(selfVel).Scale(cfg.AdjRate)
+		accelCohesion := avgPosition.Sub(selfPos).Scale(cfg.AdjRate)
+		accelSeparation := separation.Scale(cfg.AdjRate)
+		accel = accel.Add(accelAlignment).Add(accelCohesion).Add(accelSeparation)
+	}
+	if cfg.LongRangeWeight != 0 {
+		accel = accel.Add(w.longRangeForce(selfPos, f.Species[i]))
+	}
+	// look ahead and turn before flying into an obstacle
+	accel = accel.Add(w.avoidObstacles(selfPos, selfVel))
+
+	return accel
+}
+
+// visitScratch collects the neighbors visited for one boid at a time:
+// stamp[id] == mark once boid id has been collected into ids for the current boid
+type visitScratch struct {
+	stamp []uint32
+	mark  uint32
+	ids   []int64
+	edges []metrics.Edge // flockmate pairs found by this worker during a tick
+	// queryTime is the time this worker spent in neighbor queries during a tick, measured
+	// only for the quadtree tuner
+	queryTime time.Duration
+}
+
+// next starts a new boid and returns its mark
+func (s *visitScratch) next() uint32 {
+	s.mark++
+	if s.mark == 0 {
+		// wrapped around: forget stamps from earlier rounds
+		clear(s.stamp)
+		s.mark = 1
+	}
+	return s.mark
+}
+
+// Maps an offset to its shortest equivalent in the toroidal world
+func wrapOffset(d vector.Vec2, width, height float64) vector.Vec2 {
+	if d.X > width/2 {
+		d.X -= width
+	} else if d.X < -width/2 {
+		d.X += width
+	}
+	if d.Y > height/2 {
+		d.Y -= height
+	} else if d.Y < -height/2 {
+		d.Y += height
+	}
+	return d
+}
+
+// Provides a force to steer the boid away from boundaries with clamping to avoid infinities
+func (w *World) borderBounce(pos, maxBorderPos float64) float64 {
+	cfg := w.cfg
+	eps := 1e-3
+	maxForce := 1.0
+	if pos < cfg.ViewRadius {
+		v := 1.0 / math.Max(pos, eps)
+		if v > maxForce {
+			v = maxForce
+		}
+		return v
+	}
+	if pos > maxBorderPos-cfg.ViewRadius {
+		v := 1.0 / math.Max(maxBorderPos-pos, eps)
+		if v > maxForce {
+			v = maxForce
+		}
+		return -v
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+package sim
+
+import (
+	"context"
+	"time"
+
+	"github.com/OutOfStack/boids/vector"
+)
+
+const (
+	// defaultStep is the fixed simulation step used when update_rate_ms is not set
+	defaultStep = 10 * time.Millisecond
+	// referenceStep is the step length the steering weights (adj_rate, border bounce, long-range
+	// and obstacle forces) are calibrated for; their effect is scaled to the actual step
+	referenceStep = 10 * time.Millisecond
+	// defaultMaxSpeed is the top speed per axis in world units per second
+	defaultMaxSpeed = 100
+	// defaultMaxCatchUp is how many steps Run may simulate at once to catch up
+	defaultMaxCatchUp = 5
+)
+
+// TickStats counts simulation steps and those that did not run on schedule
+type TickStats struct {
+	Ticks   int64 // steps simulated
+	Late    int64 // steps run back to back to catch up after the loop fell behind
+	Dropped int64 // steps skipped because catching up would have exceeded the cap
+}
+
+// Step returns the fixed simulation step
+func (w *World) Step() time.Duration {
+	if w.cfg.UpdateRateMs <= 0 {
+		return defaultStep
+	}
+	return time.Duration(w.cfg.UpdateRateMs) * time.Millisecond
+}
+
+// maxSpeed returns the top speed per axis in world units per second
+func (w *World) maxSpeed() float64 {
+	if w.cfg.MaxSpeed <= 0 {
+		return defaultMaxSpeed
+	}
+	return w.cfg.MaxSpeed
+}
+
+// Run advances the world in fixed steps following the wall clock until ctx is cancelled.
+// Elapsed time is accumulated and consumed one step at a time, so the simulated speed does not
+// depend on how often the loop wakes up. When it falls behind it catches up with at most
+// max_catch_up_ticks steps at once; the rest of the backlog is dropped.
+func (w *World) Run(ctx context.Context) {
+	step := w.Step()
+	maxSteps := w.cfg.MaxCatchUpTicks
+	if maxSteps <= 0 {
+		maxSteps = defaultMaxCatchUp
+	}
+
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	last := time.Now()
+	var acc time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		if w.paused.Load() {
+			// the paused time is not caught up on
+			last, acc = now, 0
+			continue
+		}
+		acc += now.Sub(last)
+		last = now
+
+		n := 0
+		for acc >= step && n < maxSteps {
+			w.Tick()
+			acc -= step
+			n++
+		}
+		if n > 1 {
+			w.late.Add(int64(n - 1))
+		}
+		if acc >= step {
+			skipped := acc / step
+			w.dropped.Add(int64(skipped))
+			acc -= skipped * step
+		}
+		// a loaded snapshot may come with another step
+		step = w.Step()
+	}
+}
+
+// SetPaused stops or restarts the stepping done by Run
+func (w *World) SetPaused(paused bool) {
+	w.paused.Store(paused)
+}
+
+// Paused reports whether Run is paused
+func (w *World) Paused() bool {
+	return w.paused.Load()
+}
+
+// TickStats returns the step counters
+func (w *World) TickStats() TickStats {
+	w.mu.RLock()
+	ticks := w.ticks
+	w.mu.RUnlock()
+	return TickStats{Ticks: ticks, Late: w.late.Load(), Dropped: w.dropped.Load()}
+}
+
+// Alpha returns how far the wall clock is between the last step and the next one, in [0, 1];
+// callers must hold the read lock
+func (w *World) Alpha() float64 {
+	if w.lastTick.IsZero() {
+		return 1
+	}
+	return min(float64(time.Since(w.lastTick))/float64(w.Step()), 1)
+}
+
+// Interpolated returns the position of boid i blended between the previous step (alpha 0) and
+// the current one (alpha 1), following the shortest way across the wrapped borders;
+// callers must hold the read lock
+func (w *World) Interpolated(i int, alpha float64) vector.Vec2 {
+	width, height := float64(w.cfg.Width), float64(w.cfg.Height)
+	prev := w.prev.Position(i)
+	p := prev.Add(wrapOffset(w.flock.Position(i).Sub(prev), width, height).Scale(alpha))
+	if p.X < 0 {
+		p.X += width
+	} else if p.X >= width {
+		p.X -= width
+	}
+	if p.Y < 0 {
+		p.Y += height
+	} else if p.Y >= height {
+		p.Y -= height
+	}
+	return p
+}
@@ -0,0 +1,59 @@
+package sim
+
+import (
+	"log"
+	"os"
+
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/spatial"
+)
+
+const (
+	quadTreeJSONPath = "quadtree.json"
+	quadTreeDOTPath  = "quadtree.dot"
+)
+
+// QuadTree returns the quadtree behind the spatial index, nil for other index kinds;
+// callers must hold the read lock while using it
+func (w *World) QuadTree() *quadtree.QuadTree {
+	if qt, ok := w.index.(*spatial.QuadTree); ok {
+		return qt.QuadTree
+	}
+	return nil
+}
+
+// DumpQuadTree logs the quadtree statistics and writes its structure as JSON and Graphviz DOT
+// for offline analysis
+func (w *World) DumpQuadTree() {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	qt := w.QuadTree()
+	if qt == nil {
+		log.Printf("quadtree dump needs the %q spatial index", spatial.KindQuadTree)
+		return
+	}
+
+	s := qt.Stats()
+	log.Printf("quadtree: %d nodes, %d leaves (%d empty), %d objects, depth %d, max %d per node, %d stuck at max level",
+		s.Nodes, s.Leaves, s.EmptyLeaves, s.Objects, s.MaxDepth, s.MaxNodeObjects, s.StuckObjects)
+	log.Printf("quadtree leaf occupancy: %v", s.LeafOccupancy)
+
+	for path, write := range map[string]func(*os.File) error{
+		quadTreeJSONPath: func(f *os.File) error { return qt.WriteJSON(f) },
+		quadTreeDOTPath:  func(f *os.File) error { return qt.WriteDOT(f) },
+	} {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Printf("quadtree dump: %v", err)
+			continue
+		}
+		if err = write(f); err != nil {
+			log.Printf("quadtree dump %s: %v", path, err)
+		}
+		if err = f.Close(); err != nil {
+			log.Printf("quadtree dump %s: %v", path, err)
+		}
+	}
+	log.Printf("quadtree written to %s and %s", quadTreeJSONPath, quadTreeDOTPath)
+}
@@ -0,0 +1,53 @@
+package sim
+
+import (
+	"time"
+
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/vector"
+)
+
+// TuneWindow is the number of ticks the quadtree tuner measures each parameter set for
+const TuneWindow = tuneWindow
+
+// Tuner exposes the quadtree tuner's search to the tests
+type Tuner struct {
+	t *quadTreeTuner
+}
+
+func NewTuner(maxObj, maxLvl int) Tuner {
+	return Tuner{newTuner(tuneParams{maxObj: maxObj, maxLvl: maxLvl})}
+}
+
+// Observe records the cost of a tick in a tree described by stats
+func (t Tuner) Observe(cost time.Duration, stats quadtree.Stats) (maxObj, maxLvl int, changed bool) {
+	p, changed := t.t.observe(cost, func() quadtree.Stats { return stats })
+	return p.maxObj, p.maxLvl, changed
+}
+
+// Best returns the cheapest parameters found so far
+func (t Tuner) Best() (maxObj, maxLvl int) {
+	return t.t.best.maxObj, t.t.best.maxLvl
+}
+
+// Neighbors returns the parameter sets the tuner would try next around the best one
+func (t Tuner) Neighbors(stats quadtree.Stats) [][2]int {
+	var res [][2]int
+	for _, p := range t.t.neighbors(stats) {
+		res = append(res, [2]int{p.maxObj, p.maxLvl})
+	}
+	return res
+}
+
+// LongRangeForce returns the long-range force on boid i in the current state with the given
+// opening angle
+func (w *World) LongRangeForce(i int, theta float64) vector.Vec2 {
+	w.prepareLongRange()
+	f := w.flock
+	return w.longRangeForceWithin(f.Position(i), f.Species[i], theta)
+}
+
+// AutoTuning reports whether the quadtree tuner is running
+func (w *World) AutoTuning() bool {
+	return w.tuner != nil
+}
@@ -0,0 +1,149 @@
+package sim
+
+import (
+	"math"
+	"slices"
+
+	"github.com/OutOfStack/boids/vector"
+)
+
+// Flock stores the boids as a structure of arrays: boid i is at (X[i], Y[i]) moving with
+// (VX[i], VY[i]). The hot per-tick data is contiguous, the cold data lives in separate arrays.
+// Slots are reordered by spatial cell from time to time, so ID identifies a boid across reorders.
+type Flock struct {
+	X, Y    []float64
+	VX, VY  []float64
+	ID      []int64 // stable identifier of the boid in each slot
+	Species []uint8 // index into SpeciesColors; boids flock with their own species
+}
+
+// newFlock returns an empty flock with capacity for n boids
+func newFlock(n int) *Flock {
+	return &Flock{
+		X:       make([]float64, 0, n),
+		Y:       make([]float64, 0, n),
+		VX:      make([]float64, 0, n),
+		VY:      make([]float64, 0, n),
+		ID:      make([]int64, 0, n),
+		Species: make([]uint8, 0, n),
+	}
+}
+
+// Len returns the number of boids
+func (f *Flock) Len() int {
+	return len(f.X)
+}
+
+// Position returns the position of the boid in slot i
+func (f *Flock) Position(i int) vector.Vec2 {
+	return vector.V(f.X[i], f.Y[i])
+}
+
+// Velocity returns the velocity of the boid in slot i
+func (f *Flock) Velocity(i int) vector.Vec2 {
+	return vector.V(f.VX[i], f.VY[i])
+}
+
+// add appends a boid
+func (f *Flock) add(id int64, pos, vel vector.Vec2, species uint8) {
+	f.X = append(f.X, pos.X)
+	f.Y = append(f.Y, pos.Y)
+	f.VX = append(f.VX, vel.X)
+	f.VY = append(f.VY, vel.Y)
+	f.ID = append(f.ID, id)
+	f.Species = append(f.Species, species)
+}
+
+// buffer returns a flock of the same size sharing the cold arrays, to compute the next state into
+func (f *Flock) buffer() *Flock {
+	n := f.Len()
+	return &Flock{
+		X:       make([]float64, n),
+		Y:       make([]float64, n),
+		VX:      make([]float64, n),
+		VY:      make([]float64, n),
+		ID:      f.ID,
+		Species: f.Species,
+	}
+}
+
+// clone returns a copy of the flock's state sharing the cold arrays
+func (f *Flock) clone() *Flock {
+	return &Flock{
+		X:       slices.Clone(f.X),
+		Y:       slices.Clone(f.Y),
+		VX:      slices.Clone(f.VX),
+		VY:      slices.Clone(f.VY),
+		ID:      f.ID,
+		Species: f.Species,
+	}
+}
+
+// swapState exchanges the positions and velocities of f and other
+func (f *Flock) swapState(other *Flock) {
+	f.X, other.X = other.X, f.X
+	f.Y, other.Y = other.Y, f.Y
+	f.VX, other.VX = other.VX, f.VX
+	f.VY, other.VY = other.VY, f.VY
+}
+
+// cellOrder returns the slots sorted by the Z-order (Morton) code of their grid cell, so boids
+// sharing a cell, and mostly those in nearby cells, end up next to each other in memory
+func (f *Flock) cellOrder(cellSize float64) []int {
+	type keyed struct {
+		code uint64
+		slot int
+	}
+	keys := make([]keyed, f.Len())
+	for i := range keys {
+		cx := uint32(max(math.Floor(f.X[i]/cellSize), 0))
+		cy := uint32(max(math.Floor(f.Y[i]/cellSize), 0))
+		keys[i] = keyed{code: interleave(cx) | interleave(cy)<<1, slot: i}
+	}
+	slices.SortFunc(keys, func(a, b keyed) int {
+		if a.code != b.code {
+			if a.code < b.code {
+				return -1
+			}
+			return 1
+		}
+		return a.slot - b.slot
+	})
+
+	order := make([]int, len(keys))
+	for i, k := range keys {
+		order[i] = k.slot
+	}
+	return order
+}
+
+// interleave spreads the bits of v to the even bit positions of the result
+func interleave(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// permuteState moves the state of slot order[i] to slot i, using scratch's state arrays as
+// temporary storage
+func (f *Flock) permuteState(order []int, scratch *Flock) {
+	for i, s := range order {
+		scratch.X[i], scratch.Y[i] = f.X[s], f.Y[s]
+		scratch.VX[i], scratch.VY[i] = f.VX[s], f.VY[s]
+	}
+	f.swapState(scratch)
+}
+
+// permuteCold moves the cold data of slot order[i] to slot i, in place
+func (f *Flock) permuteCold(order []int) {
+	ids := slices.Clone(f.ID)
+	species := slices.Clone(f.Species)
+	for i, s := range order {
+		f.ID[i] = ids[s]
+		f.Species[i] = species[s]
+	}
+}
@@ -0,0 +1,312 @@
+package sim
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// historyKeyEvery is the number of ticks between key frames of the rewind history; reaching
+	// a tick decodes at most this many differences
+	historyKeyEvery = 50
+	// defaultRewindMaxMB caps the rewind history when rewind_max_mb is not set
+	defaultRewindMaxMB = 256
+)
+
+// history keeps the most recent states for rewinding: a full key frame every historyKeyEvery
+// ticks and, in between, the bitwise difference from the previous tick. Differences are lossless,
+// so the simulation can resume from any stored tick exactly. It is shared between the ticking
+// goroutine and readers such as a renderer.
+type history struct {
+	mu       sync.Mutex
+	frames   []historyFrame // consecutive ticks, starting with a key frame
+	size     int            // bytes held by frames
+	maxTicks int64
+	maxBytes int
+	// step and world size, to predict positions from velocities
+	dt, width, height float64
+
+	base     *Flock // newest stored state, the base of the next difference
+	forceKey bool   // the next frame must be a key frame because boids changed slots
+	raw      []byte
+	zbuf     bytes.Buffer
+	zw       *flate.Writer
+}
+
+// historyFrame is the state after one tick
+type historyFrame struct {
+	tick  int64
+	rng   []byte
+	key   *Flock // full state of key frames
+	delta []byte // otherwise the compressed difference from the previous frame
+}
+
+// newHistory returns the rewind history configured for the world, nil when rewinding is disabled
+func (w *World) newHistory() *history {
+	cfg := w.cfg
+	if cfg.RewindSeconds <= 0 {
+		return nil
+	}
+	maxMB := cfg.RewindMaxMB
+	if maxMB <= 0 {
+		maxMB = defaultRewindMaxMB
+	}
+	zw, _ := flate.NewWriter(nil, flate.BestSpeed) // valid level, never fails
+	return &history{
+		maxTicks: int64(math.Ceil(cfg.RewindSeconds * float64(time.Second) / float64(w.Step()))),
+		maxBytes: maxMB << 20,
+		dt:       w.Step().Seconds(),
+		width:    float64(cfg.Width),
+		height:   float64(cfg.Height),
+		zw:       zw,
+	}
+}
+
+// deepClone returns a copy of the flock that shares nothing with it
+func (f *Flock) deepClone() *Flock {
+	c := f.clone()
+	c.ID, c.Species = slices.Clone(f.ID), slices.Clone(f.Species)
+	return c
+}
+
+// values returns the state arrays in a fixed order
+func (f *Flock) values() [4][]float64 {
+	return [4][]float64{f.X, f.Y, f.VX, f.VY}
+}
+
+// keyNext makes the next frame a key frame; called when boids change slots
+func (h *history) keyNext() {
+	h.mu.Lock()
+	h.forceKey = true
+	h.mu.Unlock()
+}
+
+// push stores the state after a tick
+func (h *history) push(tick int64, src []byte, f *Flock) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := len(h.frames)
+	if n > 0 && (tick != h.frames[n-1].tick+1 || f.Len() != h.base.Len()) {
+		// the timeline jumped, e.g. a snapshot was loaded: start over
+		h.frames, h.size = nil, 0
+		n = 0
+	}
+	fr := historyFrame{tick: tick, rng: src}
+	if n == 0 || h.forceKey || tick-h.lastKey().tick >= historyKeyEvery {
+		fr.key = f.deepClone()
+		h.base = f.clone()
+		h.size += f.Len() * (4*8 + 8 + 1)
+		h.forceKey = false
+	} else {
+		fr.delta = h.encode(f)
+		h.size += len(fr.delta)
+	}
+	h.size += len(fr.rng)
+	h.frames = append(h.frames, fr)
+	h.evict()
+}
+
+// lastKey returns the newest key frame
+func (h *history) lastKey() *historyFrame {
+	for i := len(h.frames) - 1; ; i-- {
+		if h.frames[i].key != nil {
+			return &h.frames[i]
+		}
+	}
+}
+
+// evict drops the oldest key frame and its differences while the rest still covers the kept time,
+// or while the history takes more memory than allowed; the newest key frame is always kept
+func (h *history) evict() {
+	for {
+		next := slices.IndexFunc(h.frames[1:], func(fr historyFrame) bool { return fr.key != nil }) + 1
+		if next == 0 {
+			return
+		}
+		newest := h.frames[len(h.frames)-1].tick
+		if newest-h.frames[next].tick < h.maxTicks && h.size <= h.maxBytes {
+			return
+		}
+		for _, fr := range h.frames[:next] {
+			h.size -= fr.bytes()
+		}
+		h.frames = slices.Delete(h.frames, 0, next)
+	}
+}
+
+// bytes returns the memory taken by the frame's data
+func (fr *historyFrame) bytes() int {
+	if fr.key != nil {
+		return fr.key.Len()*(4*8+8+1) + len(fr.rng)
+	}
+	return len(fr.delta) + len(fr.rng)
+}
+
+// predict returns the values the positions are stored relative to: the previous position moved by
+// the new velocity, as the simulation does, so the difference is mostly zero. Velocities are
+// stored relative to the previous ones.
+func (h *history) predict(base [4][]float64, k, i int, vel [4][]float64) float64 {
+	switch k {
+	case 0:
+		return wrapCoord(base[0][i]+vel[2][i]*h.dt, h.width)
+	case 1:
+		return wrapCoord(base[1][i]+vel[3][i]*h.dt, h.height)
+	}
+	return base[k][i]
+}
+
+// wrapCoord maps a coordinate that left the world by less than its size back into it
+func wrapCoord(v, size float64) float64 {
+	if v < 0 {
+		return v + size
+	} else if v >= size {
+		return v - size
+	}
+	return v
+}
+
+// encode returns the difference between f and the base and makes f the new base. Values are
+// XORed bitwise with their prediction and the bytes regrouped by significance, so the mostly
+// zero high bytes compress well.
+func (h *history) encode(f *Flock) []byte {
+	n := f.Len()
+	h.raw = slices.Grow(h.raw[:0], 4*8*n)[:4*8*n]
+	base, vals := h.base.values(), f.values()
+	// velocities first, positions are predicted from them
+	for _, k := range [4]int{2, 3, 0, 1} {
+		for i, v := range vals[k] {
+			x := math.Float64bits(v) ^ math.Float64bits(h.predict(base, k, i, vals))
+			for b := range 8 {
+				h.raw[(b*4+k)*n+i] = byte(x >> (8 * b))
+			}
+		}
+	}
+	for k := range base {
+		copy(base[k], vals[k])
+	}
+	h.zbuf.Reset()
+	h.zw.Reset(&h.zbuf)
+	_, _ = h.zw.Write(h.raw) // writes to memory, never fails
+	_ = h.zw.Close()
+	return bytes.Clone(h.zbuf.Bytes())
+}
+
+// decode applies a difference made by encode to f, the state of the previous tick
+func (h *history) decode(delta []byte, f *Flock) error {
+	n := f.Len()
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(delta)))
+	if err != nil || len(raw) != 4*8*n {
+		return errors.New("corrupt rewind history")
+	}
+	vals := f.values()
+	for _, k := range [4]int{2, 3, 0, 1} {
+		for i := range vals[k] {
+			var x uint64
+			for b := range 8 {
+				x |= uint64(raw[(b*4+k)*n+i]) << (8 * b)
+			}
+			// vals still holds the previous positions while velocities are already new
+			vals[k][i] = math.Float64frombits(math.Float64bits(h.predict(vals, k, i, vals)) ^ x)
+		}
+	}
+	return nil
+}
+
+// span returns the oldest and newest stored ticks
+func (h *history) span() (first, last int64, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.frames) == 0 {
+		return 0, 0, false
+	}
+	return h.frames[0].tick, h.frames[len(h.frames)-1].tick, true
+}
+
+// state reconstructs the boids and the random state after the given tick
+func (h *history) state(tick int64) (*Flock, []byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.frames) == 0 || tick < h.frames[0].tick || tick > h.frames[len(h.frames)-1].tick {
+		return nil, nil, fmt.Errorf("tick %d is not in the rewind history", tick)
+	}
+	i := int(tick - h.frames[0].tick)
+	k := i
+	for h.frames[k].key == nil {
+		k--
+	}
+	f := h.frames[k].key.deepClone()
+	for _, fr := range h.frames[k+1 : i+1] {
+		if err := h.decode(fr.delta, f); err != nil {
+			return nil, nil, err
+		}
+	}
+	return f, h.frames[i].rng, nil
+}
+
+// truncate forgets the frames after tick, which becomes the newest one, so the timeline can branch
+func (h *history) truncate(tick int64, f *Flock) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := int(tick - h.frames[0].tick)
+	for _, fr := range h.frames[i+1:] {
+		h.size -= fr.bytes()
+	}
+	h.frames = h.frames[:i+1]
+	h.base = f.clone()
+}
+
+// HistorySpan returns the oldest and newest ticks the world can be rewound to;
+// ok is false when rewinding is disabled
+func (w *World) HistorySpan() (first, last int64, ok bool) {
+	w.mu.RLock()
+	h := w.history
+	w.mu.RUnlock()
+	if h == nil {
+		return 0, 0, false
+	}
+	return h.span()
+}
+
+// HistoryFlock returns the boids as they were after a tick in the rewind history
+func (w *World) HistoryFlock(tick int64) (*Flock, error) {
+	w.mu.RLock()
+	h := w.history
+	w.mu.RUnlock()
+	if h == nil {
+		return nil, errors.New("rewinding is disabled")
+	}
+	f, _, err := h.state(tick)
+	return f, err
+}
+
+// rewind resumes the world from an earlier tick in the history; the later ticks are forgotten,
+// so the simulation branches off from there
+func (w *World) rewind(to int64) error {
+	h := w.history
+	if h == nil {
+		return errors.New("rewinding is disabled")
+	}
+	f, src, err := h.state(to)
+	if err != nil {
+		return err
+	}
+	restored, err := Restore(&Snapshot{
+		Version: snapshotVersion, Tick: to, RNG: src, Config: *w.cfg,
+		ID: f.ID, Species: f.Species, X: f.X, Y: f.Y, VX: f.VX, VY: f.VY,
+	})
+	if err != nil {
+		return err
+	}
+	h.truncate(to, f)
+	restored.history = h
+	w.adopt(restored)
+	return nil
+}
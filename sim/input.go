@@ -0,0 +1,108 @@
+package sim
+
+import (
+	"bytes"
+	"log"
+)
+
+// Input kinds
+const (
+	// InputSaveSnapshot writes a snapshot to Path
+	InputSaveSnapshot = "save_snapshot"
+	// InputLoadSnapshot replaces the world state with the encoded snapshot in Snapshot
+	InputLoadSnapshot = "load_snapshot"
+	// InputDumpQuadTree logs quadtree statistics and writes the tree to files
+	InputDumpQuadTree = "dump_quadtree"
+	// InputRewind resumes the simulation from tick To of the rewind history, dropping the later ticks
+	InputRewind = "rewind"
+)
+
+// Input is an interactive command applied to the world between two steps, so that it takes
+// effect at a well-defined tick and can be recorded and replayed
+type Input struct {
+	Tick     int64  `json:"tick"` // tick at which the input was applied, set by the world
+	Kind     string `json:"kind"`
+	Path     string `json:"path,omitempty"`
+	To       int64  `json:"to,omitempty"`
+	Snapshot []byte `json:"snapshot,omitempty"`
+}
+
+// Send queues an input to be applied before the next step; safe to call from any goroutine
+func (w *World) Send(in Input) {
+	w.inputMu.Lock()
+	w.inputs = append(w.inputs, in)
+	w.inputMu.Unlock()
+}
+
+// applyInputs applies the queued inputs at the current tick
+func (w *World) applyInputs() {
+	w.inputMu.Lock()
+	inputs := w.inputs
+	w.inputs = nil
+	w.inputMu.Unlock()
+
+	for _, in := range inputs {
+		in.Tick = w.ticks
+		if w.recorder != nil {
+			w.recorder.input(in)
+		}
+		w.apply(in)
+	}
+}
+
+// apply carries out an input; side effects outside the world are skipped while replaying
+func (w *World) apply(in Input) {
+	switch in.Kind {
+	case InputLoadSnapshot:
+		s, err := ReadSnapshot(bytes.NewReader(in.Snapshot))
+		if err != nil {
+			log.Printf("load snapshot: %v", err)
+			return
+		}
+		restored, err := Restore(s)
+		if err != nil {
+			log.Printf("load snapshot: %v", err)
+			return
+		}
+		w.adopt(restored)
+		log.Printf("snapshot loaded at tick %d", in.Tick)
+	case InputSaveSnapshot:
+		if w.replaying {
+			return
+		}
+		if err := SaveSnapshot(in.Path, w.Snapshot()); err != nil {
+			log.Printf("save snapshot: %v", err)
+			return
+		}
+		log.Printf("snapshot of tick %d saved to %s", in.Tick, in.Path)
+	case InputRewind:
+		if err := w.rewind(in.To); err != nil {
+			log.Printf("rewind: %v", err)
+			return
+		}
+		log.Printf("rewound from tick %d to %d", in.Tick, in.To)
+	case InputDumpQuadTree:
+		if !w.replaying {
+			w.DumpQuadTree()
+		}
+	default:
+		log.Printf("unknown input %q", in.Kind)
+	}
+}
+
+// adopt takes over the state of other, which must not be used afterwards
+func (w *World) adopt(other *World) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cfg, w.src, w.rng = other.cfg, other.src, other.rng
+	w.flock, w.prev, w.next = other.flock, other.prev, other.next
+	w.ticks = other.ticks
+	w.index, w.neighbors, w.tuner, w.obstacles = other.index, other.neighbors, other.tuner, other.obstacles
+	// keeping the parallelism set on this world
+	w.scratch = other.scratch[:min(len(other.scratch), len(w.scratch))]
+	w.history = other.history
+	// the metrics buffers are sized for the boids, and flocks are followed afresh
+	w.nbCount, w.nbNearest, w.edges = other.nbCount, other.nbNearest, other.edges
+	w.calc, w.metrics = other.calc, other.metrics
+	// recordings and metrics exports go on across the switch, with the restored ticks
+}
@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/vector"
+)
+
+// defaultLongRangeTheta is the Barnes–Hut opening angle used when none is configured
+const defaultLongRangeTheta = 0.5
+
+// longRangeTree returns the quadtree used for long-range forces, nil when they are disabled
+// or the configured spatial index keeps no aggregates
+func (w *World) longRangeTree() *quadtree.QuadTree {
+	if w.cfg.LongRangeWeight == 0 {
+		return nil
+	}
+	return w.QuadTree()
+}
+
+// prepareLongRange refreshes the quadtree aggregates of each species from the current state for
+// this tick's long-range forces
+func (w *World) prepareLongRange() {
+	if qt := w.longRangeTree(); qt != nil {
+		f := w.flock
+		qt.ComputeAggregates(len(SpeciesColors), func(obj *quadtree.Object) int {
+			return int(f.Species[obj.ID])
+		}, func(obj *quadtree.Object) vector.Vec2 {
+			return vector.V(f.VX[obj.ID], f.VY[obj.ID])
+		})
+	}
+}
+
+// longRangeForce approximates the pull of all boids of a species beyond view radius with a
+// Barnes–Hut walk. Each distant boid pulls with inverse-distance falloff; the sum is normalized
+// by the number of boids of the species and scaled by long_range_weight, so a negative weight
+// turns cohesion into avoidance.
+func (w *World) longRangeForce(selfPos vector.Vec2, species uint8) vector.Vec2 {
+	theta := w.cfg.LongRangeTheta
+	if theta <= 0 {
+		theta = defaultLongRangeTheta
+	}
+	return w.longRangeForceWithin(selfPos, species, theta)
+}
+
+// longRangeForceWithin is longRangeForce with the given opening angle; 0 sums every boid exactly
+func (w *World) longRangeForceWithin(selfPos vector.Vec2, species uint8, theta float64) vector.Vec2 {
+	qt := w.longRangeTree()
+	if qt == nil {
+		return vector.Vec2{}
+	}
+	cfg := w.cfg
+	width, height := float64(cfg.Width), float64(cfg.Height)
+	r2 := cfg.ViewRadius * cfg.ViewRadius
+
+	force := vector.Vec2{}
+	// nodes reaching into the view radius are opened, so the boid itself and its flockmates,
+	// which the flocking rules handle, are reported on their own and skipped
+	qt.BarnesHutFunc(selfPos, theta, cfg.ViewRadius, int(species), func(agg quadtree.Aggregate) bool {
+		d := wrapOffset(agg.Center.Sub(selfPos), width, height)
+		dist2 := d.X*d.X + d.Y*d.Y
+		if dist2 <= r2 {
+			return true
+		}
+		// unit direction over distance: d/|d|^2
+		force = force.Add(d.Scale(float64(agg.Count) / dist2))
+		return true
+	})
+
+	total := qt.Aggregate(int(species)).Count
+	if total == 0 {
+		return vector.Vec2{}
+	}
+	return force.Scale(cfg.LongRangeWeight / float64(total))
+}
@@ -0,0 +1,126 @@
+package sim
+
+import (
+	"log"
+	"slices"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/metrics"
+)
+
+// maxFlockEvents bounds the flock events kept until DrainFlockEvents is called
+const maxFlockEvents = 1000
+
+// metricsExport writes the metrics of every few ticks as a time series
+type metricsExport struct {
+	exporter *metrics.Exporter
+	every    int64
+}
+
+// ExportMetrics writes the metrics of every tick divisible by every to e, from the next step on.
+// The world must not be ticking.
+func (w *World) ExportMetrics(e *metrics.Exporter, every int64) {
+	w.export = &metricsExport{exporter: e, every: max(every, 1)}
+}
+
+// StopMetricsExport stops writing metrics. The world must not be ticking.
+func (w *World) StopMetricsExport() {
+	w.export = nil
+}
+
+// exportMetrics writes the metrics of a tick if it is due, ending the export on errors
+func (w *World) exportMetrics(m *metrics.Frame) {
+	x := w.export
+	if m.Tick%x.every != 0 {
+		return
+	}
+	if err := x.exporter.Write(m, float64(m.Tick)*w.Step().Seconds()); err != nil {
+		log.Printf("%v; metrics export stopped", err)
+		w.export = nil
+	}
+}
+
+// computeMetrics measures the current state from what the neighbor search of this tick found
+func (w *World) computeMetrics() metrics.Frame {
+	w.edges = w.edges[:0]
+	for _, s := range w.scratch {
+		w.edges = append(w.edges, s.edges...)
+	}
+	f := w.flock
+	cfg := w.cfg
+	return w.calc.Compute(w.ticks, metrics.Input{
+		ID: f.ID, X: f.X, Y: f.Y, VX: f.VX, VY: f.VY, Species: f.Species,
+		Neighbors: w.nbCount, Nearest: w.nbNearest, Edges: w.edges,
+		Width: float64(cfg.Width), Height: float64(cfg.Height), MinFlockSize: cfg.MinFlockSize,
+	}, len(SpeciesColors))
+}
+
+// SetMetricsEnabled turns the metrics on or off from the next step on; new worlds compute them.
+// While they are off, steps skip the work, Metrics returns the last ones computed and no flock
+// events are logged. Exported metrics are computed regardless.
+func (w *World) SetMetricsEnabled(on bool) {
+	w.metricsOff.Store(!on)
+}
+
+// Metrics returns a copy of the metrics of the state the last step started from; they are
+// computed alongside the neighbor search, so they trail the current state by one tick
+func (w *World) Metrics() metrics.Frame {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.metrics.Clone()
+}
+
+// ReadMetrics calls fn with the metrics Metrics returns, without copying them; fn must not keep
+// the frame or its slices
+func (w *World) ReadMetrics(fn func(m *metrics.Frame)) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	fn(&w.metrics)
+}
+
+// Measurer computes the metrics of states that were not simulated in a world, such as recorded
+// frames or ticks of the rewind history, exactly as a world at that state would. Flocks are
+// followed from one measured state to the next.
+type Measurer struct {
+	cfg  config.Config
+	calc metrics.Calculator
+}
+
+// NewMeasurer returns a measurer for states of a world configured by cfg
+func NewMeasurer(cfg *config.Config) *Measurer {
+	m := &Measurer{cfg: *cfg}
+	// the throwaway worlds are never rewound
+	m.cfg.RewindSeconds = 0
+	return m
+}
+
+// Measure returns the metrics of the boids as they were at the given tick; f is not modified
+func (m *Measurer) Measure(tick int64, f *Flock) metrics.Frame {
+	cfg := m.cfg
+	cfg.BoidsCount = int64(f.Len())
+	// the metrics come out of the neighbor search of a step from the state
+	w := &World{cfg: &cfg, ticks: tick, restored: true}
+	w.src, w.rng = newRand(cfg.Seed)
+	w.init(f.deepClone())
+	w.calc = m.calc
+	w.Tick()
+	m.calc = w.calc
+	return w.metrics.Clone()
+}
+
+// DrainFlockEvents returns the flock events since the last call, at most the latest maxFlockEvents
+func (w *World) DrainFlockEvents() []metrics.Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := w.flockEvents
+	w.flockEvents = nil
+	return events
+}
+
+// logFlockEvents keeps the events of a tick for DrainFlockEvents; the caller holds the lock
+func (w *World) logFlockEvents(events []metrics.Event) {
+	w.flockEvents = append(w.flockEvents, events...)
+	if extra := len(w.flockEvents) - maxFlockEvents; extra > 0 {
+		w.flockEvents = slices.Delete(w.flockEvents, 0, extra)
+	}
+}
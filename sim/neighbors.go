@@ -0,0 +1,85 @@
+package sim
+
+import (
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/spatial"
+	"github.com/OutOfStack/boids/vector"
+)
+
+// neighborList is a Verlet neighbor list: for every boid it caches the IDs of boids within
+// view_radius + skin. As long as no boid has moved more than skin/2 since the list was built,
+// no pair could have closed in from beyond the skin, so filtering the cached candidates by
+// view_radius finds exactly the boids a fresh spatial query would.
+type neighborList struct {
+	skin   float64
+	refPos []vector.Vec2 // positions at the last rebuild
+	start  []int         // candidates of boid i are ids[start[i]:start[i+1]]
+	ids    []int64
+	stamp  []int // scratch: stamp[id] == i+1 when id is already listed for boid i
+}
+
+// newNeighborList returns a neighbor list cache if it is enabled in config.
+// Only metric neighbor mode can use it: the k nearest boids are not bounded by a radius.
+func (w *World) newNeighborList() *neighborList {
+	cfg := w.cfg
+	if cfg.NeighborSkin <= 0 || cfg.NeighborMode == config.NeighborModeTopological {
+		return nil
+	}
+	return &neighborList{skin: cfg.NeighborSkin}
+}
+
+// stale reports whether some boid has moved more than skin/2 since the last rebuild
+func (nl *neighborList) stale(f *Flock, width, height float64) bool {
+	if len(nl.refPos) != f.Len() {
+		return true
+	}
+	limit2 := nl.skin * nl.skin / 4
+	for i, ref := range nl.refPos {
+		// positions wrap around, so measure displacement on the torus
+		d := wrapOffset(f.Position(i).Sub(ref), width, height)
+		if d.X*d.X+d.Y*d.Y > limit2 {
+			return true
+		}
+	}
+	return false
+}
+
+// rebuild queries the spatial index for candidates within viewRadius + skin of every boid
+func (nl *neighborList) rebuild(index spatial.Index, f *Flock, viewRadius float64) {
+	radius := viewRadius + nl.skin
+
+	nl.refPos = nl.refPos[:0]
+	for i := range f.Len() {
+		nl.refPos = append(nl.refPos, f.Position(i))
+	}
+	nl.start = append(nl.start[:0], 0)
+	nl.ids = nl.ids[:0]
+	if len(nl.stamp) != f.Len() {
+		nl.stamp = make([]int, f.Len())
+	} else {
+		clear(nl.stamp)
+	}
+
+	for i, p := range nl.refPos {
+		index.QueryCircleFunc(p, radius, func(obj *quadtree.Object) bool {
+			// skip self and ghosts of boids already listed
+			if obj.ID != int64(i) && nl.stamp[obj.ID] != i+1 {
+				nl.stamp[obj.ID] = i + 1
+				nl.ids = append(nl.ids, obj.ID)
+			}
+			return true
+		})
+		nl.start = append(nl.start, len(nl.ids))
+	}
+}
+
+// invalidate forces a rebuild on the next tick, e.g. after boid slots were reordered
+func (nl *neighborList) invalidate() {
+	nl.refPos = nl.refPos[:0]
+}
+
+// of returns the cached candidate IDs of boid i
+func (nl *neighborList) of(i int) []int64 {
+	return nl.ids[nl.start[i]:nl.start[i+1]]
+}
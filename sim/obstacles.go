@@ -0,0 +1,35 @@
+package sim
+
+import (
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/vector"
+)
+
+// newObstacleIndex builds a loose quadtree over the configured obstacles
+func (w *World) newObstacleIndex() *quadtree.LooseQuadTree {
+	cfg := w.cfg
+	if len(cfg.Obstacles) == 0 {
+		return nil
+	}
+	bounds := quadtree.Bounds{X: 0, Y: 0, Width: float64(cfg.Width), Height: float64(cfg.Height)}
+	lt := quadtree.NewLooseQuadTree(bounds, cfg.QuadtreeMaxLvl, quadtree.DefaultLooseFactor)
+	for i, o := range cfg.Obstacles {
+		lt.Insert(quadtree.NewRectObject(int64(i), quadtree.Bounds{X: o.X, Y: o.Y, Width: o.Width, Height: o.Height}))
+	}
+	return lt
+}
+
+// avoidObstacles casts a ray ahead along the velocity and steers away from the surface it hits,
+// harder the closer the hit; clamped like the border bounce
+func (w *World) avoidObstacles(pos, vel vector.Vec2) vector.Vec2 {
+	lookAhead := w.cfg.LookAhead
+	if w.obstacles == nil || lookAhead <= 0 {
+		return vector.Vec2{}
+	}
+	hit, ok := w.obstacles.RayCast(pos, vel, lookAhead)
+	if !ok {
+		return vector.Vec2{}
+	}
+	maxForce := 1.0
+	return hit.Normal.Scale(maxForce * (1 - hit.Dist/lookAhead))
+}
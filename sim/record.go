@@ -0,0 +1,216 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/OutOfStack/boids/config"
+)
+
+// recordingVersion is the version of the recording layout written by this code
+const recordingVersion = 1
+
+// A recording is newline-delimited JSON: a header line, then one line per input and per state
+// hash checkpoint in tick order, and a final line marking the tick the run ended at.
+
+// recordHeader is the first line of a recording
+type recordHeader struct {
+	Version   int           `json:"version"`
+	Seed      int64         `json:"seed"`
+	Config    config.Config `json:"config"`
+	HashEvery int64         `json:"hash_every"`
+	// Snapshot holds the starting state when the run did not start from a fresh world
+	Snapshot []byte `json:"snapshot,omitempty"`
+}
+
+// recordLine is any line after the header
+type recordLine struct {
+	Tick  int64  `json:"tick"`
+	Input *Input `json:"input,omitempty"`
+	Hash  string `json:"hash,omitempty"`
+	End   bool   `json:"end,omitempty"`
+}
+
+// recorder writes the inputs and state hashes of a running world
+type recorder struct {
+	bw        *bufio.Writer
+	enc       *json.Encoder
+	hashEvery int64
+	err       error // first write error, reported by StopRecording
+}
+
+func (r *recorder) write(v any) {
+	if r.err == nil {
+		r.err = r.enc.Encode(v)
+	}
+}
+
+func (r *recorder) input(in Input) {
+	r.write(recordLine{Tick: in.Tick, Input: &in})
+}
+
+func (r *recorder) checkpoint(tick int64, hash uint64) {
+	r.write(recordLine{Tick: tick, Hash: strconv.FormatUint(hash, 16)})
+}
+
+// Record starts writing a recording of the world to out: the seed and config, every input with
+// the tick it was applied at, and a hash of the world state every hashEvery ticks
+func (w *World) Record(out io.Writer, hashEvery int64) error {
+	if hashEvery <= 0 {
+		return errors.New("hash interval must be positive")
+	}
+	h := recordHeader{Version: recordingVersion, Seed: w.cfg.Seed, Config: *w.cfg, HashEvery: hashEvery}
+	if w.ticks != 0 || w.restored {
+		var buf bytes.Buffer
+		if err := w.Snapshot().WriteBinary(&buf); err != nil {
+			return err
+		}
+		h.Snapshot = buf.Bytes()
+	}
+
+	r := &recorder{bw: bufio.NewWriter(out), hashEvery: hashEvery}
+	r.enc = json.NewEncoder(r.bw)
+	r.write(h)
+	r.checkpoint(w.ticks, w.StateHash())
+	if r.err != nil {
+		return r.err
+	}
+	w.recorder = r
+	return nil
+}
+
+// StopRecording marks the end of the recording at the current tick and flushes it.
+// The world must not be ticking.
+func (w *World) StopRecording() error {
+	r := w.recorder
+	if r == nil {
+		return nil
+	}
+	w.recorder = nil
+	r.write(recordLine{Tick: w.ticks, End: true})
+	if r.err != nil {
+		return r.err
+	}
+	return r.bw.Flush()
+}
+
+// StateHash returns an FNV-1a hash of the tick, the random state and the boids in slot order
+func (w *World) StateHash() uint64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	h := fnv.New64a()
+	buf := make([]byte, 0, 4096)
+	flush := func() {
+		_, _ = h.Write(buf) // never fails
+		buf = buf[:0]
+	}
+	rng, _ := w.src.MarshalBinary() // never fails
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(w.ticks))
+	buf = append(buf, rng...)
+	flush()
+
+	f := w.flock
+	for i := range f.Len() {
+		if len(buf) > cap(buf)-48 {
+			flush()
+		}
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(f.ID[i]))
+		buf = append(buf, f.Species[i])
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f.X[i]))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f.Y[i]))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f.VX[i]))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f.VY[i]))
+	}
+	flush()
+	return h.Sum64()
+}
+
+// ReplayResult is the outcome of a replay
+type ReplayResult struct {
+	Ticks       int64 // tick the replay stopped at
+	Checkpoints int   // state hashes that matched
+	Diverged    bool
+	// DivergedTick is the first checkpoint whose state differs from the recording; the
+	// divergence happened after LastMatch, the checkpoint before it
+	DivergedTick int64
+	LastMatch    int64
+	Want, Got    uint64
+}
+
+// Replay re-runs a recording, feeding back its inputs at the recorded ticks, and compares the
+// state hashes with the recorded ones. It stops at the first mismatch or at the end of the recording.
+func Replay(in io.Reader) (ReplayResult, error) {
+	var res ReplayResult
+	dec := json.NewDecoder(bufio.NewReader(in))
+	var h recordHeader
+	if err := dec.Decode(&h); err != nil {
+		return res, fmt.Errorf("read recording header: %w", err)
+	}
+	if h.Version != recordingVersion {
+		return res, fmt.Errorf("unsupported recording version %d", h.Version)
+	}
+
+	var w *World
+	if len(h.Snapshot) > 0 {
+		s, err := ReadSnapshot(bytes.NewReader(h.Snapshot))
+		if err != nil {
+			return res, fmt.Errorf("recording start: %w", err)
+		}
+		if w, err = Restore(s); err != nil {
+			return res, fmt.Errorf("recording start: %w", err)
+		}
+	} else {
+		cfg := h.Config
+		cfg.Seed = h.Seed
+		w = NewWorld(&cfg)
+	}
+	w.replaying = true
+	res.LastMatch = w.ticks
+
+	for {
+		var line recordLine
+		err := dec.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			// recording cut short, e.g. the recording process was killed
+			break
+		}
+		if err != nil {
+			return res, fmt.Errorf("read recording: %w", err)
+		}
+
+		// inputs and checkpoints of a tick are written before it is stepped over
+		for w.ticks < line.Tick {
+			w.Tick()
+		}
+		res.Ticks = w.ticks
+
+		switch {
+		case line.End:
+			return res, nil
+		case line.Input != nil:
+			w.Send(*line.Input)
+			w.applyInputs()
+		case line.Hash != "":
+			want, err := strconv.ParseUint(line.Hash, 16, 64)
+			if err != nil {
+				return res, fmt.Errorf("recording tick %d: bad hash %q", line.Tick, line.Hash)
+			}
+			if got := w.StateHash(); got != want {
+				res.Diverged, res.DivergedTick, res.Want, res.Got = true, line.Tick, want, got
+				return res, nil
+			}
+			res.Checkpoints++
+			res.LastMatch = line.Tick
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,911 @@
+package sim_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/sim"
+	"github.com/OutOfStack/boids/spatial"
+	"github.com/OutOfStack/boids/trajectory"
+	"github.com/OutOfStack/boids/vector"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Width:          200,
+		Height:         150,
+		BoidsCount:     300,
+		ViewRadius:     7,
+		AdjRate:        0.3,
+		QuadtreeMaxObj: 10,
+		QuadtreeMaxLvl: 5,
+		UpdateRateMs:   10,
+		NeighborK:      7,
+		Seed:           1,
+	}
+}
+
+func state(w *sim.World) []vector.Vec2 {
+	w.RLock()
+	defer w.RUnlock()
+	f := w.Flock()
+	s := make([]vector.Vec2, 0, 2*f.Len())
+	for i := range f.Len() {
+		s = append(s, f.Position(i), f.Velocity(i))
+	}
+	return s
+}
+
+func TestTickDeterministic(t *testing.T) {
+	// enough boids to split the compute phase across workers
+	cfg := testConfig()
+	cfg.BoidsCount = 3000
+	a, b := sim.NewWorld(cfg), sim.NewWorld(cfg)
+	// however many goroutines they step on
+	a.SetParallelism(4)
+	b.SetParallelism(1)
+	for range 50 {
+		a.Tick()
+		b.Tick()
+	}
+	sa, sb := state(a), state(b)
+	for i := range sa {
+		if sa[i] != sb[i] {
+			t.Fatalf("worlds with the same seed diverged at value %d: %v != %v", i, sa[i], sb[i])
+		}
+	}
+	if !reflect.DeepEqual(a.Metrics(), b.Metrics()) {
+		t.Error("worlds with the same seed measured different metrics")
+	}
+}
+
+func TestTickKeepsBoidsInBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*config.Config)
+	}{
+		{"quadtree", func(*config.Config) {}},
+		{"grid", func(c *config.Config) { c.SpatialIndex = spatial.KindGrid }},
+		{"hash", func(c *config.Config) { c.SpatialIndex = spatial.KindHash }},
+		{"topological", func(c *config.Config) { c.NeighborMode = config.NeighborModeTopological }},
+		{"neighbor skin", func(c *config.Config) { c.NeighborSkin = 2 }},
+		{"long range", func(c *config.Config) { c.LongRangeWeight = 0.1 }},
+		{"auto tune", func(c *config.Config) { c.QuadtreeAutoTune = true }},
+		{"reorder", func(c *config.Config) { c.ReorderTicks = 7; c.NeighborSkin = 2 }},
+		{"obstacles", func(c *config.Config) {
+			c.Obstacles = []config.Obstacle{{X: 90, Y: 0, Width: 20, Height: 100}}
+			c.LookAhead = 15
+			c.LineOfSight = true
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			tt.edit(cfg)
+			w := sim.NewWorld(cfg)
+			for range 30 {
+				w.Tick()
+			}
+			w.RLock()
+			defer w.RUnlock()
+			f := w.Flock()
+			for i := range f.Len() {
+				p, v := f.Position(i), f.Velocity(i)
+				if !p.IsFinite() || !v.IsFinite() || p.X < 0 || p.X >= float64(cfg.Width) || p.Y < 0 || p.Y >= float64(cfg.Height) {
+					t.Fatalf("boid %d left the world: position %v, velocity %v", f.ID[i], p, v)
+				}
+			}
+		})
+	}
+}
+
+func TestReorderKeepsIdentity(t *testing.T) {
+	cfg := testConfig()
+	cfg.ReorderTicks = 1
+	w, ref := sim.NewWorld(cfg), sim.NewWorld(testConfig())
+	for range 5 {
+		w.Tick()
+	}
+
+	f, rf := w.Flock(), ref.Flock()
+	seen := make(map[int64]bool)
+	for i := range f.Len() {
+		id := f.ID[i]
+		if seen[id] {
+			t.Fatalf("boid %d appears twice after reordering", id)
+		}
+		seen[id] = true
+		if f.Species[i] != rf.Species[id] {
+			t.Fatalf("boid %d changed species after reordering", id)
+		}
+	}
+	if len(seen) != rf.Len() {
+		t.Fatalf("expected %d boids, got %d", rf.Len(), len(seen))
+	}
+}
+
+func TestNeighborListMatchesIndex(t *testing.T) {
+	const skin = 0.5
+	for _, kind := range []string{spatial.KindQuadTree, spatial.KindGrid} {
+		cfg := testConfig()
+		cfg.SpatialIndex = kind
+		// crowded, so that a pair missed by a stale list changes the outcome
+		cfg.BoidsCount = 1000
+		cfg.ReorderTicks = 30
+		direct := sim.NewWorld(cfg)
+		listed := *cfg
+		listed.NeighborSkin = skin
+		cached := sim.NewWorld(&listed)
+
+		start := map[int64]vector.Vec2{}
+		f := direct.Flock()
+		for i := range f.Len() {
+			start[f.ID[i]] = f.Position(i)
+		}
+		var moved float64 // farthest any boid got from its start before the first reorder
+		for tick := range 90 {
+			direct.Tick()
+			cached.Tick()
+			sa, sb := state(direct), state(cached)
+			for i := range sa {
+				if sa[i] != sb[i] {
+					t.Fatalf("%s: tick %d: neighbor list run diverged at value %d: %v != %v", kind, tick, i, sb[i], sa[i])
+				}
+			}
+			if !reflect.DeepEqual(direct.Metrics(), cached.Metrics()) {
+				t.Fatalf("%s: tick %d: neighbor list run saw different flockmates", kind, tick)
+			}
+			if tick < cfg.ReorderTicks-1 {
+				for i := range f.Len() {
+					d := f.Position(i).Sub(start[f.ID[i]])
+					moved = max(moved, math.Hypot(math.Remainder(d.X, float64(cfg.Width)), math.Remainder(d.Y, float64(cfg.Height))))
+				}
+			}
+		}
+		// the run went past several reorders, and the list went stale on its own several times
+		// before the first
+		if moved < 2*skin {
+			t.Fatalf("%s: boids moved at most %v before the first reorder, want several times the rebuild threshold %v",
+				kind, moved, skin/2)
+		}
+	}
+}
+
+func BenchmarkTick(b *testing.B) {
+	for _, kind := range []string{spatial.KindQuadTree, spatial.KindGrid} {
+		for _, reorder := range []int{0, 50} {
+			name := kind
+			if reorder > 0 {
+				name += "/reorder"
+			}
+			b.Run(name, func(b *testing.B) {
+				cfg := testConfig()
+				cfg.Width, cfg.Height = 1600, 1200
+				cfg.BoidsCount = 10000
+				cfg.SpatialIndex = kind
+				cfg.ReorderTicks = reorder
+				w := sim.NewWorld(cfg)
+				for b.Loop() {
+					w.Tick()
+				}
+			})
+		}
+	}
+}
+
+func TestLongRangeMatchesBruteForce(t *testing.T) {
+	cfg := testConfig()
+	cfg.LongRangeWeight = 0.7
+	w := sim.NewWorld(cfg)
+	for range 20 {
+		w.Tick()
+	}
+
+	w.RLock()
+	defer w.RUnlock()
+	f := w.Flock()
+	width, height := float64(cfg.Width), float64(cfg.Height)
+	counts := map[uint8]int{}
+	for i := range f.Len() {
+		counts[f.Species[i]]++
+	}
+	var sumErr float64 // error of the approximation relative to the total pull, summed over boids
+	for i := range f.Len() {
+		// every other boid of the species beyond the view radius pulls with d/|d|^2
+		var want vector.Vec2
+		var pull float64
+		for j := range f.Len() {
+			if j == i || f.Species[j] != f.Species[i] {
+				continue
+			}
+			d := f.Position(j).Sub(f.Position(i))
+			d = vector.V(math.Remainder(d.X, width), math.Remainder(d.Y, height))
+			if dist2 := d.X*d.X + d.Y*d.Y; dist2 > cfg.ViewRadius*cfg.ViewRadius {
+				want = want.Add(d.Scale(1 / dist2))
+				pull += 1 / math.Sqrt(dist2)
+			}
+		}
+		scale := cfg.LongRangeWeight / float64(counts[f.Species[i]])
+		want, pull = want.Scale(scale), pull*scale
+
+		if got := w.LongRangeForce(i, 0); got.Dist(want) > 1e-9*(1+want.Len()) {
+			t.Fatalf("boid %d: exact long-range force %v, brute force %v", i, got, want)
+		}
+		if pull > 0 {
+			sumErr += w.LongRangeForce(i, 0.5).Dist(want) / pull
+		}
+	}
+	if mean := sumErr / float64(f.Len()); mean > 0.05 {
+		t.Errorf("approximation at theta 0.5 off by %.1f%% on average", mean*100)
+	}
+}
+
+func TestSpeedIndependentOfStep(t *testing.T) {
+	// a lone boid with no steering moves in a straight line at its initial velocity
+	positions := make([]vector.Vec2, 0, 2)
+	for _, ms := range []int{10, 5} {
+		cfg := testConfig()
+		cfg.BoidsCount = 1
+		cfg.ViewRadius = 0
+		cfg.UpdateRateMs = ms
+		w := sim.NewWorld(cfg)
+		// 100 ms of simulated time
+		for range 100 / ms {
+			w.Tick()
+		}
+		positions = append(positions, w.Flock().Position(0))
+	}
+	if d := positions[0].Dist(positions[1]); d > 1e-9 {
+		t.Fatalf("positions after the same simulated time differ by %v: %v and %v", d, positions[0], positions[1])
+	}
+}
+
+func TestInterpolated(t *testing.T) {
+	w := sim.NewWorld(testConfig())
+	w.Tick()
+	before := state(w)
+	w.Tick()
+
+	w.RLock()
+	defer w.RUnlock()
+	f := w.Flock()
+	for i := range f.Len() {
+		if p := w.Interpolated(i, 0); p.Dist(before[2*i]) > 1e-9 {
+			t.Fatalf("boid %d at alpha 0: got %v, want previous position %v", i, p, before[2*i])
+		}
+		if p := w.Interpolated(i, 1); p.Dist(f.Position(i)) > 1e-9 {
+			t.Fatalf("boid %d at alpha 1: got %v, want current position %v", i, p, f.Position(i))
+		}
+		p := w.Interpolated(i, 0.5)
+		if p.X < 0 || p.X >= float64(w.Config().Width) || p.Y < 0 || p.Y >= float64(w.Config().Height) {
+			t.Fatalf("boid %d interpolated outside the world: %v", i, p)
+		}
+	}
+}
+
+// tuneCost is a synthetic cost per tick that is lowest at max_obj=32, max_lvl=8
+func tuneCost(maxObj, maxLvl int) time.Duration {
+	return time.Duration(1+math.Abs(math.Log2(float64(maxObj)/32))+math.Abs(float64(maxLvl-8))) * time.Millisecond
+}
+
+func TestTunerClimbsToCheapest(t *testing.T) {
+	tuner := sim.NewTuner(8, 5)
+	stats := quadtree.Stats{Objects: 1000, Leaves: 100, EmptyLeaves: 10}
+	maxObj, maxLvl := 8, 5
+	trials := 0
+	for window := range 60 {
+		for tick := range sim.TuneWindow {
+			o, l, changed := tuner.Observe(tuneCost(maxObj, maxLvl), stats)
+			if changed != (tick == sim.TuneWindow-1 && (o != maxObj || l != maxLvl)) {
+				t.Fatalf("window %d, tick %d: changed %v to max_obj=%d max_lvl=%d", window, tick, changed, o, l)
+			}
+			maxObj, maxLvl = o, l
+		}
+		// once settled, the tuner only leaves the best values for an occasional search
+		if window >= 40 && (maxObj != 32 || maxLvl != 8) {
+			trials++
+		}
+	}
+	if o, l := tuner.Best(); o != 32 || l != 8 {
+		t.Fatalf("settled on max_obj=%d max_lvl=%d, want 32 and 8", o, l)
+	}
+	if trials > 4 {
+		t.Errorf("%d of the last 20 windows tried other values", trials)
+	}
+}
+
+func TestTunerKeepsMarginalCandidates(t *testing.T) {
+	tuner := sim.NewTuner(10, 5)
+	var stats quadtree.Stats
+	maxObj, maxLvl := 10, 5
+	// every other set is cheaper, but by less than the margin
+	for range 5 * sim.TuneWindow {
+		cost := 100 * time.Microsecond
+		if maxObj != 10 || maxLvl != 5 {
+			cost = 97 * time.Microsecond
+		}
+		maxObj, maxLvl, _ = tuner.Observe(cost, stats)
+	}
+	if o, l := tuner.Best(); o != 10 || l != 5 || maxObj != 10 || maxLvl != 5 {
+		t.Fatalf("best max_obj=%d max_lvl=%d, using max_obj=%d max_lvl=%d; want the configured values", o, l, maxObj, maxLvl)
+	}
+}
+
+func TestTunerNeighbors(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxObj, maxLvl int
+		stats          quadtree.Stats
+		want           [][2]int
+	}{
+		{"balanced", 10, 5, quadtree.Stats{Objects: 100, Leaves: 10, EmptyLeaves: 2}, [][2]int{{20, 5}, {5, 5}, {10, 6}, {10, 4}}},
+		{"stuck at the maximum depth", 10, 5, quadtree.Stats{Objects: 100, StuckObjects: 10}, [][2]int{{10, 6}, {20, 5}, {5, 5}, {10, 4}}},
+		{"mostly empty leaves", 10, 5, quadtree.Stats{Objects: 100, Leaves: 10, EmptyLeaves: 6}, [][2]int{{10, 4}, {20, 5}, {5, 5}, {10, 6}}},
+		{"at the limits", 256, 1, quadtree.Stats{}, [][2]int{{128, 1}, {256, 2}}},
+	}
+	for _, tt := range tests {
+		if got := sim.NewTuner(tt.maxObj, tt.maxLvl).Neighbors(tt.stats); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: neighbors %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotResumesIdentically(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*config.Config)
+	}{
+		{"quadtree", func(c *config.Config) { c.ReorderTicks = 7 }},
+		{"hash", func(c *config.Config) { c.SpatialIndex = spatial.KindHash }},
+		{"topological", func(c *config.Config) { c.NeighborMode = config.NeighborModeTopological }},
+		{"neighbor skin", func(c *config.Config) { c.NeighborSkin = 2 }},
+		{"long range", func(c *config.Config) { c.LongRangeWeight = 0.1 }},
+	}
+	for _, tt := range tests {
+		for _, ext := range []string{".json", ".bin"} {
+			t.Run(tt.name+ext, func(t *testing.T) {
+				cfg := testConfig()
+				tt.edit(cfg)
+				w := sim.NewWorld(cfg)
+				for range 20 {
+					w.Tick()
+				}
+
+				path := filepath.Join(t.TempDir(), "snapshot"+ext)
+				if err := sim.SaveSnapshot(path, w.Snapshot()); err != nil {
+					t.Fatalf("save: %v", err)
+				}
+				s, err := sim.LoadSnapshot(path)
+				if err != nil {
+					t.Fatalf("load: %v", err)
+				}
+				restored, err := sim.Restore(s)
+				if err != nil {
+					t.Fatalf("restore: %v", err)
+				}
+				if got, want := restored.TickStats().Ticks, w.TickStats().Ticks; got != want {
+					t.Fatalf("restored tick %d, want %d", got, want)
+				}
+
+				for range 30 {
+					w.Tick()
+					restored.Tick()
+				}
+				sa, sb := state(w), state(restored)
+				for i := range sa {
+					if sa[i] != sb[i] {
+						t.Fatalf("restored world diverged at value %d: %v != %v", i, sb[i], sa[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestReadSnapshotRejectsCorruptInput(t *testing.T) {
+	var buf bytes.Buffer
+	if err := sim.NewWorld(testConfig()).Snapshot().WriteBinary(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data := buf.Bytes()
+	for _, n := range []int{0, 8, 20, len(data) / 2, len(data) - 1} {
+		if _, err := sim.ReadSnapshot(bytes.NewReader(data[:n])); err == nil {
+			t.Errorf("expected an error for a snapshot truncated to %d bytes", n)
+		}
+	}
+	if _, err := sim.ReadSnapshot(bytes.NewReader(data)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	savePath := filepath.Join(dir, "saved.bin")
+
+	// a snapshot from another run to load in the middle of the recording
+	other := sim.NewWorld(testConfig())
+	for range 3 {
+		other.Tick()
+	}
+	var loaded bytes.Buffer
+	if err := other.Snapshot().WriteBinary(&loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	w := sim.NewWorld(testConfig())
+	var rec bytes.Buffer
+	if err := w.Record(&rec, 5); err != nil {
+		t.Fatal(err)
+	}
+	// loading moves the tick counter back, so count the steps here
+	for step := range 40 {
+		switch step {
+		case 10:
+			w.Send(sim.Input{Kind: sim.InputSaveSnapshot, Path: savePath})
+		case 20:
+			w.Send(sim.Input{Kind: sim.InputLoadSnapshot, Snapshot: loaded.Bytes()})
+		}
+		w.Tick()
+	}
+	if err := w.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(savePath); err != nil {
+		t.Fatalf("save input not applied: %v", err)
+	}
+	if err := os.Remove(savePath); err != nil {
+		t.Fatal(err)
+	}
+	end := w.TickStats().Ticks
+
+	res, err := sim.Replay(bytes.NewReader(rec.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Diverged || res.Ticks != end {
+		t.Fatalf("faithful replay: %+v, want a match up to tick %d", res, end)
+	}
+	if _, err = os.Stat(savePath); !os.IsNotExist(err) {
+		t.Fatalf("replay wrote a snapshot file: %v", err)
+	}
+
+	// without the load input the replay stays at tick 20 and misses the next checkpoint,
+	// taken at tick 5 of the loaded run
+	lines := strings.SplitAfter(rec.String(), "\n")
+	var edited strings.Builder
+	var loadTick int64
+	for _, line := range lines {
+		if strings.Contains(line, sim.InputLoadSnapshot) {
+			var l struct{ Tick int64 }
+			if err = json.Unmarshal([]byte(line), &l); err != nil {
+				t.Fatal(err)
+			}
+			loadTick = l.Tick
+			continue
+		}
+		edited.WriteString(line)
+	}
+	if loadTick != 20 {
+		t.Fatalf("load input recorded at tick %d, want 20", loadTick)
+	}
+	res, err = sim.Replay(strings.NewReader(edited.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Diverged || res.LastMatch != 20 || res.DivergedTick != 5 {
+		t.Fatalf("replay without the load input: %+v, want divergence at tick 5 after a match at 20", res)
+	}
+}
+
+func TestReplayWithAutoTuneAndLongRange(t *testing.T) {
+	cfg := testConfig()
+	cfg.QuadtreeAutoTune = true
+	w := sim.NewWorld(cfg)
+	if !w.AutoTuning() {
+		t.Fatal("quadtree_auto_tune alone is not tuning")
+	}
+
+	// the tree shape follows the wall clock, so it must not feed the long-range force
+	cfg.LongRangeWeight = 0.1
+	w = sim.NewWorld(cfg)
+	if w.AutoTuning() {
+		t.Fatal("quadtree_auto_tune is tuning the tree the long-range force is approximated from")
+	}
+	var rec bytes.Buffer
+	if err := w.Record(&rec, 10); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 * sim.TuneWindow {
+		w.Tick()
+	}
+	if err := w.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := sim.Replay(bytes.NewReader(rec.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end := w.TickStats().Ticks; res.Diverged || res.Ticks != end {
+		t.Fatalf("replay: %+v, want a match up to tick %d", res, end)
+	}
+}
+
+func TestRecordTrajectory(t *testing.T) {
+	cfg := testConfig()
+	// reordering moves boids between slots; the trajectory keeps them in ID order
+	cfg.ReorderTicks = 7
+	w := sim.NewWorld(cfg)
+	var buf bytes.Buffer
+	if err := w.RecordTrajectory(&buf); err != nil {
+		t.Fatal(err)
+	}
+	byID := map[int64][4]float64{}
+	for range 30 {
+		w.Tick()
+		if w.TickStats().Ticks == 20 {
+			f := w.Flock()
+			for i := range f.Len() {
+				byID[f.ID[i]] = [4]float64{f.X[i], f.Y[i], f.VX[i], f.VY[i]}
+			}
+		}
+	}
+	if err := w.StopTrajectory(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := trajectory.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 31 {
+		t.Fatalf("%d frames, want 31", r.Len())
+	}
+	f, err := r.FrameAt(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, id := range r.Header().IDs {
+		want := byID[id]
+		got := [4]float64{f.X[j], f.Y[j], f.VX[j], f.VY[j]}
+		for k := range want {
+			if math.Abs(got[k]-want[k]) > trajectory.DefaultPosQuantum {
+				t.Fatalf("boid %d value %d: %v, want %v", id, k, got[k], want[k])
+			}
+		}
+	}
+}
+
+func TestRewind(t *testing.T) {
+	for _, reorder := range []int{0, 7} {
+		cfg := testConfig()
+		cfg.ReorderTicks = reorder
+		// 2 s at the default 10 ms step
+		cfg.RewindSeconds = 2
+		w := sim.NewWorld(cfg)
+		for range 300 {
+			w.Tick()
+		}
+		first, last, ok := w.HistorySpan()
+		if !ok || last != 300 || first > 100 || first < 50 {
+			t.Fatalf("reorder %d: history spans ticks %d-%d (%v), want about 100-300", reorder, first, last, ok)
+		}
+
+		// the same run stopped at the tick rewound to
+		ref := sim.NewWorld(cfg)
+		for range 170 {
+			ref.Tick()
+		}
+		past, err := w.HistoryFlock(170)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := ref.Flock()
+		for i := range f.Len() {
+			if past.ID[i] != f.ID[i] || past.Position(i) != f.Position(i) || past.Velocity(i) != f.Velocity(i) {
+				t.Fatalf("reorder %d: boid in slot %d differs from the reference at tick 170", reorder, i)
+			}
+		}
+
+		// resuming branches off and continues exactly like the reference
+		w.Send(sim.Input{Kind: sim.InputRewind, To: 170})
+		for range 20 {
+			w.Tick()
+			ref.Tick()
+		}
+		if got, want := w.StateHash(), ref.StateHash(); got != want {
+			t.Fatalf("reorder %d: rewound run diverged from the reference", reorder)
+		}
+		if _, last, _ = w.HistorySpan(); last != 190 {
+			t.Fatalf("reorder %d: history ends at tick %d after branching, want 190", reorder, last)
+		}
+	}
+}
+
+func TestMetricsMatchBruteForce(t *testing.T) {
+	for _, kind := range []string{spatial.KindQuadTree, spatial.KindHash} {
+		cfg := testConfig()
+		cfg.SpatialIndex = kind
+		w := sim.NewWorld(cfg)
+		for range 10 {
+			w.Tick()
+		}
+		m := w.Metrics()
+		// metrics describe the state the last step started from
+		before := sim.NewWorld(cfg)
+		for range 9 {
+			before.Tick()
+		}
+		f := before.Flock()
+		if m.Tick != 9 {
+			t.Fatalf("%s: metrics of tick %d, want 9", kind, m.Tick)
+		}
+
+		neighbors, nearestSum, withNearest := 0, 0.0, 0
+		for i := range f.Len() {
+			nearest := math.Inf(1)
+			for j := range f.Len() {
+				if i == j || f.Species[i] != f.Species[j] {
+					continue
+				}
+				d := vector.V(
+					math.Remainder(f.X[j]-f.X[i], float64(cfg.Width)),
+					math.Remainder(f.Y[j]-f.Y[i], float64(cfg.Height))).Len()
+				if d < cfg.ViewRadius {
+					neighbors++
+					nearest = min(nearest, d)
+				}
+			}
+			if !math.IsInf(nearest, 1) {
+				nearestSum += nearest
+				withNearest++
+			}
+		}
+		g := m.Global
+		if g.Count != f.Len() || math.Abs(g.MeanNeighbors-float64(neighbors)/float64(f.Len())) > 1e-9 {
+			t.Errorf("%s: mean neighbors %v, want %v", kind, g.MeanNeighbors, float64(neighbors)/float64(f.Len()))
+		}
+		if math.Abs(g.MeanNearest-nearestSum/float64(withNearest)) > 1e-9 {
+			t.Errorf("%s: mean nearest %v, want %v", kind, g.MeanNearest, nearestSum/float64(withNearest))
+		}
+		total := 0
+		for _, s := range m.Species {
+			total += s.Count
+		}
+		if total != g.Count || g.Flocks == 0 {
+			t.Errorf("%s: species counts sum to %d of %d, %d flocks", kind, total, g.Count, g.Flocks)
+		}
+	}
+}
+
+func TestFlocksAreTracked(t *testing.T) {
+	cfg := testConfig()
+	cfg.MinFlockSize = 3
+	w := sim.NewWorld(cfg)
+	var events []metrics.Event
+	var kept, seen int
+	prev := map[int64]bool{}
+	for range 200 {
+		w.Tick()
+		events = append(events, w.DrainFlockEvents()...)
+		m := w.Metrics()
+
+		members := 0
+		current := map[int64]bool{}
+		for _, f := range m.Flocks {
+			if f.Size < 3 {
+				t.Fatalf("flock %d of %d boids", f.ID, f.Size)
+			}
+			members += f.Size
+			current[f.ID] = true
+			if prev[f.ID] {
+				kept++
+			}
+			seen++
+		}
+		labeled := 0
+		for _, id := range m.Labels {
+			if id == 0 {
+				continue
+			}
+			labeled++
+			if !current[id] {
+				t.Fatalf("tick %d: boid labeled with unknown flock %d", m.Tick, id)
+			}
+		}
+		if members != labeled {
+			t.Fatalf("tick %d: flocks hold %d boids, %d labeled", m.Tick, members, labeled)
+		}
+		prev = current
+	}
+	if len(events) == 0 {
+		t.Fatal("no flock events")
+	}
+	// flocks change slowly, so most keep their identity from one tick to the next
+	if kept < seen*9/10 {
+		t.Errorf("only %d of %d flocks kept their ID from the previous tick", kept, seen)
+	}
+}
+
+func TestMeasurerMatchesWorld(t *testing.T) {
+	cfg := testConfig()
+	cfg.MinFlockSize = 3
+	cfg.ReorderTicks = 7
+	w := sim.NewWorld(cfg)
+	m := sim.NewMeasurer(cfg)
+	for range 30 {
+		w.RLock()
+		f := w.Flock()
+		before := &sim.Flock{
+			X: slices.Clone(f.X), Y: slices.Clone(f.Y), VX: slices.Clone(f.VX), VY: slices.Clone(f.VY),
+			ID: slices.Clone(f.ID), Species: slices.Clone(f.Species),
+		}
+		w.RUnlock()
+		tick := w.TickStats().Ticks
+		w.Tick()
+
+		// flocks are followed the same way, so even their IDs and events agree
+		want := w.Metrics()
+		if got := m.Measure(tick, before); !reflect.DeepEqual(got, want) {
+			t.Fatalf("tick %d: measured %+v, world %+v", tick, got, want)
+		}
+	}
+}
+
+func TestLineOfSight(t *testing.T) {
+	// two boids of one species either side of a wall, close enough to see each other without it
+	cfg := testConfig()
+	cfg.BoidsCount = 2
+	cfg.ViewRadius = 10
+	cfg.Obstacles = []config.Obstacle{{X: 99, Y: 50, Width: 2, Height: 50}}
+	for _, mode := range []string{config.NeighborModeMetric, config.NeighborModeTopological} {
+		for _, los := range []bool{false, true} {
+			c := *cfg
+			c.NeighborMode, c.NeighborK, c.LineOfSight = mode, 1, los
+			s := sim.NewWorld(&c).Snapshot()
+			s.Species = []uint8{0, 0}
+			s.X, s.Y = []float64{96, 104}, []float64{75, 75}
+			s.VX, s.VY = []float64{0, 0}, []float64{1, 1}
+			w, err := sim.Restore(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Tick()
+
+			want := 1.0
+			if los {
+				want = 0
+			}
+			if got := w.Metrics().Global.MeanNeighbors; got != want {
+				t.Errorf("%s mode, line_of_sight %v: boids see %v flockmates, want %v", mode, los, got, want)
+			}
+		}
+	}
+}
+
+func TestExportMetrics(t *testing.T) {
+	w := sim.NewWorld(testConfig())
+	var out bytes.Buffer
+	e, err := metrics.NewExporter(&out, metrics.FormatCSV, []string{"flocks"}, len(sim.SpeciesColors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.ExportMetrics(e, 5)
+	for range 20 {
+		w.Tick()
+	}
+	w.StopMetricsExport()
+	w.Tick()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 || lines[0] != "tick,time,flocks" {
+		t.Fatalf("exported:\n%s", out.String())
+	}
+	step := w.Step().Seconds()
+	for k, line := range lines[1:] {
+		var tick int64
+		var seconds float64
+		var flocks int
+		if _, err = fmt.Sscanf(line, "%d,%g,%d", &tick, &seconds, &flocks); err != nil {
+			t.Fatalf("row %q: %v", line, err)
+		}
+		if tick != int64(5*k) || math.Abs(seconds-float64(tick)*step) > 1e-9 {
+			t.Errorf("row %q, want tick %d at %gs", line, 5*k, float64(5*k)*step)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	w := sim.NewWorld(testConfig())
+	w.Tick()
+	before := w.Metrics()
+
+	w.SetMetricsEnabled(false)
+	for range 5 {
+		w.Tick()
+	}
+	if got := w.Metrics(); !reflect.DeepEqual(got, before) {
+		t.Errorf("metrics of tick %d changed to tick %d while disabled", before.Tick, got.Tick)
+	}
+
+	// an exporter needs them regardless
+	e, err := metrics.NewExporter(io.Discard, metrics.FormatCSV, []string{"flocks"}, len(sim.SpeciesColors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.ExportMetrics(e, 1)
+	w.Tick()
+	if got := w.Metrics().Tick; got != 6 {
+		t.Errorf("exporting: metrics of tick %d, want 6", got)
+	}
+	w.StopMetricsExport()
+
+	w.SetMetricsEnabled(true)
+	w.Tick()
+	if got := w.Metrics(); got.Tick != 7 || got.Global.MeanNeighbors == 0 {
+		t.Errorf("re-enabled: metrics of tick %d with %v neighbors, want tick 7", got.Tick, got.Global.MeanNeighbors)
+	}
+}
+
+func TestLoadLargerSnapshot(t *testing.T) {
+	cfg := testConfig()
+	cfg.BoidsCount = 600
+	big := sim.NewWorld(cfg)
+	for range 5 {
+		big.Tick()
+	}
+	var data bytes.Buffer
+	if err := big.Snapshot().WriteBinary(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	w := sim.NewWorld(testConfig())
+	w.Tick()
+	w.Send(sim.Input{Kind: sim.InputLoadSnapshot, Snapshot: data.Bytes()})
+	for range 5 {
+		w.Tick()
+	}
+	if m := w.Metrics(); m.Global.Count != 600 || len(m.Labels) != 600 {
+		t.Errorf("metrics of %d boids with %d labels after loading 600", m.Global.Count, len(m.Labels))
+	}
+	// the loaded world goes on exactly like the one it was saved from
+	for range 5 {
+		big.Tick()
+	}
+	if w.StateHash() != big.StateHash() {
+		t.Error("loaded world diverged from the original")
+	}
+}
+
+func TestTopologicalSeesKFlockmates(t *testing.T) {
+	for _, kind := range []string{spatial.KindQuadTree, spatial.KindGrid, spatial.KindHash} {
+		cfg := testConfig()
+		cfg.SpatialIndex = kind
+		cfg.NeighborMode = config.NeighborModeTopological
+		cfg.NeighborK = 5
+		w := sim.NewWorld(cfg)
+		for range 3 {
+			w.Tick()
+		}
+		// nobody sees more than k, so a mean of k means every boid sees exactly k flockmates,
+		// including those of the rarest species
+		m := w.Metrics()
+		for s, v := range m.Species {
+			if v.Count > cfg.NeighborK && v.MeanNeighbors != float64(cfg.NeighborK) {
+				t.Errorf("%s: species %d boids see %v flockmates on average, want %d", kind, s, v.MeanNeighbors, cfg.NeighborK)
+			}
+		}
+	}
+}
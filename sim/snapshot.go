@@ -0,0 +1,251 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/vector"
+)
+
+const (
+	// snapshotVersion is the version of the snapshot layout written by this code
+	snapshotVersion = 1
+	// snapshotMagic starts every binary snapshot
+	snapshotMagic = "BOIDSNAP"
+	// maxSnapshotBoids guards against allocating absurd amounts for corrupt binary snapshots
+	maxSnapshotBoids = 1 << 26
+)
+
+// Snapshot is the complete state of a world. Restoring it gives a world that continues
+// bit-identically.
+type Snapshot struct {
+	Version int
+	Tick    int64
+	RNG     []byte // state of the random source
+	Config  config.Config
+	ID      []int64
+	Species []uint8
+	X, Y    []float64
+	VX, VY  []float64
+}
+
+// snapshotJSON is the JSON layout of a snapshot; species are written as numbers rather than base64
+type snapshotJSON struct {
+	Version int           `json:"version"`
+	Tick    int64         `json:"tick"`
+	RNG     []byte        `json:"rng"`
+	Config  config.Config `json:"config"`
+	ID      []int64       `json:"id"`
+	Species []int         `json:"species"`
+	X       []float64     `json:"x"`
+	Y       []float64     `json:"y"`
+	VX      []float64     `json:"vx"`
+	VY      []float64     `json:"vy"`
+}
+
+// Snapshot captures the current state of the world
+func (w *World) Snapshot() *Snapshot {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	rng, _ := w.src.MarshalBinary() // never fails
+	f := w.flock
+	return &Snapshot{
+		Version: snapshotVersion,
+		Tick:    w.ticks,
+		RNG:     rng,
+		Config:  *w.cfg,
+		ID:      slices.Clone(f.ID),
+		Species: slices.Clone(f.Species),
+		X:       slices.Clone(f.X),
+		Y:       slices.Clone(f.Y),
+		VX:      slices.Clone(f.VX),
+		VY:      slices.Clone(f.VY),
+	}
+}
+
+// Restore creates a world from a snapshot, using the configuration stored in it
+func Restore(s *Snapshot) (*World, error) {
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	n := len(s.ID)
+	if len(s.Species) != n || len(s.X) != n || len(s.Y) != n || len(s.VX) != n || len(s.VY) != n {
+		return nil, errors.New("snapshot arrays differ in length")
+	}
+	for _, sp := range s.Species {
+		if int(sp) >= len(SpeciesColors) {
+			return nil, fmt.Errorf("unknown species %d in snapshot", sp)
+		}
+	}
+
+	if err := s.Config.Validate(); err != nil {
+		return nil, fmt.Errorf("snapshot config: %w", err)
+	}
+	cfg := s.Config
+	cfg.BoidsCount = int64(n)
+	w := &World{cfg: &cfg, ticks: s.Tick, restored: true}
+	w.src, w.rng = newRand(cfg.Seed)
+	if err := w.src.UnmarshalBinary(s.RNG); err != nil {
+		return nil, fmt.Errorf("snapshot random state: %w", err)
+	}
+
+	flock := newFlock(n)
+	for i := range n {
+		flock.add(s.ID[i], vector.V(s.X[i], s.Y[i]), vector.V(s.VX[i], s.VY[i]), s.Species[i])
+	}
+	w.init(flock)
+	return w, nil
+}
+
+// WriteJSON writes the snapshot as JSON
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	species := make([]int, len(s.Species))
+	for i, sp := range s.Species {
+		species[i] = int(sp)
+	}
+	enc := json.NewEncoder(w)
+	return enc.Encode(snapshotJSON{
+		Version: s.Version, Tick: s.Tick, RNG: s.RNG, Config: s.Config,
+		ID: s.ID, Species: species, X: s.X, Y: s.Y, VX: s.VX, VY: s.VY,
+	})
+}
+
+// WriteBinary writes the snapshot in the compact binary format: the magic string, then
+// little-endian version, tick, random state and JSON config (both length-prefixed), boid count
+// and the per-boid arrays one after another
+func (s *Snapshot) WriteBinary(w io.Writer) error {
+	cfg, err := json.Marshal(s.Config)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err = bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	fields := []any{
+		uint32(s.Version), s.Tick,
+		uint32(len(s.RNG)), s.RNG,
+		uint32(len(cfg)), cfg,
+		uint32(len(s.ID)), s.ID, s.Species, s.X, s.Y, s.VX, s.VY,
+	}
+	for _, field := range fields {
+		if err = binary.Write(bw, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot in either format
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(snapshotMagic))
+	if err == nil && string(magic) == snapshotMagic {
+		return readBinarySnapshot(br)
+	}
+
+	var js snapshotJSON
+	if err = json.NewDecoder(br).Decode(&js); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	species := make([]uint8, len(js.Species))
+	for i, sp := range js.Species {
+		if sp < 0 || sp > 255 {
+			return nil, fmt.Errorf("invalid species %d in snapshot", sp)
+		}
+		species[i] = uint8(sp)
+	}
+	return &Snapshot{
+		Version: js.Version, Tick: js.Tick, RNG: js.RNG, Config: js.Config,
+		ID: js.ID, Species: species, X: js.X, Y: js.Y, VX: js.VX, VY: js.VY,
+	}, nil
+}
+
+func readBinarySnapshot(r io.Reader) (*Snapshot, error) {
+	if _, err := io.CopyN(io.Discard, r, int64(len(snapshotMagic))); err != nil {
+		return nil, err
+	}
+	var version, rngLen, cfgLen, n uint32
+	s := &Snapshot{}
+
+	read := func(fields ...any) error {
+		for _, field := range fields {
+			if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+				return fmt.Errorf("read snapshot: %w", err)
+			}
+		}
+		return nil
+	}
+	if err := read(&version, &s.Tick, &rngLen); err != nil {
+		return nil, err
+	}
+	s.Version = int(version)
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	if rngLen > 1<<10 {
+		return nil, errors.New("corrupt snapshot random state")
+	}
+	s.RNG = make([]byte, rngLen)
+	if err := read(s.RNG, &cfgLen); err != nil {
+		return nil, err
+	}
+	if cfgLen > 1<<24 {
+		return nil, errors.New("corrupt snapshot config")
+	}
+	cfg := make([]byte, cfgLen)
+	if err := read(cfg, &n); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(cfg, &s.Config); err != nil {
+		return nil, fmt.Errorf("decode snapshot config: %w", err)
+	}
+	if n > maxSnapshotBoids {
+		return nil, fmt.Errorf("snapshot claims %d boids", n)
+	}
+
+	s.ID = make([]int64, n)
+	s.Species = make([]uint8, n)
+	s.X, s.Y = make([]float64, n), make([]float64, n)
+	s.VX, s.VY = make([]float64, n), make([]float64, n)
+	if err := read(s.ID, s.Species, s.X, s.Y, s.VX, s.VY); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveSnapshot writes a snapshot to path, as JSON if it ends in .json and in binary otherwise
+func SaveSnapshot(path string, s *Snapshot) error {
+	var buf bytes.Buffer
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = s.WriteJSON(&buf)
+	} else {
+		err = s.WriteBinary(&buf)
+	}
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644) //nolint:gosec
+}
+
+// LoadSnapshot reads a snapshot file in either format
+func LoadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSnapshot(f)
+}
@@ -0,0 +1,92 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"slices"
+
+	"github.com/OutOfStack/boids/trajectory"
+)
+
+// trajectoryRecorder writes the state after every step to a trajectory
+type trajectoryRecorder struct {
+	tw    *trajectory.Writer
+	slot  map[int64]int // boid ID -> index in the frame
+	frame trajectory.Frame
+}
+
+// RecordTrajectory starts writing the current state and then the state after every step to out.
+// Boids are stored in ID order. Recording stops by itself if a loaded snapshot changes the boids.
+func (w *World) RecordTrajectory(out io.Writer) error {
+	w.mu.RLock()
+	f := w.flock
+	ids := slices.Clone(f.ID)
+	slices.Sort(ids)
+	slot := make(map[int64]int, len(ids))
+	for i, id := range ids {
+		slot[id] = i
+	}
+	species := make([]uint8, len(ids))
+	for i := range f.Len() {
+		species[slot[f.ID[i]]] = f.Species[i]
+	}
+	h := trajectory.Header{Config: *w.cfg, IDs: ids, Species: species, Step: w.Step()}
+	w.mu.RUnlock()
+
+	tw, err := trajectory.NewWriter(out, h)
+	if err != nil {
+		return err
+	}
+	w.trajectory = &trajectoryRecorder{
+		tw: tw, slot: slot,
+		frame: trajectory.Frame{
+			X: make([]float64, len(ids)), Y: make([]float64, len(ids)),
+			VX: make([]float64, len(ids)), VY: make([]float64, len(ids)),
+		},
+	}
+	if err = w.writeTrajectoryFrame(); err != nil {
+		w.trajectory = nil
+		return err
+	}
+	return nil
+}
+
+// StopTrajectory finishes the trajectory. The world must not be ticking.
+func (w *World) StopTrajectory() error {
+	t := w.trajectory
+	if t == nil {
+		return nil
+	}
+	w.trajectory = nil
+	return t.tw.Close()
+}
+
+// writeTrajectoryFrame appends the current state to the trajectory
+func (w *World) writeTrajectoryFrame() error {
+	t := w.trajectory
+	f := w.flock
+	if f.Len() != len(t.slot) {
+		return fmt.Errorf("trajectory: %d boids, recording %d", f.Len(), len(t.slot))
+	}
+	for i := range f.Len() {
+		j, ok := t.slot[f.ID[i]]
+		if !ok {
+			return fmt.Errorf("trajectory: boid %d is not recorded", f.ID[i])
+		}
+		t.frame.X[j], t.frame.Y[j] = f.X[i], f.Y[i]
+		t.frame.VX[j], t.frame.VY[j] = f.VX[i], f.VY[i]
+	}
+	t.frame.Tick = w.ticks
+	return t.tw.WriteFrame(t.frame)
+}
+
+// traceStep writes the state after a step, ending the trajectory on errors
+func (w *World) traceStep() {
+	if err := w.writeTrajectoryFrame(); err != nil {
+		log.Printf("%v; trajectory stopped", err)
+		if err = w.StopTrajectory(); err != nil {
+			log.Printf("close trajectory: %v", err)
+		}
+	}
+}
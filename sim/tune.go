@@ -0,0 +1,170 @@
+package sim
+
+import (
+	"log"
+	"time"
+
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/spatial"
+)
+
+const (
+	// tuneWindow is the number of ticks each parameter set is measured for
+	tuneWindow = 100
+	// tuneMargin is the relative improvement a candidate must show to be adopted
+	tuneMargin = 0.05
+	// retuneWindows is how many windows the tuner waits after settling before searching again,
+	// since good values drift as flocks form and disperse
+	retuneWindows = 20
+
+	minTuneMaxObj, maxTuneMaxObj = 2, 256
+	minTuneMaxLvl, maxTuneMaxLvl = 1, 16
+)
+
+// tuneParams are the quadtree parameters being tuned
+type tuneParams struct {
+	maxObj int
+	maxLvl int
+}
+
+// quadTreeTuner adjusts the quadtree split threshold and maximum depth at run time. It measures
+// the average neighbor query and index maintenance time per tick over a window, leaving out the
+// work the parameters do not affect, then hill-climbs:
+// neighboring parameter sets are tried one window each, in an order suggested by the tree shape,
+// and adopted when they are measurably cheaper.
+type quadTreeTuner struct {
+	ticks   int
+	elapsed time.Duration
+
+	current    tuneParams
+	best       tuneParams
+	bestCost   time.Duration // average cost per tick of best, 0 before the first window
+	candidates []tuneParams  // neighbors of best still to try
+	idle       int           // windows measured since the search settled
+}
+
+// newQuadTreeTuner returns a tuner if auto-tuning is enabled, the index is a quadtree and no
+// long-range force depends on its shape
+func (w *World) newQuadTreeTuner() *quadTreeTuner {
+	cfg := w.cfg
+	if !cfg.QuadtreeAutoTune {
+		return nil
+	}
+	if w.QuadTree() == nil {
+		log.Printf("quadtree_auto_tune needs the %q spatial index, auto-tuning is disabled", spatial.KindQuadTree)
+		return nil
+	}
+	if cfg.LongRangeWeight != 0 {
+		// the long-range force is approximated from the tree, so a shape that follows the wall
+		// clock would make runs, replays and rewinds diverge
+		log.Printf("quadtree_auto_tune cannot be combined with long_range_weight, auto-tuning is disabled")
+		return nil
+	}
+	return newTuner(tuneParams{maxObj: cfg.QuadtreeMaxObj, maxLvl: cfg.QuadtreeMaxLvl})
+}
+
+// newTuner returns a tuner starting from the given parameters
+func newTuner(p tuneParams) *quadTreeTuner {
+	return &quadTreeTuner{current: p, best: p}
+}
+
+// tuneQuadTree records the cost of a tick and rebuilds the index when the tuner picks new parameters
+func (w *World) tuneQuadTree(cost time.Duration) {
+	w.mu.RLock()
+	qt := w.QuadTree()
+	w.mu.RUnlock()
+	if qt == nil {
+		return
+	}
+
+	p, changed := w.tuner.observe(cost, qt.Stats)
+	if !changed {
+		return
+	}
+	if idx, ok := w.index.(*spatial.QuadTree); ok {
+		idx.SetParams(p.maxObj, p.maxLvl)
+		w.buildIndexWithGhosts()
+	}
+}
+
+// observe records the cost of one tick and, at the end of a window, returns the parameters
+// to use next and whether they differ from the current ones; stats describes the current tree
+func (t *quadTreeTuner) observe(cost time.Duration, stats func() quadtree.Stats) (tuneParams, bool) {
+	t.ticks++
+	t.elapsed += cost
+	if t.ticks < tuneWindow {
+		return t.current, false
+	}
+	avg := t.elapsed / time.Duration(t.ticks)
+	t.ticks, t.elapsed = 0, 0
+
+	next := t.evaluate(avg, stats())
+	if next == t.current {
+		return next, false
+	}
+	t.current = next
+	return next, true
+}
+
+// evaluate scores the window that just ended and returns the parameters for the next one
+func (t *quadTreeTuner) evaluate(avg time.Duration, stats quadtree.Stats) tuneParams {
+	switch {
+	case t.bestCost == 0:
+		// baseline of the configured values
+		t.bestCost = avg
+		t.candidates = t.neighbors(stats)
+	case t.current != t.best:
+		// a trial just finished
+		if float64(avg) < float64(t.bestCost)*(1-tuneMargin) {
+			log.Printf("quadtree auto-tune: max_obj=%d max_lvl=%d (%v/tick, was max_obj=%d max_lvl=%d at %v/tick)",
+				t.current.maxObj, t.current.maxLvl, avg, t.best.maxObj, t.best.maxLvl, t.bestCost)
+			t.best, t.bestCost = t.current, avg
+			t.candidates = t.neighbors(stats)
+		} else if len(t.candidates) == 0 {
+			log.Printf("quadtree auto-tune: settled on max_obj=%d max_lvl=%d (%v/tick)", t.best.maxObj, t.best.maxLvl, t.bestCost)
+		}
+	default:
+		// settled: keep the baseline fresh and search again from time to time
+		t.bestCost = avg
+		t.idle++
+		if t.idle >= retuneWindows {
+			t.idle = 0
+			t.candidates = t.neighbors(stats)
+		}
+	}
+
+	if len(t.candidates) == 0 {
+		return t.best
+	}
+	next := t.candidates[0]
+	t.candidates = t.candidates[1:]
+	return next
+}
+
+// neighbors returns the parameter sets adjacent to best, most promising first given the tree shape
+func (t *quadTreeTuner) neighbors(stats quadtree.Stats) []tuneParams {
+	deeper := tuneParams{maxObj: t.best.maxObj, maxLvl: t.best.maxLvl + 1}
+	shallower := tuneParams{maxObj: t.best.maxObj, maxLvl: t.best.maxLvl - 1}
+	larger := tuneParams{maxObj: t.best.maxObj * 2, maxLvl: t.best.maxLvl}
+	smaller := tuneParams{maxObj: t.best.maxObj / 2, maxLvl: t.best.maxLvl}
+
+	var order []tuneParams
+	switch {
+	case stats.StuckObjects*20 > stats.Objects:
+		// many objects piled up at the maximum depth
+		order = []tuneParams{deeper, larger, smaller, shallower}
+	case stats.EmptyLeaves*2 > stats.Leaves:
+		// the tree is finer than the data
+		order = []tuneParams{shallower, larger, smaller, deeper}
+	default:
+		order = []tuneParams{larger, smaller, deeper, shallower}
+	}
+
+	result := order[:0]
+	for _, p := range order {
+		if p.maxObj >= minTuneMaxObj && p.maxObj <= maxTuneMaxObj && p.maxLvl >= minTuneMaxLvl && p.maxLvl <= maxTuneMaxLvl {
+			result = append(result, p)
+		}
+	}
+	return result
+}
@@ -0,0 +1,378 @@
+package sim
+
+import (
+	"log"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/spatial"
+	"github.com/OutOfStack/boids/vector"
+)
+
+// World is a running flock simulation: the boids, the spatial index over them and everything
+// derived from it. Ticks run on one goroutine while readers such as a renderer take the read lock.
+type World struct {
+	cfg *config.Config
+	src *rand.PCG // random source, kept to save its state in snapshots
+	rng *rand.Rand
+	mu  sync.RWMutex
+
+	flock     *Flock
+	prev      *Flock // state of the previous step, for interpolated rendering
+	next      *Flock // scratch the next state is computed into
+	ticks     int64
+	lastTick  time.Time // wall clock time of the last step
+	late      atomic.Int64
+	dropped   atomic.Int64
+	index     spatial.Index
+	neighbors *neighborList // neighbor list cache, nil when disabled
+	tuner     *quadTreeTuner
+	obstacles *quadtree.LooseQuadTree // static obstacles, nil when there are none
+
+	scratch []*visitScratch // one per compute worker, see SetParallelism
+	// what the neighbor search found for each slot during the last tick, for the metrics
+	nbCount     []int32
+	nbNearest   []float64
+	edges       []metrics.Edge
+	calc        metrics.Calculator
+	metrics     metrics.Frame   // shares its slices with calc; readers get copies
+	flockEvents []metrics.Event // not yet drained
+	export      *metricsExport  // nil when metrics are not exported
+	metricsOff  atomic.Bool     // see SetMetricsEnabled
+	measuring   bool            // the metrics of this tick are computed
+
+	inputMu  sync.Mutex
+	inputs   []Input   // queued until the next step
+	recorder *recorder // nil when not recording
+	// trajectory receives the state after every step, nil when not recording one
+	trajectory *trajectoryRecorder
+	history    *history    // recent states for rewinding, nil when disabled
+	paused     atomic.Bool // Run does not step while set
+	replaying  bool        // inputs with effects outside the world are skipped
+	restored   bool        // created from a snapshot rather than from the config
+}
+
+// minChunk is the smallest number of boids worth handing to a compute worker
+const minChunk = 512
+
+// NewWorld creates a world with randomly placed boids as described by cfg
+func NewWorld(cfg *config.Config) *World {
+	w := &World{cfg: cfg}
+	w.src, w.rng = newRand(cfg.Seed)
+
+	// initialize boids
+	flock := newFlock(int(cfg.BoidsCount))
+	for i := range cfg.BoidsCount {
+		w.spawn(flock, i)
+	}
+	w.init(flock)
+	return w
+}
+
+// init sets up everything derived from the boids
+func (w *World) init(flock *Flock) {
+	cfg := w.cfg
+	w.flock = flock
+	w.prev = w.flock.clone()
+	w.next = w.flock.buffer()
+	w.scratch = make([]*visitScratch, runtime.GOMAXPROCS(0))
+	for i := range w.scratch {
+		w.scratch[i] = &visitScratch{stamp: make([]uint32, w.flock.Len())}
+	}
+	w.nbCount = make([]int32, w.flock.Len())
+	w.nbNearest = make([]float64, w.flock.Len())
+
+	// build initial spatial index from snapshot positions
+	w.index = w.newSpatialIndex()
+	w.buildIndexWithGhosts()
+	w.neighbors = w.newNeighborList()
+	w.tuner = w.newQuadTreeTuner()
+	w.obstacles = w.newObstacleIndex()
+	w.history = w.newHistory()
+	if w.history != nil {
+		w.pushHistory()
+	}
+	if cfg.LongRangeWeight != 0 && w.longRangeTree() == nil {
+		log.Printf("long_range_weight needs the %q spatial index, long-range forces are disabled", spatial.KindQuadTree)
+	}
+}
+
+// newRand returns the random source for a seed and a generator drawing from it; 0 keeps the
+// default seed. PCG is used because its state can be saved and restored.
+func newRand(seed int64) (*rand.PCG, *rand.Rand) {
+	if seed == 0 {
+		seed = 1
+	}
+	src := rand.NewPCG(uint64(seed), 0)
+	return src, rand.New(src) //nolint:gosec
+}
+
+// SetParallelism caps the number of goroutines a step spreads the boids over, at least 1; new
+// worlds use up to GOMAXPROCS. The result does not depend on it. It must not be called while
+// a step is running.
+func (w *World) SetParallelism(n int) {
+	n = max(n, 1)
+	for len(w.scratch) < n {
+		w.scratch = append(w.scratch, &visitScratch{stamp: make([]uint32, w.flock.Len())})
+	}
+	w.scratch = w.scratch[:n]
+}
+
+// Config returns the world's configuration; it changes when a snapshot is loaded, so callers
+// racing with Tick must hold the read lock
+func (w *World) Config() *config.Config {
+	return w.cfg
+}
+
+// RLock locks the world for reading between ticks
+func (w *World) RLock() {
+	w.mu.RLock()
+}
+
+// RUnlock undoes a single RLock call
+func (w *World) RUnlock() {
+	w.mu.RUnlock()
+}
+
+// Flock returns the boids; callers must hold the read lock
+func (w *World) Flock() *Flock {
+	return w.flock
+}
+
+// Tick advances the world by one fixed step: compute next state -> swap it in -> update index
+// for next queries
+func (w *World) Tick() {
+	// inputs may replace the whole state, so they go first
+	w.applyInputs()
+
+	cfg := w.cfg
+	f, next := w.flock, w.next
+	dt := w.Step().Seconds()
+	speed := w.maxSpeed()
+	// steering forces are calibrated per reference step
+	steps := dt / referenceStep.Seconds()
+
+	w.measuring = w.export != nil || !w.metricsOff.Load()
+	// the quadtree tuner weighs what its parameters affect: neighbor queries and index upkeep
+	var indexCost time.Duration
+
+	// the spatial index already reflects the current positions, it is kept current after each swap;
+	// cached neighbor lists are refreshed from it once boids have moved too far
+	width, height := float64(cfg.Width), float64(cfg.Height)
+	if w.neighbors != nil && w.neighbors.stale(f, width, height) {
+		start := time.Now()
+		w.neighbors.rebuild(w.index, f, cfg.ViewRadius)
+		indexCost += time.Since(start)
+	}
+	w.prepareLongRange()
+
+	// compute accelerations into the next state; only this goroutine writes the flock,
+	// so it is read here without locking. Boids are independent, so the work is split
+	// into contiguous chunks across workers and the result does not depend on their number.
+	n := f.Len()
+	workers := min(len(w.scratch), max(n/minChunk, 1))
+	var wg sync.WaitGroup
+	for k := range workers {
+		lo, hi := n*k/workers, n*(k+1)/workers
+		s := w.scratch[k]
+		s.edges, s.queryTime = s.edges[:0], 0
+		wg.Go(func() {
+			for i := lo; i < hi; i++ {
+				accel := w.accelerationFor(i, s)
+				// limit velocity, relative to the top speed, and integrate over the step
+				nv := vector.Limit(f.Velocity(i).Scale(1/speed).Add(accel.Scale(steps)), -1, 1).Scale(speed)
+				np := f.Position(i).Add(nv.Scale(dt))
+				// wrap around
+				if np.X < 0 {
+					np.X += width
+				} else if np.X >= width {
+					np.X -= width
+				}
+				if np.Y < 0 {
+					np.Y += height
+				} else if np.Y >= height {
+					np.Y -= height
+				}
+				next.X[i], next.Y[i] = np.X, np.Y
+				next.VX[i], next.VY[i] = nv.X, nv.Y
+			}
+		})
+	}
+	wg.Wait()
+	for _, s := range w.scratch[:workers] {
+		indexCost += s.queryTime
+	}
+	var m metrics.Frame
+	if w.measuring {
+		m = w.computeMetrics()
+		if w.export != nil {
+			w.exportMetrics(&m)
+		}
+	}
+
+	// apply: the current state becomes the previous one and the computed one current
+	w.mu.Lock()
+	if w.measuring {
+		w.metrics = m
+		w.logFlockEvents(m.Events)
+	}
+	w.prev.swapState(f)
+	f.swapState(next)
+	w.ticks++
+	w.lastTick = time.Now()
+	w.mu.Unlock()
+
+	// bring spatial index up to date with the new positions for next frame queries
+	reorder := cfg.ReorderTicks > 0 && w.ticks%int64(cfg.ReorderTicks) == 0
+	if reorder {
+		w.reorder()
+	}
+	start := time.Now()
+	if reorder {
+		w.buildIndexWithGhosts()
+	} else {
+		w.updateIndex()
+	}
+	indexCost += time.Since(start)
+
+	if w.tuner != nil {
+		w.tuneQuadTree(indexCost)
+	}
+	if r := w.recorder; r != nil && w.ticks%r.hashEvery == 0 {
+		r.checkpoint(w.ticks, w.StateHash())
+	}
+	if w.trajectory != nil {
+		w.traceStep()
+	}
+	if w.history != nil {
+		w.pushHistory()
+	}
+}
+
+// pushHistory stores the current state in the rewind history
+func (w *World) pushHistory() {
+	src, _ := w.src.MarshalBinary() // never fails
+	w.history.push(w.ticks, src, w.flock)
+}
+
+// reorder sorts the boid slots by spatial cell so that flockmates are close in memory; the
+// caller rebuilds the index, which is keyed by slot
+func (w *World) reorder() {
+	order := w.flock.cellOrder(max(w.cfg.ViewRadius, 1))
+	w.mu.Lock()
+	w.flock.permuteState(order, w.next)
+	w.prev.permuteState(order, w.next)
+	w.flock.permuteCold(order)
+	w.mu.Unlock()
+	if w.history != nil {
+		// differences are taken slot by slot
+		w.history.keyNext()
+	}
+	if w.neighbors != nil {
+		w.neighbors.invalidate()
+	}
+}
+
+// newSpatialIndex creates the spatial index selected in config
+func (w *World) newSpatialIndex() spatial.Index {
+	cfg := w.cfg
+	bounds := quadtree.Bounds{X: 0, Y: 0, Width: float64(cfg.Width), Height: float64(cfg.Height)}
+	// bounded indexes measure distance on the torus so they see across the borders;
+	// the unbounded hash only sees across them through ghosts
+	switch cfg.SpatialIndex {
+	case spatial.KindGrid:
+		return spatial.NewGrid(bounds, cfg.ViewRadius, true)
+	case spatial.KindHash:
+		return spatial.NewHash(cfg.ViewRadius)
+	default:
+		return spatial.NewQuadTree(bounds, cfg.QuadtreeMaxObj, cfg.QuadtreeMaxLvl, true)
+	}
+}
+
+// updateIndex brings the spatial index up to date with new positions. Indexes that support it
+// move each boid in place, so the cost is proportional to the number of boids changing cells.
+func (w *World) updateIndex() {
+	// Barnes–Hut sums depend on the tree shape; building afresh makes it a function of the
+	// positions alone, so runs with long-range forces stay reproducible
+	u, ok := w.index.(spatial.Updater)
+	if !ok || w.indexNeedsGhosts() || w.longRangeTree() != nil {
+		w.buildIndexWithGhosts()
+		return
+	}
+
+	f := w.flock
+	w.mu.Lock()
+	for i := range f.Len() {
+		u.Update(int64(i), f.Position(i))
+	}
+	w.mu.Unlock()
+}
+
+// indexNeedsGhosts reports whether the configured index relies on ghost objects to see across
+// the borders; bounded indexes wrap around by themselves
+func (w *World) indexNeedsGhosts() bool {
+	return w.cfg.SpatialIndex == spatial.KindHash
+}
+
+// buildIndexWithGhosts rebuilds the index from the current positions and, if the index needs them,
+// adds ghost objects near borders to emulate toroidal space
+func (w *World) buildIndexWithGhosts() {
+	cfg := w.cfg
+	f := w.flock
+	objects := make([]*quadtree.Object, 0, f.Len())
+	ghosts := w.indexNeedsGhosts()
+	width := float64(cfg.Width)
+	height := float64(cfg.Height)
+	r := cfg.ViewRadius
+
+	for i := range f.Len() {
+		p := f.Position(i)
+		// insert original
+		objects = append(objects, &quadtree.Object{ID: int64(i), Position: p})
+		if !ghosts {
+			continue
+		}
+
+		// ghosts when within view radius of edges
+		nearLeft := p.X < r
+		nearRight := p.X > width-r
+		nearBottom := p.Y < r
+		nearTop := p.Y > height-r
+
+		if nearLeft {
+			objects = append(objects, &quadtree.Object{ID: int64(i), Position: vector.V(p.X+width, p.Y)})
+		}
+		if nearRight {
+			objects = append(objects, &quadtree.Object{ID: int64(i), Position: vector.V(p.X-width, p.Y)})
+		}
+		if nearBottom {
+			objects = append(objects, &quadtree.Object{ID: int64(i), Position: vector.V(p.X, p.Y+height)})
+		}
+		if nearTop {
+			objects = append(objects, &quadtree.Object{ID: int64(i), Position: vector.V(p.X, p.Y-height)})
+		}
+		// corners
+		if nearLeft && nearBottom {
+			objects = append(objects, &quadtree.Object{ID: int64(i), Position: vector.V(p.X+width, p.Y+height)})
+		}
+		if nearLeft && nearTop {
+			objects = append(objects, &quadtree.Object{ID: int64(i), Position: vector.V(p.X+width, p.Y-height)})
+		}
+		if nearRight && nearBottom {
+			objects = append(objects, &quadtree.Object{ID: int64(i), Position: vector.V(p.X-width, p.Y+height)})
+		}
+		if nearRight && nearTop {
+			objects = append(objects, &quadtree.Object{ID: int64(i), Position: vector.V(p.X-width, p.Y-height)})
+		}
+	}
+
+	w.mu.Lock()
+	w.index.Rebuild(objects)
+	w.mu.Unlock()
+}
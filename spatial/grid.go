@@ -0,0 +1,176 @@
+package spatial
+
+import (
+	"math"
+
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/vector"
+)
+
+// Grid is a dense uniform grid of square-ish cells covering fixed bounds.
+// Objects are stored contiguously per cell (counting sort), so Rebuild does not allocate
+// once its buffers have grown. Objects outside the bounds (e.g. ghosts) are stored in the
+// nearest edge cell. With wrap enabled, queries treat the bounds as a torus and objects
+// are expected to lie within them.
+type Grid struct {
+	bounds       quadtree.Bounds
+	cols, rows   int
+	cellW, cellH float64
+	wrap         bool
+
+	cellStart []int // objects of cell c are items[cellStart[c]:cellStart[c+1]]
+	items     []*quadtree.Object
+	cellOf    []int // scratch: cell index of each object during Rebuild
+}
+
+// NewGrid creates an empty grid whose cells are at least cellSize wide;
+// wrap makes queries treat bounds as a torus
+func NewGrid(bounds quadtree.Bounds, cellSize float64, wrap bool) *Grid {
+	cols := max(1, int(bounds.Width/cellSize))
+	rows := max(1, int(bounds.Height/cellSize))
+	return &Grid{
+		bounds:    bounds,
+		cols:      cols,
+		rows:      rows,
+		cellW:     bounds.Width / float64(cols),
+		cellH:     bounds.Height / float64(rows),
+		wrap:      wrap,
+		cellStart: make([]int, cols*rows+1),
+	}
+}
+
+// Rebuild replaces the indexed objects
+func (g *Grid) Rebuild(objects []*quadtree.Object) {
+	clear(g.cellStart)
+	g.cellOf = g.cellOf[:0]
+	for _, obj := range objects {
+		x, y := g.cellCoords(obj.Position)
+		c := g.cellIndex(x, y)
+		g.cellOf = append(g.cellOf, c)
+		g.cellStart[c+1]++
+	}
+	for c := 1; c < len(g.cellStart); c++ {
+		g.cellStart[c] += g.cellStart[c-1]
+	}
+
+	if cap(g.items) < len(objects) {
+		g.items = make([]*quadtree.Object, len(objects))
+	}
+	g.items = g.items[:len(objects)]
+	// fill cells back to front so that each cell keeps insertion order
+	for i := len(objects) - 1; i >= 0; i-- {
+		c := g.cellOf[i]
+		g.cellStart[c+1]--
+		g.items[g.cellStart[c+1]] = objects[i]
+	}
+	// cellStart[c+1] now holds the start of cell c; shift back into place
+	copy(g.cellStart, g.cellStart[1:])
+	g.cellStart[len(g.cellStart)-1] = len(objects)
+}
+
+// QueryCircleFunc calls fn for each object within radius of center until fn returns false
+func (g *Grid) QueryCircleFunc(center vector.Vec2, radius float64, fn func(*quadtree.Object) bool) {
+	x0, y0 := g.rawCoords(vector.V(center.X-radius, center.Y-radius))
+	x1, y1 := g.rawCoords(vector.V(center.X+radius, center.Y+radius))
+	if g.wrap {
+		// never visit a cell twice when the query is wider than the world
+		x1 = min(x1, x0+g.cols-1)
+		y1 = min(y1, y0+g.rows-1)
+	} else {
+		x0, y0 = max(x0, 0), max(y0, 0)
+		x1, y1 = min(x1, g.cols-1), min(y1, g.rows-1)
+	}
+
+	var width, height float64
+	if g.wrap {
+		width, height = g.bounds.Width, g.bounds.Height
+	}
+
+	r2 := radius * radius
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			c := g.cellIndex(x, y)
+			for _, obj := range g.items[g.cellStart[c]:g.cellStart[c+1]] {
+				dx := wrapAxis(obj.Position.X-center.X, width)
+				dy := wrapAxis(obj.Position.Y-center.Y, height)
+				if dx*dx+dy*dy <= r2 && !fn(obj) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// QueryKNN returns up to k objects nearest to center ordered by increasing distance, one per ID
+func (g *Grid) QueryKNN(center vector.Vec2, k int) []*quadtree.Object {
+	return g.QueryKNNFunc(center, k, nil)
+}
+
+// QueryKNNFunc is like QueryKNN but only counts objects for which keep returns true; nil keeps all
+func (g *Grid) QueryKNNFunc(center vector.Vec2, k int, keep func(*quadtree.Object) bool) []*quadtree.Object {
+	if k <= 0 {
+		return nil
+	}
+
+	var width, height float64
+	maxRing := max(g.cols, g.rows)
+	if g.wrap {
+		width, height = g.bounds.Width, g.bounds.Height
+		maxRing = max(g.cols, g.rows)/2 + 1
+	}
+
+	best := &nearest{k: k}
+	cx, cy := g.cellCoords(center)
+	ringSearch(cx, cy, maxRing, min(g.cellW, g.cellH), best, func(x, y int) {
+		if !g.wrap && (x < 0 || x >= g.cols || y < 0 || y >= g.rows) {
+			return
+		}
+		c := g.cellIndex(x, y)
+		for _, obj := range g.items[g.cellStart[c]:g.cellStart[c+1]] {
+			if keep != nil && !keep(obj) {
+				continue
+			}
+			dx := wrapAxis(obj.Position.X-center.X, width)
+			dy := wrapAxis(obj.Position.Y-center.Y, height)
+			best.offer(obj, dx*dx+dy*dy)
+		}
+	})
+
+	return best.objects()
+}
+
+// Stats reports the shape of the grid
+func (g *Grid) Stats() Stats {
+	s := Stats{Objects: len(g.items), Cells: g.cols * g.rows}
+	for c := range g.cols * g.rows {
+		s.MaxCellObjects = max(s.MaxCellObjects, g.cellStart[c+1]-g.cellStart[c])
+	}
+	return s
+}
+
+// rawCoords returns the unbounded cell coordinates of p
+func (g *Grid) rawCoords(p vector.Vec2) (int, int) {
+	return int(math.Floor((p.X - g.bounds.X) / g.cellW)), int(math.Floor((p.Y - g.bounds.Y) / g.cellH))
+}
+
+// cellCoords returns the cell coordinates p is stored in
+func (g *Grid) cellCoords(p vector.Vec2) (int, int) {
+	x, y := g.rawCoords(p)
+	if g.wrap {
+		return x, y
+	}
+	return min(max(x, 0), g.cols-1), min(max(y, 0), g.rows-1)
+}
+
+// cellIndex maps cell coordinates, wrapped onto the grid, to a flat index
+func (g *Grid) cellIndex(x, y int) int {
+	x %= g.cols
+	if x < 0 {
+		x += g.cols
+	}
+	y %= g.rows
+	if y < 0 {
+		y += g.rows
+	}
+	return y*g.cols + x
+}
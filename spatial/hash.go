@@ -0,0 +1,117 @@
+package spatial
+
+import (
+	"math"
+
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/vector"
+)
+
+// cellKey identifies a bucket of the spatial hash
+type cellKey struct {
+	x, y int
+}
+
+// Hash is an unbounded spatial hash of square cells. Only occupied cells take memory,
+// so it suits sparse or unbounded worlds. It has no notion of wrap-around; toroidal
+// worlds rely on ghost copies of objects near the borders.
+type Hash struct {
+	cellSize float64
+	cells    map[cellKey][]*quadtree.Object
+	count    int
+	// extent of occupied cells, bounds the KNN ring search
+	minX, minY, maxX, maxY int
+}
+
+// NewHash creates an empty spatial hash with the given cell size
+func NewHash(cellSize float64) *Hash {
+	return &Hash{
+		cellSize: cellSize,
+		cells:    make(map[cellKey][]*quadtree.Object),
+	}
+}
+
+// Rebuild replaces the indexed objects, reusing bucket storage from the previous build
+func (h *Hash) Rebuild(objects []*quadtree.Object) {
+	for key, bucket := range h.cells {
+		if len(bucket) == 0 {
+			// stayed empty for a whole build, drop it
+			delete(h.cells, key)
+			continue
+		}
+		h.cells[key] = bucket[:0]
+	}
+
+	h.count = len(objects)
+	h.minX, h.minY = math.MaxInt, math.MaxInt
+	h.maxX, h.maxY = math.MinInt, math.MinInt
+	for _, obj := range objects {
+		key := h.key(obj.Position)
+		h.cells[key] = append(h.cells[key], obj)
+		h.minX, h.minY = min(h.minX, key.x), min(h.minY, key.y)
+		h.maxX, h.maxY = max(h.maxX, key.x), max(h.maxY, key.y)
+	}
+}
+
+// QueryCircleFunc calls fn for each object within radius of center until fn returns false
+func (h *Hash) QueryCircleFunc(center vector.Vec2, radius float64, fn func(*quadtree.Object) bool) {
+	lo := h.key(vector.V(center.X-radius, center.Y-radius))
+	hi := h.key(vector.V(center.X+radius, center.Y+radius))
+	r2 := radius * radius
+	for y := lo.y; y <= hi.y; y++ {
+		for x := lo.x; x <= hi.x; x++ {
+			for _, obj := range h.cells[cellKey{x: x, y: y}] {
+				dx := obj.Position.X - center.X
+				dy := obj.Position.Y - center.Y
+				if dx*dx+dy*dy <= r2 && !fn(obj) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// QueryKNN returns up to k objects nearest to center ordered by increasing distance, one per ID
+func (h *Hash) QueryKNN(center vector.Vec2, k int) []*quadtree.Object {
+	return h.QueryKNNFunc(center, k, nil)
+}
+
+// QueryKNNFunc is like QueryKNN but only counts objects for which keep returns true; nil keeps all
+func (h *Hash) QueryKNNFunc(center vector.Vec2, k int, keep func(*quadtree.Object) bool) []*quadtree.Object {
+	if k <= 0 || h.count == 0 {
+		return nil
+	}
+
+	best := &nearest{k: k}
+	c := h.key(center)
+	// beyond this ring there are no occupied cells
+	maxRing := max(c.x-h.minX, h.maxX-c.x, c.y-h.minY, h.maxY-c.y)
+	ringSearch(c.x, c.y, maxRing, h.cellSize, best, func(x, y int) {
+		for _, obj := range h.cells[cellKey{x: x, y: y}] {
+			if keep != nil && !keep(obj) {
+				continue
+			}
+			dx := obj.Position.X - center.X
+			dy := obj.Position.Y - center.Y
+			best.offer(obj, dx*dx+dy*dy)
+		}
+	})
+
+	return best.objects()
+}
+
+// Stats reports the shape of the hash
+func (h *Hash) Stats() Stats {
+	s := Stats{Objects: h.count}
+	for _, bucket := range h.cells {
+		if len(bucket) > 0 {
+			s.Cells++
+			s.MaxCellObjects = max(s.MaxCellObjects, len(bucket))
+		}
+	}
+	return s
+}
+
+func (h *Hash) key(p vector.Vec2) cellKey {
+	return cellKey{x: int(math.Floor(p.X / h.cellSize)), y: int(math.Floor(p.Y / h.cellSize))}
+}
@@ -0,0 +1,102 @@
+package spatial
+
+import (
+	"math"
+
+	"github.com/OutOfStack/boids/quadtree"
+)
+
+// candidate is an object with its squared distance to the query point
+type candidate struct {
+	obj   *quadtree.Object
+	dist2 float64
+}
+
+// nearest keeps the k closest objects seen so far, sorted by increasing distance
+type nearest struct {
+	k     int
+	items []candidate
+}
+
+func (n *nearest) full() bool {
+	return len(n.items) >= n.k
+}
+
+// worst returns the squared distance of the farthest kept object
+func (n *nearest) worst() float64 {
+	return n.items[len(n.items)-1].dist2
+}
+
+// offer considers obj for the result, deduplicating by ID and keeping the closer copy
+func (n *nearest) offer(obj *quadtree.Object, dist2 float64) {
+	for i := range n.items {
+		if n.items[i].obj.ID == obj.ID {
+			if dist2 >= n.items[i].dist2 {
+				return
+			}
+			n.items = append(n.items[:i], n.items[i+1:]...)
+			break
+		}
+	}
+	if n.full() && dist2 >= n.worst() {
+		return
+	}
+
+	// insertion into the sorted slice; k is small so a linear scan is cheapest
+	pos := len(n.items)
+	for pos > 0 && n.items[pos-1].dist2 > dist2 {
+		pos--
+	}
+	n.items = append(n.items, candidate{})
+	copy(n.items[pos+1:], n.items[pos:])
+	n.items[pos] = candidate{obj: obj, dist2: dist2}
+	if len(n.items) > n.k {
+		n.items = n.items[:n.k]
+	}
+}
+
+func (n *nearest) objects() []*quadtree.Object {
+	result := make([]*quadtree.Object, len(n.items))
+	for i, c := range n.items {
+		result[i] = c.obj
+	}
+	return result
+}
+
+// ringSearch visits cells in square rings of growing radius around (cx, cy) until the
+// k nearest objects are known or maxRing is exceeded. minCell is the smaller cell side:
+// every object outside ring r is at least r*minCell away from a query point in the center cell.
+func ringSearch(cx, cy, maxRing int, minCell float64, best *nearest, visitCell func(x, y int)) {
+	for r := 0; r <= maxRing; r++ {
+		if r == 0 {
+			visitCell(cx, cy)
+		} else {
+			for x := cx - r; x <= cx+r; x++ {
+				visitCell(x, cy-r)
+				visitCell(x, cy+r)
+			}
+			for y := cy - r + 1; y <= cy+r-1; y++ {
+				visitCell(cx-r, y)
+				visitCell(cx+r, y)
+			}
+		}
+		bound := float64(r) * minCell
+		if best.full() && best.worst() <= bound*bound {
+			return
+		}
+	}
+}
+
+// wrapAxis maps an offset to its shortest equivalent on a periodic axis; period 0 disables wrapping
+func wrapAxis(d, period float64) float64 {
+	if period <= 0 {
+		return d
+	}
+	d = math.Mod(d, period)
+	if d > period/2 {
+		d -= period
+	} else if d < -period/2 {
+		d += period
+	}
+	return d
+}
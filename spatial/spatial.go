@@ -0,0 +1,86 @@
+package spatial
+
+import (
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/vector"
+)
+
+// Index is a spatial index over point objects used for neighbor queries.
+// Indexes created with wrap treat their bounds as a torus and expect objects within them;
+// otherwise wrap-around is emulated with ghost objects sharing the ID of the original.
+// Queries may run concurrently with each other, but not with Rebuild or updates.
+type Index interface {
+	// Rebuild replaces the indexed objects
+	Rebuild(objects []*quadtree.Object)
+	// QueryCircleFunc calls fn for each object within radius of center until fn returns false
+	QueryCircleFunc(center vector.Vec2, radius float64, fn func(*quadtree.Object) bool)
+	// QueryKNN returns up to k objects nearest to center ordered by increasing distance, one per ID
+	QueryKNN(center vector.Vec2, k int) []*quadtree.Object
+	// QueryKNNFunc is like QueryKNN but only counts objects for which keep returns true
+	QueryKNNFunc(center vector.Vec2, k int, keep func(*quadtree.Object) bool) []*quadtree.Object
+	// Stats reports the current shape of the index
+	Stats() Stats
+}
+
+// Stats describes how objects are spread over the cells of an index
+type Stats struct {
+	Objects        int // number of indexed objects
+	Cells          int // number of storage cells: tree nodes, grid cells or hash buckets
+	MaxCellObjects int // largest number of objects held by a single cell
+}
+
+// Index kinds
+const (
+	KindQuadTree = "quadtree"
+	KindGrid     = "grid"
+	KindHash     = "hash"
+)
+
+// Updater is implemented by indexes that can move a single object in place,
+// which is cheaper than a full Rebuild when few objects change cells
+type Updater interface {
+	Update(id int64, position vector.Vec2)
+}
+
+// QuadTree adapts quadtree.QuadTree to Index. Rebuild builds a fresh tree while
+// Update maintains the current one incrementally.
+type QuadTree struct {
+	*quadtree.QuadTree
+	bounds quadtree.Bounds
+	maxObj int
+	maxLvl int
+	wrap   bool
+}
+
+// NewQuadTree creates an empty quadtree index; wrap makes queries treat bounds as a torus
+func NewQuadTree(bounds quadtree.Bounds, maxObj, maxLvl int, wrap bool) *QuadTree {
+	idx := &QuadTree{bounds: bounds, maxObj: maxObj, maxLvl: maxLvl, wrap: wrap}
+	idx.Rebuild(nil)
+	return idx
+}
+
+// parallelBuildMin is the object count from which Rebuild builds the tree on all cores
+const parallelBuildMin = 20000
+
+// Rebuild replaces the indexed objects with a freshly bulk-loaded tree
+func (idx *QuadTree) Rebuild(objects []*quadtree.Object) {
+	var qt *quadtree.QuadTree
+	if len(objects) >= parallelBuildMin {
+		qt = quadtree.BuildParallel(idx.bounds, idx.maxObj, idx.maxLvl, objects)
+	} else {
+		qt = quadtree.Build(idx.bounds, idx.maxObj, idx.maxLvl, objects)
+	}
+	qt.SetWrap(idx.wrap)
+	idx.QuadTree = qt
+}
+
+// SetParams changes the split threshold and maximum depth used by the next Rebuild
+func (idx *QuadTree) SetParams(maxObj, maxLvl int) {
+	idx.maxObj, idx.maxLvl = maxObj, maxLvl
+}
+
+// Stats reports the shape of the tree
+func (idx *QuadTree) Stats() Stats {
+	s := idx.QuadTree.Stats()
+	return Stats{Objects: s.Objects, Cells: s.Nodes, MaxCellObjects: s.MaxNodeObjects}
+}
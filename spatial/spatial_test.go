@@ -0,0 +1,231 @@
+package spatial_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/OutOfStack/boids/quadtree"
+	"github.com/OutOfStack/boids/spatial"
+	"github.com/OutOfStack/boids/vector"
+)
+
+var worldBounds = quadtree.Bounds{X: 0, Y: 0, Width: 200, Height: 150}
+
+// bruteForce is the reference every index must agree with
+type bruteForce struct {
+	objects []*quadtree.Object
+	wrap    bool
+}
+
+func (b *bruteForce) circle(center vector.Vec2, radius float64) map[*quadtree.Object]bool {
+	res := make(map[*quadtree.Object]bool)
+	for _, obj := range b.objects {
+		if b.dist(obj.Position, center) <= radius {
+			res[obj] = true
+		}
+	}
+	return res
+}
+
+// knnDistances returns the sorted distances of the k nearest distinct IDs
+func (b *bruteForce) knnDistances(center vector.Vec2, k int) []float64 {
+	best := make(map[int64]float64)
+	for _, obj := range b.objects {
+		d := b.dist(obj.Position, center)
+		if cur, ok := best[obj.ID]; !ok || d < cur {
+			best[obj.ID] = d
+		}
+	}
+	dists := make([]float64, 0, len(best))
+	for _, d := range best {
+		dists = append(dists, d)
+	}
+	sort.Float64s(dists)
+	return dists[:min(k, len(dists))]
+}
+
+func (b *bruteForce) dist(p, center vector.Vec2) float64 {
+	dx, dy := p.X-center.X, p.Y-center.Y
+	if b.wrap {
+		dx = math.Remainder(dx, worldBounds.Width)
+		dy = math.Remainder(dy, worldBounds.Height)
+	}
+	return math.Hypot(dx, dy)
+}
+
+// randomObjects returns objects spread over the world, optionally with ghost copies near the borders
+func randomObjects(n int, seed int64, ghosts bool) []*quadtree.Object {
+	rng := rand.New(rand.NewSource(seed)) //nolint:gosec
+	objs := make([]*quadtree.Object, 0, n)
+	for i := range n {
+		p := vector.V(rng.Float64()*worldBounds.Width, rng.Float64()*worldBounds.Height)
+		objs = append(objs, &quadtree.Object{ID: int64(i), Position: p})
+		if ghosts && p.X < 10 {
+			objs = append(objs, &quadtree.Object{ID: int64(i), Position: vector.V(p.X+worldBounds.Width, p.Y)})
+		}
+	}
+	return objs
+}
+
+func indexes(wrap bool) map[string]spatial.Index {
+	idx := map[string]spatial.Index{
+		spatial.KindQuadTree: spatial.NewQuadTree(worldBounds, 4, 6, wrap),
+		spatial.KindGrid:     spatial.NewGrid(worldBounds, 7, wrap),
+	}
+	if !wrap {
+		idx[spatial.KindHash] = spatial.NewHash(7)
+	}
+	return idx
+}
+
+func TestIndexConformance(t *testing.T) {
+	for _, wrap := range []bool{false, true} {
+		for name, idx := range indexes(wrap) {
+			t.Run(name, func(t *testing.T) {
+				rng := rand.New(rand.NewSource(3)) //nolint:gosec
+				// rebuild twice to exercise buffer reuse
+				for build, seed := range []int64{1, 2} {
+					// wrapping indexes see across the borders themselves, others need ghosts
+					objs := randomObjects(400, seed, !wrap)
+					ref := &bruteForce{objects: objs, wrap: wrap}
+					idx.Rebuild(objs)
+
+					if s := idx.Stats(); s.Objects != len(objs) || s.Cells == 0 || s.MaxCellObjects == 0 {
+						t.Fatalf("build %d: unexpected stats %+v for %d objects", build, s, len(objs))
+					}
+
+					for range 50 {
+						center := vector.V(rng.Float64()*worldBounds.Width, rng.Float64()*worldBounds.Height)
+						radius := rng.Float64() * 25
+
+						want := ref.circle(center, radius)
+						got := make(map[*quadtree.Object]bool)
+						idx.QueryCircleFunc(center, radius, func(obj *quadtree.Object) bool {
+							if got[obj] {
+								t.Fatalf("object %d reported twice", obj.ID)
+							}
+							got[obj] = true
+							return true
+						})
+						if len(got) != len(want) {
+							t.Fatalf("circle %v r=%.2f: got %d objects, want %d", center, radius, len(got), len(want))
+						}
+						for obj := range want {
+							if !got[obj] {
+								t.Fatalf("circle %v r=%.2f: missing object %d", center, radius, obj.ID)
+							}
+						}
+
+						k := 1 + rng.Intn(12)
+						wantDist := ref.knnDistances(center, k)
+						res := idx.QueryKNN(center, k)
+						if len(res) != len(wantDist) {
+							t.Fatalf("knn k=%d: got %d objects, want %d", k, len(res), len(wantDist))
+						}
+						ids := make(map[int64]bool)
+						for i, obj := range res {
+							if ids[obj.ID] {
+								t.Fatalf("knn: ID %d reported twice", obj.ID)
+							}
+							ids[obj.ID] = true
+							if d := ref.dist(obj.Position, center); math.Abs(d-wantDist[i]) > 1e-9 {
+								t.Fatalf("knn %v k=%d: result %d at distance %f, want %f", center, k, i, d, wantDist[i])
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestIndexEarlyExit(t *testing.T) {
+	objs := randomObjects(200, 5, true)
+	for name, idx := range indexes(false) {
+		idx.Rebuild(objs)
+		visited := 0
+		idx.QueryCircleFunc(vector.V(100, 75), 100, func(*quadtree.Object) bool {
+			visited++
+			return visited < 4
+		})
+		if visited != 4 {
+			t.Fatalf("%s: expected traversal to stop after 4 objects, visited %d", name, visited)
+		}
+	}
+}
+
+func TestIndexKNNFunc(t *testing.T) {
+	objs := randomObjects(300, 9, false)
+	// only objects with odd IDs are wanted
+	var odd []*quadtree.Object
+	for _, obj := range objs {
+		if obj.ID%2 == 1 {
+			odd = append(odd, obj)
+		}
+	}
+	ref := &bruteForce{objects: odd}
+	keep := func(obj *quadtree.Object) bool { return obj.ID%2 == 1 }
+	rng := rand.New(rand.NewSource(4)) //nolint:gosec
+	for name, idx := range indexes(false) {
+		idx.Rebuild(objs)
+		for range 50 {
+			center := vector.V(rng.Float64()*worldBounds.Width, rng.Float64()*worldBounds.Height)
+			k := 1 + rng.Intn(12)
+			wantDist := ref.knnDistances(center, k)
+			res := idx.QueryKNNFunc(center, k, keep)
+			if len(res) != len(wantDist) {
+				t.Fatalf("%s: filtered knn k=%d: got %d objects, want %d", name, k, len(res), len(wantDist))
+			}
+			for i, obj := range res {
+				if !keep(obj) {
+					t.Fatalf("%s: filtered knn returned object %d", name, obj.ID)
+				}
+				if d := ref.dist(obj.Position, center); math.Abs(d-wantDist[i]) > 1e-9 {
+					t.Fatalf("%s: filtered knn k=%d: result %d at distance %f, want %f", name, k, i, d, wantDist[i])
+				}
+			}
+		}
+	}
+}
+
+func TestIndexEmpty(t *testing.T) {
+	for name, idx := range indexes(false) {
+		idx.Rebuild(nil)
+		if res := idx.QueryKNN(vector.V(10, 10), 3); len(res) != 0 {
+			t.Fatalf("%s: expected no KNN results on empty index, got %d", name, len(res))
+		}
+		idx.QueryCircleFunc(vector.V(10, 10), 50, func(*quadtree.Object) bool {
+			t.Fatalf("%s: unexpected object in empty index", name)
+			return false
+		})
+	}
+}
+
+// BenchmarkIndexes measures a full simulation tick's worth of work for each index:
+// one rebuild plus a view-radius query per boid, 2000 boids in an 800x600 world
+func BenchmarkIndexes(b *testing.B) {
+	bounds := quadtree.Bounds{X: 0, Y: 0, Width: 800, Height: 600}
+	rng := rand.New(rand.NewSource(1)) //nolint:gosec
+	objs := make([]*quadtree.Object, 2000)
+	for i := range objs {
+		objs[i] = &quadtree.Object{ID: int64(i), Position: vector.V(rng.Float64()*800, rng.Float64()*600)}
+	}
+	all := map[string]spatial.Index{
+		spatial.KindQuadTree: spatial.NewQuadTree(bounds, 10, 5, true),
+		spatial.KindGrid:     spatial.NewGrid(bounds, 7, true),
+		spatial.KindHash:     spatial.NewHash(7),
+	}
+	for name, idx := range all {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				idx.Rebuild(objs)
+				for _, obj := range objs {
+					idx.QueryCircleFunc(obj.Position, 7, func(*quadtree.Object) bool { return true })
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/sweep"
+)
+
+// runSweep simulates a grid or random sample of configurations headlessly, in parallel, and writes
+// a table of their metrics
+func runSweep(args []string) {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	var params []sweep.Param
+	fs.Func("param", "config field and its values, as field=a,b,c or field=min:max:steps (repeatable); "+
+		"with -samples, field=min:max is sampled uniformly", func(spec string) error {
+		p, err := sweep.ParseParam(spec)
+		params = append(params, p)
+		return err
+	})
+	samples := fs.Int("samples", 0, "number of random points to draw instead of the full grid")
+	sampleSeed := fs.Int64("sample-seed", 1, "seed for drawing the random points")
+	repeats := fs.Int("repeats", 1, "runs per point, with seeds counting up from the configured one")
+	ticks := fs.Int64("ticks", 2000, "steps per run")
+	warmup := fs.Int64("warmup", 500, "steps before metrics are averaged")
+	fields := fs.String("metrics", strings.Join(metrics.DefaultFields, ","),
+		fmt.Sprintf("comma-separated metrics to summarize, from %s; append _N for species N only",
+			strings.Join(metrics.FieldNames(), ", ")))
+	workers := fs.Int("workers", runtime.NumCPU(), "simulations to run at once")
+	out := fs.String("out", "", "file for the results table (CSV); standard output if empty")
+	_ = fs.Parse(args) // exits on error
+	if len(params) == 0 {
+		log.Fatal("sweep: give at least one -param")
+	}
+
+	plan := &sweep.Plan{
+		Base:       *config.GetConfig(),
+		Params:     params,
+		Samples:    *samples,
+		SampleSeed: *sampleSeed,
+		Repeats:    *repeats,
+		Ticks:      *ticks,
+		Warmup:     *warmup,
+		Fields:     strings.Split(*fields, ","),
+	}
+
+	// Ctrl-C stops the sweep
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	start := time.Now()
+	results, err := sweep.Run(ctx, plan, *workers, func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\r%d/%d runs", done, total)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("sweep of %d points done in %v", len(results), time.Since(start).Round(time.Millisecond))
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err = sweep.WriteCSV(w, plan, results); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,408 @@
+// Package sweep runs batches of headless simulations over ranges of configuration values and
+// summarizes the metrics of each combination, for mapping how the flock's behavior depends on
+// its parameters.
+package sweep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/metrics"
+	"github.com/OutOfStack/boids/sim"
+)
+
+// Param is a Config field, named as in config.json, and the values it takes: either a list or
+// the range [Min, Max], divided into Steps grid points
+type Param struct {
+	Field    string
+	Values   []string
+	Min, Max float64
+	Steps    int
+}
+
+// ParseParam parses a parameter given as field=a,b,c for a list of values, field=min:max:steps
+// for a grid of evenly spaced values, or field=min:max for a range that is only sampled randomly
+func ParseParam(spec string) (Param, error) {
+	field, values, ok := strings.Cut(spec, "=")
+	if !ok || field == "" || values == "" {
+		return Param{}, fmt.Errorf("parameter %q: want field=a,b,c or field=min:max[:steps]", spec)
+	}
+	p := Param{Field: strings.TrimSpace(field)}
+	if _, err := configField(reflect.ValueOf(&config.Config{}).Elem(), p.Field); err != nil {
+		return Param{}, err
+	}
+	if !strings.Contains(values, ":") {
+		for v := range strings.SplitSeq(values, ",") {
+			p.Values = append(p.Values, strings.TrimSpace(v))
+		}
+		return p, nil
+	}
+
+	parts := strings.Split(values, ":")
+	if len(parts) > 3 {
+		return Param{}, fmt.Errorf("parameter %q: want min:max[:steps]", spec)
+	}
+	var err error
+	if p.Min, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return Param{}, fmt.Errorf("parameter %q: %w", spec, err)
+	}
+	if p.Max, err = strconv.ParseFloat(parts[1], 64); err != nil {
+		return Param{}, fmt.Errorf("parameter %q: %w", spec, err)
+	}
+	if len(parts) == 3 {
+		if p.Steps, err = strconv.Atoi(parts[2]); err != nil || p.Steps < 1 {
+			return Param{}, fmt.Errorf("parameter %q: steps must be a positive integer", spec)
+		}
+	}
+	return p, nil
+}
+
+// grid returns the values the parameter takes in a full grid
+func (p Param) grid() ([]string, error) {
+	if p.Values != nil {
+		return p.Values, nil
+	}
+	if p.Steps == 0 {
+		return nil, fmt.Errorf("parameter %s: a grid needs the number of steps, min:max:steps", p.Field)
+	}
+	values := make([]string, p.Steps)
+	for k := range values {
+		v := p.Min
+		if p.Steps > 1 {
+			v += (p.Max - p.Min) * float64(k) / float64(p.Steps-1)
+		}
+		values[k] = FormatValue(v)
+	}
+	return values, nil
+}
+
+// sample draws a random value of the parameter
+func (p Param) sample(rng *rand.Rand) string {
+	if p.Values != nil {
+		return p.Values[rng.IntN(len(p.Values))]
+	}
+	return FormatValue(p.Min + (p.Max-p.Min)*rng.Float64())
+}
+
+// Plan describes a sweep
+type Plan struct {
+	// Base is the configuration the parameters are applied to
+	Base   config.Config
+	Params []Param
+	// Samples is the number of random points drawn from the parameter space, 0 for the full grid
+	Samples int
+	// SampleSeed seeds the random points
+	SampleSeed int64
+	// Repeats is how many times each point is simulated, with seeds counting up from the
+	// configured one
+	Repeats int
+	// Ticks is the length of each run; metrics are averaged over the ticks after Warmup
+	Ticks, Warmup int64
+	// Fields are the metrics summarized, named as for metrics.NewExporter
+	Fields []string
+}
+
+// Point is one combination of parameter values
+type Point struct {
+	Values []string // in the order of Plan.Params
+	Config config.Config
+}
+
+// Points returns the combinations of parameter values the plan simulates
+func (p *Plan) Points() ([]Point, error) {
+	var combos [][]string
+	if p.Samples > 0 {
+		rng := rand.New(rand.NewPCG(uint64(p.SampleSeed), 0)) //nolint:gosec
+		for range p.Samples {
+			values := make([]string, len(p.Params))
+			for k, param := range p.Params {
+				values[k] = param.sample(rng)
+			}
+			combos = append(combos, values)
+		}
+	} else {
+		// the last parameter varies fastest
+		combos = [][]string{{}}
+		for _, param := range p.Params {
+			values, err := param.grid()
+			if err != nil {
+				return nil, err
+			}
+			var next [][]string
+			for _, c := range combos {
+				for _, v := range values {
+					next = append(next, append(c[:len(c):len(c)], v))
+				}
+			}
+			combos = next
+		}
+	}
+
+	points := make([]Point, len(combos))
+	for i, values := range combos {
+		points[i] = Point{Values: values, Config: p.Base}
+		for k, param := range p.Params {
+			if err := Set(&points[i].Config, param.Field, values[k]); err != nil {
+				return nil, err
+			}
+			// report the value as set, e.g. rounded
+			values[k] = Get(&points[i].Config, param.Field)
+		}
+		if err := points[i].Config.Validate(); err != nil {
+			return nil, fmt.Errorf("point %s: %w", strings.Join(values, ","), err)
+		}
+	}
+	return points, nil
+}
+
+// Set assigns a value, given as text, to the Config field with the given JSON name. Integer
+// fields take the nearest integer.
+func Set(cfg *config.Config, field, value string) error {
+	v, err := configField(reflect.ValueOf(cfg).Elem(), field)
+	if err != nil {
+		return err
+	}
+	bad := func(err error) error {
+		return fmt.Errorf("%s=%s: %w", field, value, err)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return bad(err)
+		}
+		n := int64(math.Round(f))
+		if v.OverflowInt(n) {
+			return bad(errors.New("out of range"))
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return bad(err)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return bad(err)
+		}
+		v.SetBool(b)
+	case reflect.String:
+		v.SetString(value)
+	default:
+		return fmt.Errorf("%s cannot be swept", field)
+	}
+	return nil
+}
+
+// Get returns the value of the Config field with the given JSON name as text, empty for
+// unknown fields
+func Get(cfg *config.Config, field string) string {
+	v, err := configField(reflect.ValueOf(cfg).Elem(), field)
+	if err != nil {
+		return ""
+	}
+	if v.Kind() == reflect.Float64 {
+		return FormatValue(v.Float())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Numeric reports whether the Config field with the given JSON name holds a number
+func Numeric(field string) bool {
+	v, err := configField(reflect.ValueOf(&config.Config{}).Elem(), field)
+	if err != nil {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// configField finds the field of a Config value by its JSON name
+func configField(cfg reflect.Value, name string) (reflect.Value, error) {
+	t := cfg.Type()
+	for i := range t.NumField() {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if tag == name {
+			return cfg.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown config field %q", name)
+}
+
+// Result summarizes the runs of one point. Final holds the metrics at the end of the runs and
+// Mean their average over the ticks after the warmup, both averaged over the repeats; SD is the
+// standard deviation of the time averages across the repeats. All are in the order of
+// Plan.Fields.
+type Result struct {
+	Point
+	Runs            int
+	Final, Mean, SD []float64
+}
+
+// run is the outcome of one simulation
+type run struct {
+	point, repeat int
+	final, mean   []float64
+}
+
+// Run simulates every point Repeats times, running up to workers simulations at once, and
+// returns the results in the order of the points. progress, if not nil, is called on the calling
+// goroutine after each simulation.
+func Run(ctx context.Context, p *Plan, workers int, progress func(done, total int)) ([]Result, error) {
+	points, err := p.Points()
+	if err != nil {
+		return nil, err
+	}
+	return RunPoints(ctx, p, points, workers, progress)
+}
+
+// RunPoints is like Run for the given points instead of those of the plan's parameters
+func RunPoints(ctx context.Context, p *Plan, points []Point, workers int, progress func(done, total int)) ([]Result, error) {
+	if p.Repeats < 1 || p.Ticks < 1 || p.Warmup < 0 || p.Warmup >= p.Ticks {
+		return nil, errors.New("sweep: need at least one repeat and more ticks than warmup")
+	}
+	var err error
+	fields := make([]func(*metrics.Frame) float64, len(p.Fields))
+	for k, name := range p.Fields {
+		if fields[k], err = metrics.Field(strings.TrimSpace(name), len(sim.SpeciesColors)); err != nil {
+			return nil, err
+		}
+	}
+	// the CPUs are shared between the simulations running at once rather than each spreading
+	// its steps over all of them
+	workers = max(workers, 1)
+	parallelism := max(runtime.GOMAXPROCS(0)/workers, 1)
+
+	type job struct{ point, repeat int }
+	jobs := make(chan job)
+	runs := make(chan run)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for j := range jobs {
+				r, ok := simulate(ctx, p, points[j.point].Config, j.repeat, parallelism, fields)
+				if !ok {
+					return
+				}
+				r.point, r.repeat = j.point, j.repeat
+				runs <- r
+			}
+		})
+	}
+	go func() {
+		defer close(jobs)
+		for i := range points {
+			for r := range p.Repeats {
+				select {
+				case jobs <- job{i, r}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(runs)
+	}()
+
+	// runs finish in any order; they are summed in the order of their repeats so that the
+	// results do not depend on the number of workers
+	byPoint := make([][]run, len(points))
+	for i := range byPoint {
+		byPoint[i] = make([]run, p.Repeats)
+	}
+	done, total := 0, len(points)*p.Repeats
+	for r := range runs {
+		byPoint[r.point][r.repeat] = r
+		done++
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(points))
+	n := float64(p.Repeats)
+	for i, point := range points {
+		res := Result{Point: point, Runs: p.Repeats, Final: make([]float64, len(fields)),
+			Mean: make([]float64, len(fields)), SD: make([]float64, len(fields))}
+		for k := range fields {
+			for _, r := range byPoint[i] {
+				res.Final[k] += r.final[k]
+				res.Mean[k] += r.mean[k]
+			}
+			res.Final[k] /= n
+			res.Mean[k] /= n
+			if p.Repeats > 1 {
+				var ss float64
+				for _, r := range byPoint[i] {
+					ss += (r.mean[k] - res.Mean[k]) * (r.mean[k] - res.Mean[k])
+				}
+				res.SD[k] = math.Sqrt(ss / (n - 1))
+			}
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// simulate runs one repeat of a point, spreading each step over up to parallelism goroutines;
+// it reports false if the context was canceled
+func simulate(ctx context.Context, p *Plan, cfg config.Config, repeat, parallelism int, fields []func(*metrics.Frame) float64) (run, bool) {
+	if cfg.Seed == 0 {
+		cfg.Seed = 1
+	}
+	cfg.Seed += int64(repeat)
+	// runs in a sweep are never rewound
+	cfg.RewindSeconds = 0
+	w := sim.NewWorld(&cfg)
+	w.SetParallelism(parallelism)
+
+	r := run{final: make([]float64, len(fields)), mean: make([]float64, len(fields))}
+	var t int64
+	measure := func(m *metrics.Frame) {
+		for k, field := range fields {
+			v := field(m)
+			if t >= p.Warmup {
+				r.mean[k] += v
+			}
+			r.final[k] = v
+		}
+	}
+	for t = range p.Ticks {
+		if ctx.Err() != nil {
+			return run{}, false
+		}
+		w.Tick()
+		w.ReadMetrics(measure)
+	}
+	for k := range fields {
+		r.mean[k] /= float64(p.Ticks - p.Warmup)
+	}
+	return r, true
+}
+
+// FormatValue formats a numeric parameter value as it is written in points, dropping the
+// rounding noise of the grid arithmetic
+func FormatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 12, 64)
+}
@@ -0,0 +1,162 @@
+package sweep_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/OutOfStack/boids/config"
+	"github.com/OutOfStack/boids/sweep"
+)
+
+func baseConfig() config.Config {
+	return config.Config{
+		Width:          120,
+		Height:         90,
+		BoidsCount:     100,
+		ViewRadius:     7,
+		AdjRate:        0.3,
+		QuadtreeMaxObj: 10,
+		QuadtreeMaxLvl: 5,
+		UpdateRateMs:   10,
+		NeighborK:      7,
+		RewindSeconds:  30,
+		Seed:           1,
+	}
+}
+
+func mustParse(t *testing.T, specs ...string) []sweep.Param {
+	t.Helper()
+	params := make([]sweep.Param, len(specs))
+	for i, spec := range specs {
+		var err error
+		if params[i], err = sweep.ParseParam(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return params
+}
+
+func TestPoints(t *testing.T) {
+	plan := sweep.Plan{
+		Base:   baseConfig(),
+		Params: mustParse(t, "view_radius=4:8:3", "boids_count=50.4,80", "neighbor_mode=metric,topological"),
+	}
+	points, err := plan.Points()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 12 {
+		t.Fatalf("%d points, want 12", len(points))
+	}
+	p := points[len(points)-1]
+	if !reflect.DeepEqual(p.Values, []string{"8", "80", "topological"}) {
+		t.Errorf("last point %v", p.Values)
+	}
+	if p.Config.ViewRadius != 8 || p.Config.BoidsCount != 80 || p.Config.NeighborMode != "topological" {
+		t.Errorf("last point config %+v", p.Config)
+	}
+	if points[0].Config.BoidsCount != 50 || points[0].Values[1] != "50" || points[0].Config.AdjRate != 0.3 {
+		t.Errorf("first point config %+v", points[0].Config)
+	}
+
+	// random samples stay within the ranges and are reproducible
+	plan.Params = mustParse(t, "adj_rate=0.1:0.5", "quadtree_auto_tune=true,false")
+	plan.Samples, plan.SampleSeed = 20, 3
+	points, err = plan.Points()
+	if err != nil || len(points) != 20 {
+		t.Fatalf("%d samples, %v", len(points), err)
+	}
+	again, _ := plan.Points()
+	for i, p := range points {
+		if p.Config.AdjRate < 0.1 || p.Config.AdjRate > 0.5 {
+			t.Errorf("sample %d: adj_rate %v", i, p.Config.AdjRate)
+		}
+		if !reflect.DeepEqual(p, again[i]) {
+			t.Errorf("sample %d differs between calls", i)
+		}
+	}
+
+	// a grid needs steps for ranges
+	plan.Samples = 0
+	if _, err = plan.Points(); err == nil {
+		t.Error("grid over a range without steps")
+	}
+}
+
+func TestParseParamRejects(t *testing.T) {
+	for _, spec := range []string{"view_radius", "no_such_field=1", "view_radius=1:2:0", "view_radius=a:2", "adj_rate=1:2:3:4"} {
+		if _, err := sweep.ParseParam(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+	var cfg config.Config
+	if err := sweep.Set(&cfg, "obstacles", "1"); err == nil {
+		t.Error("obstacles set from a number")
+	}
+	if err := sweep.Set(&cfg, "width", "1e12"); err == nil {
+		t.Error("width overflow accepted")
+	}
+}
+
+func TestRun(t *testing.T) {
+	plan := sweep.Plan{
+		Base:    baseConfig(),
+		Params:  mustParse(t, "adj_rate=0.1,0.4"),
+		Repeats: 3,
+		Ticks:   40,
+		Warmup:  10,
+		// spaced as in -metrics "polarization, flocks"
+		Fields: []string{"polarization", " flocks", "count_1 "},
+	}
+	calls := 0
+	results, err := sweep.Run(context.Background(), &plan, 4, func(done, total int) {
+		calls++
+		if done != calls || total != 6 {
+			t.Errorf("progress %d/%d at call %d", done, total, calls)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || calls != 6 {
+		t.Fatalf("%d results, %d progress calls", len(results), calls)
+	}
+	for _, r := range results {
+		if r.Runs != 3 || r.Final[0] <= 0 || r.Final[0] > 1 || r.Mean[0] <= 0 || r.SD[0] <= 0 {
+			t.Errorf("result %+v", r)
+		}
+		// boids with an ID divisible by 7 are species 1
+		if r.Final[2] != 15 || r.SD[2] != 0 {
+			t.Errorf("species 1 count %v, sd %v", r.Final[2], r.SD[2])
+		}
+	}
+
+	// results do not depend on how many simulations run at once
+	serial, err := sweep.Run(context.Background(), &plan, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, serial) {
+		t.Error("results differ with one worker")
+	}
+
+	var table bytes.Buffer
+	if err = sweep.WriteCSV(&table, &plan, results); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 3 || lines[0] != "adj_rate,runs,final_polarization,final_flocks,final_count_1,"+
+		"mean_polarization,mean_flocks,mean_count_1,sd_polarization,sd_flocks,sd_count_1" ||
+		!strings.HasPrefix(lines[1], "0.1,3,") {
+		t.Errorf("table:\n%s", table.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = sweep.Run(ctx, &plan, 2, nil); err == nil {
+		t.Error("canceled sweep succeeded")
+	}
+}
@@ -0,0 +1,42 @@
+package sweep
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteCSV writes the results as a table with one row per point: the parameter values, the
+// number of runs, then final_<metric>, mean_<metric> and sd_<metric> for each metric
+func WriteCSV(w io.Writer, p *Plan, results []Result) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, 0, len(p.Params)+1+3*len(p.Fields))
+	for _, param := range p.Params {
+		header = append(header, param.Field)
+	}
+	header = append(header, "runs")
+	for _, prefix := range []string{"final_", "mean_", "sd_"} {
+		for _, f := range p.Fields {
+			header = append(header, prefix+strings.TrimSpace(f))
+		}
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		row := append(make([]string, 0, len(header)), r.Values...)
+		row = append(row, strconv.Itoa(r.Runs))
+		for _, values := range [][]float64{r.Final, r.Mean, r.SD} {
+			for _, v := range values {
+				row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
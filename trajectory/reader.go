@@ -0,0 +1,264 @@
+package trajectory
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Reader gives random access to the frames of a trajectory file. It is not safe for concurrent use.
+type Reader struct {
+	r      io.ReaderAt
+	closer io.Closer // set by Open
+	h      Header
+	chunks []chunkEntry
+	starts []int // index of the first frame of each chunk, plus the total
+
+	// the last decoded chunk; playback mostly moves within one
+	cached int
+	frames []Frame
+}
+
+// Open opens a trajectory file; Close releases it
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	r, err := NewReader(f, info.Size())
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r.closer = f
+	return r, nil
+}
+
+// NewReader reads the header and the seek index of a trajectory of the given size
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	pre := make([]byte, len(magic)+8)
+	if _, err := r.ReadAt(pre, 0); err != nil {
+		return nil, fmt.Errorf("read trajectory header: %w", err)
+	}
+	if string(pre[:len(magic)]) != magic {
+		return nil, errors.New("not a trajectory file")
+	}
+	if v := binary.LittleEndian.Uint32(pre[len(magic):]); v != Version {
+		return nil, fmt.Errorf("unsupported trajectory version %d", v)
+	}
+	hlen := int64(binary.LittleEndian.Uint32(pre[len(magic)+4:]))
+	if hlen > maxHeaderSize || int64(len(pre))+hlen > size {
+		return nil, errors.New("corrupt trajectory header")
+	}
+	data := make([]byte, hlen)
+	if _, err := r.ReadAt(data, int64(len(pre))); err != nil {
+		return nil, fmt.Errorf("read trajectory header: %w", err)
+	}
+
+	tr := &Reader{r: r, cached: -1}
+	if err := json.Unmarshal(data, &tr.h); err != nil {
+		return nil, fmt.Errorf("decode trajectory header: %w", err)
+	}
+	if len(tr.h.Species) != len(tr.h.IDs) || tr.h.PosQuantum <= 0 || tr.h.VelQuantum <= 0 {
+		return nil, errors.New("corrupt trajectory header")
+	}
+
+	start := int64(len(pre)) + hlen
+	var err error
+	if tr.chunks, err = readIndex(r, start, size); err != nil {
+		// no usable index, e.g. the writer was not closed: find the chunks one by one
+		tr.chunks = scanChunks(r, start, size)
+	}
+	tr.starts = make([]int, len(tr.chunks)+1)
+	for i, c := range tr.chunks {
+		tr.starts[i+1] = tr.starts[i] + int(c.Frames)
+	}
+	return tr, nil
+}
+
+// readIndex reads the seek index through the footer
+func readIndex(r io.ReaderAt, start, size int64) ([]chunkEntry, error) {
+	if size < start+4+int64(footerSize) {
+		return nil, errors.New("no index")
+	}
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-int64(footerSize)); err != nil {
+		return nil, err
+	}
+	if string(footer[8:]) != indexMagic {
+		return nil, errors.New("no index")
+	}
+	offset := int64(binary.LittleEndian.Uint64(footer))
+	if offset < start || offset > size-int64(footerSize)-4 {
+		return nil, errors.New("corrupt index offset")
+	}
+	data := make([]byte, size-int64(footerSize)-offset)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	n := int(binary.LittleEndian.Uint32(data))
+	const entrySize = 8 + 4 + 8
+	if len(data) != 4+n*entrySize {
+		return nil, errors.New("corrupt index")
+	}
+	chunks := make([]chunkEntry, n)
+	for i := range chunks {
+		e := data[4+i*entrySize:]
+		chunks[i] = chunkEntry{
+			FirstTick: int64(binary.LittleEndian.Uint64(e)),
+			Frames:    binary.LittleEndian.Uint32(e[8:]),
+			Offset:    binary.LittleEndian.Uint64(e[12:]),
+		}
+		if chunks[i].Offset < uint64(start) || chunks[i].Offset >= uint64(offset) ||
+			i > 0 && chunks[i].FirstTick < chunks[i-1].FirstTick+int64(chunks[i-1].Frames) {
+			return nil, errors.New("corrupt index")
+		}
+	}
+	return chunks, nil
+}
+
+// scanChunks walks the chunks from start and returns those that are complete
+func scanChunks(r io.ReaderAt, start, size int64) []chunkEntry {
+	var chunks []chunkEntry
+	hdr := make([]byte, chunkHeaderSize)
+	for offset := start; offset+chunkHeaderSize <= size; {
+		if _, err := r.ReadAt(hdr, offset); err != nil {
+			break
+		}
+		c := chunkEntry{
+			FirstTick: int64(binary.LittleEndian.Uint64(hdr)),
+			Frames:    binary.LittleEndian.Uint32(hdr[8:]),
+			Offset:    uint64(offset),
+		}
+		end := offset + chunkHeaderSize + int64(binary.LittleEndian.Uint32(hdr[12:]))
+		if c.Frames == 0 || end > size ||
+			len(chunks) > 0 && c.FirstTick < chunks[len(chunks)-1].FirstTick+int64(chunks[len(chunks)-1].Frames) {
+			// the index or a partly written chunk
+			break
+		}
+		chunks = append(chunks, c)
+		offset = end
+	}
+	return chunks
+}
+
+// Close closes the file opened by Open
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Header returns the header of the trajectory
+func (r *Reader) Header() *Header {
+	return &r.h
+}
+
+// Len returns the number of frames
+func (r *Reader) Len() int {
+	return r.starts[len(r.starts)-1]
+}
+
+// Frame returns frame i, counting from 0. The frame is shared with the reader and must not be
+// modified; it stays valid until the reader decodes another chunk.
+func (r *Reader) Frame(i int) (Frame, error) {
+	if i < 0 || i >= r.Len() {
+		return Frame{}, fmt.Errorf("frame %d out of range [0, %d)", i, r.Len())
+	}
+	c := sort.SearchInts(r.starts, i+1) - 1
+	frames, err := r.decode(c)
+	if err != nil {
+		return Frame{}, err
+	}
+	return frames[i-r.starts[c]], nil
+}
+
+// Search returns the index of the first frame at or after tick, or Len if there is none
+func (r *Reader) Search(tick int64) int {
+	c := sort.Search(len(r.chunks), func(c int) bool {
+		return r.chunks[c].FirstTick+int64(r.chunks[c].Frames) > tick
+	})
+	if c == len(r.chunks) {
+		return r.Len()
+	}
+	return r.starts[c] + int(max(tick-r.chunks[c].FirstTick, 0))
+}
+
+// FrameAt returns the frame of the given tick, with the same sharing rules as Frame
+func (r *Reader) FrameAt(tick int64) (Frame, error) {
+	i := r.Search(tick)
+	if i < r.Len() {
+		f, err := r.Frame(i)
+		if err != nil || f.Tick == tick {
+			return f, err
+		}
+	}
+	return Frame{}, fmt.Errorf("no frame of tick %d", tick)
+}
+
+// decode decompresses chunk c, or returns it from the cache
+func (r *Reader) decode(c int) ([]Frame, error) {
+	if c == r.cached {
+		return r.frames, nil
+	}
+	entry := r.chunks[c]
+	hdr := make([]byte, chunkHeaderSize)
+	if _, err := r.r.ReadAt(hdr, int64(entry.Offset)); err != nil {
+		return nil, fmt.Errorf("read trajectory chunk: %w", err)
+	}
+	size := binary.LittleEndian.Uint32(hdr[12:])
+	if size > maxChunkSize {
+		return nil, errors.New("corrupt trajectory chunk")
+	}
+	data := make([]byte, size)
+	if _, err := r.r.ReadAt(data, int64(entry.Offset)+chunkHeaderSize); err != nil {
+		return nil, fmt.Errorf("read trajectory chunk: %w", err)
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decompress trajectory chunk: %w", err)
+	}
+
+	n := len(r.h.IDs)
+	// every value takes at least a byte
+	if uint64(len(raw)) < uint64(entry.Frames)*uint64(4*n) {
+		return nil, errors.New("corrupt trajectory chunk")
+	}
+	frames := make([]Frame, entry.Frames)
+	var prev [4][]int64
+	for k := range prev {
+		prev[k] = make([]int64, n)
+	}
+	for t := range frames {
+		frames[t] = newFrame(entry.FirstTick+int64(t), n)
+		for k, vals := range frames[t].values() {
+			q := r.h.quantum(k)
+			for i := range vals {
+				d, m := binary.Varint(raw)
+				if m <= 0 {
+					return nil, errors.New("corrupt trajectory chunk")
+				}
+				raw = raw[m:]
+				prev[k][i] += d
+				vals[i] = float64(prev[k][i]) * q
+			}
+		}
+	}
+	if len(raw) != 0 {
+		return nil, errors.New("corrupt trajectory chunk")
+	}
+	r.cached, r.frames = c, frames
+	return frames, nil
+}
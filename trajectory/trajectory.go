@@ -0,0 +1,88 @@
+// Package trajectory stores the position and velocity of every boid at every tick in a compact
+// binary format.
+//
+// A file starts with the magic string, the format version and a JSON header holding the config
+// and the boids. Frames follow in chunks of up to Header.ChunkFrames consecutive ticks. Each chunk
+// is compressed with DEFLATE on its own and starts with a key frame, so it can be decoded without
+// the ones before it. Values are quantized to fixed steps and stored as zigzag varints: absolute
+// in the key frame, as differences from the previous frame otherwise. A seek index listing every
+// chunk and a fixed-size footer pointing to it end the file; files without them, for example from
+// a run that was killed, are read by scanning the chunks.
+package trajectory
+
+import (
+	"time"
+
+	"github.com/OutOfStack/boids/config"
+)
+
+const (
+	// Version is the version of the format written by this package
+	Version = 1
+	// DefaultChunkFrames is the number of frames per chunk when the header does not set it
+	DefaultChunkFrames = 64
+	// DefaultPosQuantum is the position step used when the header does not set it, in world units
+	DefaultPosQuantum = 1.0 / 256
+	// DefaultVelQuantum is the velocity step used when the header does not set it, in world units per second
+	DefaultVelQuantum = 1.0 / 256
+
+	magic      = "BOIDTRAJ"
+	indexMagic = "BOIDTIDX"
+	// chunkHeaderSize is the size of first tick, frame count and data length before each chunk
+	chunkHeaderSize = 8 + 4 + 4
+	// footerSize is the size of the index offset and magic at the very end
+	footerSize = 8 + len(indexMagic)
+	// maxHeaderSize and maxChunkSize guard against allocating absurd amounts for corrupt files
+	maxHeaderSize = 1 << 28
+	maxChunkSize  = 1 << 30
+)
+
+// Header describes the recorded run
+type Header struct {
+	Config config.Config `json:"config"`
+	// IDs and Species describe the boids; frame values are indexed the same way
+	IDs     []int64 `json:"ids"`
+	Species []uint8 `json:"species"`
+	// Step is the simulated time between two ticks
+	Step time.Duration `json:"step"`
+	// PosQuantum and VelQuantum are the steps positions and velocities are rounded to
+	PosQuantum  float64 `json:"pos_quantum"`
+	VelQuantum  float64 `json:"vel_quantum"`
+	ChunkFrames int     `json:"chunk_frames"`
+}
+
+// Frame is the state of all boids at one tick
+type Frame struct {
+	Tick   int64
+	X, Y   []float64
+	VX, VY []float64
+}
+
+// chunkEntry is the seek index entry of a chunk
+type chunkEntry struct {
+	FirstTick int64
+	Frames    uint32
+	Offset    uint64 // of the chunk header from the start of the file
+}
+
+// quantum returns the step of value array k (X, Y, VX, VY)
+func (h *Header) quantum(k int) float64 {
+	if k < 2 {
+		return h.PosQuantum
+	}
+	return h.VelQuantum
+}
+
+// values returns the value arrays of the frame in encoding order
+func (f *Frame) values() [4][]float64 {
+	return [4][]float64{f.X, f.Y, f.VX, f.VY}
+}
+
+// newFrame allocates a frame for n boids
+func newFrame(tick int64, n int) Frame {
+	return Frame{
+		Tick: tick,
+		X:    make([]float64, n), Y: make([]float64, n),
+		VX: make([]float64, n), VY: make([]float64, n),
+	}
+}
@@ -0,0 +1,211 @@
+package trajectory_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/OutOfStack/boids/trajectory"
+)
+
+const boids = 40
+
+// testFrames returns a random walk over ticks 0-99 and 150-219, crossing the borders now and then
+func testFrames() []trajectory.Frame {
+	rng := rand.New(rand.NewSource(1)) //nolint:gosec
+	var frames []trajectory.Frame
+	x, y := make([]float64, boids), make([]float64, boids)
+	for i := range boids {
+		x[i], y[i] = rng.Float64()*200, rng.Float64()*150
+	}
+	for tick := range int64(220) {
+		if tick >= 100 && tick < 150 {
+			continue
+		}
+		f := trajectory.Frame{
+			Tick: tick,
+			X:    make([]float64, boids), Y: make([]float64, boids),
+			VX: make([]float64, boids), VY: make([]float64, boids),
+		}
+		for i := range boids {
+			f.VX[i], f.VY[i] = rng.Float64()*200-100, rng.Float64()*200-100
+			x[i] = math.Mod(x[i]+f.VX[i]*0.01+200, 200)
+			y[i] = math.Mod(y[i]+f.VY[i]*0.01+150, 150)
+			f.X[i], f.Y[i] = x[i], y[i]
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func testHeader() trajectory.Header {
+	h := trajectory.Header{ChunkFrames: 16}
+	for i := range boids {
+		h.IDs = append(h.IDs, int64(i))
+		h.Species = append(h.Species, uint8(i%4))
+	}
+	return h
+}
+
+// write encodes the frames; closed selects whether the index is written
+func write(t *testing.T, frames []trajectory.Frame, closed bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := trajectory.NewWriter(&buf, testHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		if err = w.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if closed {
+		err = w.Close()
+	} else {
+		err = w.Flush()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// checkFrame compares a decoded frame with the original up to quantization
+func checkFrame(t *testing.T, got, want trajectory.Frame) {
+	t.Helper()
+	if got.Tick != want.Tick {
+		t.Fatalf("tick %d, want %d", got.Tick, want.Tick)
+	}
+	pairs := [][2][]float64{{got.X, want.X}, {got.Y, want.Y}, {got.VX, want.VX}, {got.VY, want.VY}}
+	for k, p := range pairs {
+		for i := range p[1] {
+			if math.Abs(p[0][i]-p[1][i]) > trajectory.DefaultPosQuantum/2+1e-12 {
+				t.Fatalf("tick %d array %d boid %d: %v, want %v", want.Tick, k, i, p[0][i], p[1][i])
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	frames := testFrames()
+	for _, closed := range []bool{true, false} {
+		data := write(t, frames, closed)
+		r, err := trajectory.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("closed %v: %v", closed, err)
+		}
+		if r.Len() != len(frames) {
+			t.Fatalf("closed %v: %d frames, want %d", closed, r.Len(), len(frames))
+		}
+		if h := r.Header(); len(h.IDs) != boids || h.Species[5] != 1 || h.PosQuantum != trajectory.DefaultPosQuantum {
+			t.Fatalf("header %+v", h)
+		}
+
+		// random access jumps between chunks
+		rng := rand.New(rand.NewSource(2)) //nolint:gosec
+		for range 100 {
+			i := rng.Intn(len(frames))
+			f, err := r.Frame(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFrame(t, f, frames[i])
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	data := write(t, testFrames(), true)
+	r, err := trajectory.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tick int64
+		want int
+	}{
+		{-5, 0}, {0, 0}, {17, 17}, {99, 99}, {100, 100}, {149, 100}, {150, 100}, {219, 169}, {220, 170},
+	}
+	for _, tt := range tests {
+		if got := r.Search(tt.tick); got != tt.want {
+			t.Errorf("Search(%d) = %d, want %d", tt.tick, got, tt.want)
+		}
+	}
+	if f, err := r.FrameAt(160); err != nil || f.Tick != 160 {
+		t.Errorf("FrameAt(160) = tick %d, %v", f.Tick, err)
+	}
+	if _, err = r.FrameAt(120); err == nil {
+		t.Error("FrameAt in the gap succeeded")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	frames := testFrames()
+	data := write(t, frames, false)
+	// cut into the last chunk: the complete ones are still readable
+	data = data[:len(data)-10]
+	r, err := trajectory.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100 frames in chunks of 16 then 70 more; the last chunk holds 70 % 16 = 6 frames
+	if want := len(frames) - 6; r.Len() != want {
+		t.Fatalf("%d frames, want %d", r.Len(), want)
+	}
+	f, err := r.Frame(r.Len() - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, f, frames[r.Len()-1])
+}
+
+func TestWriterRejectsBadFrames(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := trajectory.NewWriter(&buf, testHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := testFrames()
+	if err = w.WriteFrame(frames[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteFrame(frames[0]); err == nil {
+		t.Error("accepted a frame going back in time")
+	}
+	short := frames[2]
+	short.X = short.X[1:]
+	if err = w.WriteFrame(short); err == nil {
+		t.Error("accepted a frame with missing boids")
+	}
+}
+
+func TestReaderRejectsCorruptInput(t *testing.T) {
+	data := write(t, testFrames(), true)
+	// the first chunk follows magic, version, header length and header
+	chunk := 16 + int(binary.LittleEndian.Uint32(data[12:]))
+	for name, bad := range map[string][]byte{
+		"empty":  nil,
+		"magic":  append([]byte("NOTATRAJ"), data[8:]...),
+		"header": data[:chunk-1],
+	} {
+		if _, err := trajectory.NewReader(bytes.NewReader(bad), int64(len(bad))); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// a damaged chunk is reported when it is decoded
+	damaged := bytes.Clone(data)
+	for i := chunk + 20; i < chunk+80; i++ {
+		damaged[i] ^= 0x5a
+	}
+	r, err := trajectory.NewReader(bytes.NewReader(damaged), int64(len(damaged)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Frame(0); err == nil {
+		t.Error("decoded a damaged chunk")
+	}
+}
@@ -0,0 +1,169 @@
+package trajectory
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Writer streams frames to a trajectory file. It only appends, so it can write to pipes.
+type Writer struct {
+	bw     *bufio.Writer
+	offset uint64 // bytes written so far
+	h      Header
+
+	raw   []byte // uncompressed frames of the open chunk
+	zbuf  bytes.Buffer
+	zw    *flate.Writer
+	prev  [4][]int64 // quantized values of the previous frame in the chunk
+	chunk chunkEntry // open chunk, Frames == 0 when there is none
+	index []chunkEntry
+
+	lastTick int64
+	started  bool
+	err      error
+}
+
+// NewWriter writes the header and returns a writer for frames of the boids it describes.
+// Zero quanta and chunk size are replaced by the defaults.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	if len(h.Species) != len(h.IDs) {
+		return nil, errors.New("trajectory header: species and ids differ in length")
+	}
+	if h.PosQuantum <= 0 {
+		h.PosQuantum = DefaultPosQuantum
+	}
+	if h.VelQuantum <= 0 {
+		h.VelQuantum = DefaultVelQuantum
+	}
+	if h.ChunkFrames <= 0 {
+		h.ChunkFrames = DefaultChunkFrames
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, fmt.Errorf("encode trajectory header: %w", err)
+	}
+	zw, _ := flate.NewWriter(nil, flate.DefaultCompression) // valid level, never fails
+
+	tw := &Writer{bw: bufio.NewWriter(w), h: h, zw: zw}
+	for k := range tw.prev {
+		tw.prev[k] = make([]int64, len(h.IDs))
+	}
+	tw.write([]byte(magic))
+	tw.write(binary.LittleEndian.AppendUint32(nil, Version))
+	tw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	tw.write(data)
+	if tw.err != nil {
+		return nil, tw.err
+	}
+	return tw, nil
+}
+
+// Header returns the header as written, with defaults filled in
+func (w *Writer) Header() Header {
+	return w.h
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.bw.Write(p)
+	w.offset += uint64(n)
+}
+
+// WriteFrame appends a frame. Ticks must increase; a gap starts a new chunk.
+func (w *Writer) WriteFrame(f Frame) error {
+	if w.err != nil {
+		return w.err
+	}
+	n := len(w.h.IDs)
+	if len(f.X) != n || len(f.Y) != n || len(f.VX) != n || len(f.VY) != n {
+		return fmt.Errorf("trajectory frame of tick %d: want %d boids", f.Tick, n)
+	}
+	if w.started && f.Tick <= w.lastTick {
+		return fmt.Errorf("trajectory frame of tick %d after tick %d", f.Tick, w.lastTick)
+	}
+	if w.chunk.Frames > 0 && f.Tick != w.lastTick+1 {
+		w.flushChunk()
+	}
+	if w.chunk.Frames == 0 {
+		// key frame: differences from zero
+		w.chunk.FirstTick = f.Tick
+		for k := range w.prev {
+			clear(w.prev[k])
+		}
+	}
+
+	for k, vals := range f.values() {
+		q, prev := w.h.quantum(k), w.prev[k]
+		for i, v := range vals {
+			qv := int64(math.Round(v / q))
+			w.raw = binary.AppendVarint(w.raw, qv-prev[i])
+			prev[i] = qv
+		}
+	}
+	w.chunk.Frames++
+	w.lastTick, w.started = f.Tick, true
+	if int(w.chunk.Frames) == w.h.ChunkFrames {
+		w.flushChunk()
+	}
+	return w.err
+}
+
+// flushChunk compresses and writes the open chunk
+func (w *Writer) flushChunk() {
+	if w.chunk.Frames == 0 || w.err != nil {
+		return
+	}
+	w.zbuf.Reset()
+	w.zw.Reset(&w.zbuf)
+	if _, w.err = w.zw.Write(w.raw); w.err == nil {
+		w.err = w.zw.Close()
+	}
+
+	w.chunk.Offset = w.offset
+	hdr := binary.LittleEndian.AppendUint64(nil, uint64(w.chunk.FirstTick))
+	hdr = binary.LittleEndian.AppendUint32(hdr, w.chunk.Frames)
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(w.zbuf.Len()))
+	w.write(hdr)
+	w.write(w.zbuf.Bytes())
+	w.index = append(w.index, w.chunk)
+	w.chunk = chunkEntry{}
+	w.raw = w.raw[:0]
+}
+
+// Flush writes the open chunk and buffered data, so that readers see every frame written so far
+func (w *Writer) Flush() error {
+	w.flushChunk()
+	if w.err == nil {
+		w.err = w.bw.Flush()
+	}
+	return w.err
+}
+
+// Close writes the open chunk and the seek index. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.flushChunk()
+	indexOffset := w.offset
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(w.index)))
+	for _, c := range w.index {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(c.FirstTick))
+		buf = binary.LittleEndian.AppendUint32(buf, c.Frames)
+		buf = binary.LittleEndian.AppendUint64(buf, c.Offset)
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, indexOffset)
+	buf = append(buf, indexMagic...)
+	w.write(buf)
+	if w.err == nil {
+		w.err = w.bw.Flush()
+	}
+	return w.err
+}
@@ -0,0 +1,130 @@
+package vector
+
+import (
+	"fmt"
+	"math"
+)
+
+// Vec2 is a 2D vector used for positions, velocities and forces
+type Vec2 struct {
+	X, Y float64
+}
+
+// V returns a new vector with the given coordinates
+func V(x, y float64) Vec2 {
+	return Vec2{X: x, Y: y}
+}
+
+// Unit returns the unit vector at the given angle in radians
+func Unit(angle float64) Vec2 {
+	return Vec2{X: math.Cos(angle), Y: math.Sin(angle)}
+}
+
+// String returns the vector formatted as "Vec2(x, y)"
+func (v Vec2) String() string {
+	return fmt.Sprintf("Vec2(%v, %v)", v.X, v.Y)
+}
+
+// Add returns v + u
+func (v Vec2) Add(u Vec2) Vec2 {
+	return Vec2{X: v.X + u.X, Y: v.Y + u.Y}
+}
+
+// Sub returns v - u
+func (v Vec2) Sub(u Vec2) Vec2 {
+	return Vec2{X: v.X - u.X, Y: v.Y - u.Y}
+}
+
+// Scale returns v multiplied by f
+func (v Vec2) Scale(f float64) Vec2 {
+	return Vec2{X: v.X * f, Y: v.Y * f}
+}
+
+// Neg returns -v
+func (v Vec2) Neg() Vec2 {
+	return Vec2{X: -v.X, Y: -v.Y}
+}
+
+// Dot returns the dot product of v and u
+func (v Vec2) Dot(u Vec2) float64 {
+	return v.X*u.X + v.Y*u.Y
+}
+
+// Cross returns the z component of the cross product of v and u; positive when u is
+// counter-clockwise from v
+func (v Vec2) Cross(u Vec2) float64 {
+	return v.X*u.Y - v.Y*u.X
+}
+
+// Len returns the length of v
+func (v Vec2) Len() float64 {
+	return math.Hypot(v.X, v.Y)
+}
+
+// Len2 returns the squared length of v, avoiding the square root for comparisons
+func (v Vec2) Len2() float64 {
+	return v.X*v.X + v.Y*v.Y
+}
+
+// Dist returns the distance between v and u
+func (v Vec2) Dist(u Vec2) float64 {
+	return v.Sub(u).Len()
+}
+
+// Dist2 returns the squared distance between v and u
+func (v Vec2) Dist2(u Vec2) float64 {
+	return v.Sub(u).Len2()
+}
+
+// Normalize returns the unit vector in the direction of v; the zero vector stays zero
+func (v Vec2) Normalize() Vec2 {
+	l := v.Len()
+	if l == 0 {
+		return v
+	}
+	return Vec2{X: v.X / l, Y: v.Y / l}
+}
+
+// WithLen returns v scaled to length l; the zero vector stays zero
+func (v Vec2) WithLen(l float64) Vec2 {
+	return v.Normalize().Scale(l)
+}
+
+// LimitLen returns v shortened to at most length l
+func (v Vec2) LimitLen(l float64) Vec2 {
+	if v.Len2() <= l*l {
+		return v
+	}
+	return v.WithLen(l)
+}
+
+// Heading returns the angle of v in radians, in (-π, π]
+func (v Vec2) Heading() float64 {
+	return math.Atan2(v.Y, v.X)
+}
+
+// Rotate returns v rotated counter-clockwise by angle radians
+func (v Vec2) Rotate(angle float64) Vec2 {
+	sin, cos := math.Sincos(angle)
+	return Vec2{X: v.X*cos - v.Y*sin, Y: v.X*sin + v.Y*cos}
+}
+
+// AngleTo returns the signed angle in radians to rotate v onto u, in (-π, π]
+func (v Vec2) AngleTo(u Vec2) float64 {
+	return math.Atan2(v.Cross(u), v.Dot(u))
+}
+
+// Lerp returns the linear interpolation between v (t = 0) and u (t = 1)
+func (v Vec2) Lerp(u Vec2, t float64) Vec2 {
+	return Vec2{X: v.X + (u.X-v.X)*t, Y: v.Y + (u.Y-v.Y)*t}
+}
+
+// IsNaN reports whether either coordinate is NaN
+func (v Vec2) IsNaN() bool {
+	return math.IsNaN(v.X) || math.IsNaN(v.Y)
+}
+
+// IsFinite reports whether both coordinates are neither NaN nor infinite
+func (v Vec2) IsFinite() bool {
+	return !v.IsNaN() && !math.IsInf(v.X, 0) && !math.IsInf(v.Y, 0)
+}
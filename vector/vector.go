@@ -2,28 +2,26 @@ package vector
 
 import (
 	"math"
-
-	"github.com/gopxl/pixel/v2"
 )
 
 // Limit restricts vector's X and Y to range [lower, upper]
-func Limit(vector pixel.Vec, lower, upper float64) pixel.Vec {
-	return pixel.V(
+func Limit(vector Vec2, lower, upper float64) Vec2 {
+	return V(
 		math.Min(math.Max(vector.X, lower), upper),
 		math.Min(math.Max(vector.Y, lower), upper))
 }
 
 // Distance calculates the Euclidean distance between two vectors.
 // The distance is determined using the Pythagorean theorem in 2D space.
-func Distance(v1, v2 pixel.Vec) float64 {
+func Distance(v1, v2 Vec2) float64 {
 	xDist, yDist := v1.X-v2.X, v1.Y-v2.Y
 	return math.Sqrt(xDist*xDist + yDist*yDist)
 }
 
 // DivisionV divides X and Y of vector by a specified divisor.
-func DivisionV(vector pixel.Vec, d float64) pixel.Vec {
+func DivisionV(vector Vec2, d float64) Vec2 {
 	if d == 0 {
 		return vector
 	}
-	return pixel.V(vector.X/d, vector.Y/d)
+	return V(vector.X/d, vector.Y/d)
 }
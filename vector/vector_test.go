@@ -5,65 +5,64 @@ import (
 	"testing"
 
 	"github.com/OutOfStack/boids/vector"
-	"github.com/gopxl/pixel/v2"
 )
 
 func TestLimit(t *testing.T) {
 	tests := []struct {
 		name     string
-		vector   pixel.Vec
+		vector   vector.Vec2
 		lower    float64
 		upper    float64
-		expected pixel.Vec
+		expected vector.Vec2
 	}{
 		{
 			name:     "vector within bounds",
-			vector:   pixel.V(0.5, 0.5),
+			vector:   vector.V(0.5, 0.5),
 			lower:    -1.0,
 			upper:    1.0,
-			expected: pixel.V(0.5, 0.5),
+			expected: vector.V(0.5, 0.5),
 		},
 		{
 			name:     "vector exceeds upper bound",
-			vector:   pixel.V(2.0, 1.5),
+			vector:   vector.V(2.0, 1.5),
 			lower:    -1.0,
 			upper:    1.0,
-			expected: pixel.V(1.0, 1.0),
+			expected: vector.V(1.0, 1.0),
 		},
 		{
 			name:     "vector below lower bound",
-			vector:   pixel.V(-2.0, -1.5),
+			vector:   vector.V(-2.0, -1.5),
 			lower:    -1.0,
 			upper:    1.0,
-			expected: pixel.V(-1.0, -1.0),
+			expected: vector.V(-1.0, -1.0),
 		},
 		{
 			name:     "mixed bounds violation",
-			vector:   pixel.V(-2.0, 2.0),
+			vector:   vector.V(-2.0, 2.0),
 			lower:    -1.0,
 			upper:    1.0,
-			expected: pixel.V(-1.0, 1.0),
+			expected: vector.V(-1.0, 1.0),
 		},
 		{
 			name:     "at lower bound",
-			vector:   pixel.V(-1.0, -1.0),
+			vector:   vector.V(-1.0, -1.0),
 			lower:    -1.0,
 			upper:    1.0,
-			expected: pixel.V(-1.0, -1.0),
+			expected: vector.V(-1.0, -1.0),
 		},
 		{
 			name:     "at upper bound",
-			vector:   pixel.V(1.0, 1.0),
+			vector:   vector.V(1.0, 1.0),
 			lower:    -1.0,
 			upper:    1.0,
-			expected: pixel.V(1.0, 1.0),
+			expected: vector.V(1.0, 1.0),
 		},
 		{
 			name:     "zero vector",
-			vector:   pixel.V(0, 0),
+			vector:   vector.V(0, 0),
 			lower:    -1.0,
 			upper:    1.0,
-			expected: pixel.V(0, 0),
+			expected: vector.V(0, 0),
 		},
 	}
 
@@ -81,50 +80,50 @@ func TestLimit(t *testing.T) {
 func TestDistance(t *testing.T) {
 	tests := []struct {
 		name     string
-		v1       pixel.Vec
-		v2       pixel.Vec
+		v1       vector.Vec2
+		v2       vector.Vec2
 		expected float64
 	}{
 		{
 			name:     "identical vectors",
-			v1:       pixel.V(0, 0),
-			v2:       pixel.V(0, 0),
+			v1:       vector.V(0, 0),
+			v2:       vector.V(0, 0),
 			expected: 0.0,
 		},
 		{
 			name:     "horizontal distance",
-			v1:       pixel.V(0, 0),
-			v2:       pixel.V(3, 0),
+			v1:       vector.V(0, 0),
+			v2:       vector.V(3, 0),
 			expected: 3.0,
 		},
 		{
 			name:     "vertical distance",
-			v1:       pixel.V(0, 0),
-			v2:       pixel.V(0, 4),
+			v1:       vector.V(0, 0),
+			v2:       vector.V(0, 4),
 			expected: 4.0,
 		},
 		{
 			name:     "diagonal distance (3-4-5 triangle)",
-			v1:       pixel.V(0, 0),
-			v2:       pixel.V(3, 4),
+			v1:       vector.V(0, 0),
+			v2:       vector.V(3, 4),
 			expected: 5.0,
 		},
 		{
 			name:     "negative coordinates",
-			v1:       pixel.V(-1, -1),
-			v2:       pixel.V(2, 3),
+			v1:       vector.V(-1, -1),
+			v2:       vector.V(2, 3),
 			expected: 5.0,
 		},
 		{
 			name:     "symmetric distance",
-			v1:       pixel.V(1, 1),
-			v2:       pixel.V(4, 5),
+			v1:       vector.V(1, 1),
+			v2:       vector.V(4, 5),
 			expected: 5.0,
 		},
 		{
 			name:     "unit distance diagonal",
-			v1:       pixel.V(0, 0),
-			v2:       pixel.V(1, 1),
+			v1:       vector.V(0, 0),
+			v2:       vector.V(1, 1),
 			expected: math.Sqrt(2),
 		},
 	}
@@ -150,57 +149,57 @@ func TestDistance(t *testing.T) {
 func TestDivisionV(t *testing.T) {
 	tests := []struct {
 		name     string
-		vector   pixel.Vec
+		vector   vector.Vec2
 		divisor  float64
-		expected pixel.Vec
+		expected vector.Vec2
 	}{
 		{
 			name:     "divide by 1",
-			vector:   pixel.V(10, 20),
+			vector:   vector.V(10, 20),
 			divisor:  1.0,
-			expected: pixel.V(10, 20),
+			expected: vector.V(10, 20),
 		},
 		{
 			name:     "divide by 2",
-			vector:   pixel.V(10, 20),
+			vector:   vector.V(10, 20),
 			divisor:  2.0,
-			expected: pixel.V(5, 10),
+			expected: vector.V(5, 10),
 		},
 		{
 			name:     "divide by 0.5 (multiply by 2)",
-			vector:   pixel.V(10, 20),
+			vector:   vector.V(10, 20),
 			divisor:  0.5,
-			expected: pixel.V(20, 40),
+			expected: vector.V(20, 40),
 		},
 		{
 			name:     "divide by zero (returns original)",
-			vector:   pixel.V(10, 20),
+			vector:   vector.V(10, 20),
 			divisor:  0.0,
-			expected: pixel.V(10, 20),
+			expected: vector.V(10, 20),
 		},
 		{
 			name:     "divide negative vector",
-			vector:   pixel.V(-10, -20),
+			vector:   vector.V(-10, -20),
 			divisor:  2.0,
-			expected: pixel.V(-5, -10),
+			expected: vector.V(-5, -10),
 		},
 		{
 			name:     "divide by negative divisor",
-			vector:   pixel.V(10, 20),
+			vector:   vector.V(10, 20),
 			divisor:  -2.0,
-			expected: pixel.V(-5, -10),
+			expected: vector.V(-5, -10),
 		},
 		{
 			name:     "divide zero vector",
-			vector:   pixel.V(0, 0),
+			vector:   vector.V(0, 0),
 			divisor:  5.0,
-			expected: pixel.V(0, 0),
+			expected: vector.V(0, 0),
 		},
 		{
 			name:     "divide small vector",
-			vector:   pixel.V(1.5, 3.0),
+			vector:   vector.V(1.5, 3.0),
 			divisor:  3.0,
-			expected: pixel.V(0.5, 1.0),
+			expected: vector.V(0.5, 1.0),
 		},
 	}
 
@@ -214,3 +213,67 @@ func TestDivisionV(t *testing.T) {
 		})
 	}
 }
+
+func TestVec2(t *testing.T) {
+	const eps = 1e-10
+	near := func(a, b vector.Vec2) bool {
+		return math.Abs(a.X-b.X) < eps && math.Abs(a.Y-b.Y) < eps
+	}
+	v, u := vector.V(3, 4), vector.V(-1, 2)
+
+	vectors := []struct {
+		name     string
+		result   vector.Vec2
+		expected vector.Vec2
+	}{
+		{"add", v.Add(u), vector.V(2, 6)},
+		{"sub", v.Sub(u), vector.V(4, 2)},
+		{"scale", v.Scale(0.5), vector.V(1.5, 2)},
+		{"neg", v.Neg(), vector.V(-3, -4)},
+		{"normalize", v.Normalize(), vector.V(0.6, 0.8)},
+		{"normalize zero", vector.Vec2{}.Normalize(), vector.Vec2{}},
+		{"with len", v.WithLen(10), vector.V(6, 8)},
+		{"limit len above", v.LimitLen(1), vector.V(0.6, 0.8)},
+		{"limit len below", v.LimitLen(6), v},
+		{"rotate quarter", vector.V(1, 0).Rotate(math.Pi / 2), vector.V(0, 1)},
+		{"unit", vector.Unit(math.Pi), vector.V(-1, 0)},
+		{"lerp", v.Lerp(u, 0.25), vector.V(2, 3.5)},
+	}
+	for _, tt := range vectors {
+		t.Run(tt.name, func(t *testing.T) {
+			if !near(tt.result, tt.expected) {
+				t.Errorf("got %v, want %v", tt.result, tt.expected)
+			}
+		})
+	}
+
+	scalars := []struct {
+		name     string
+		result   float64
+		expected float64
+	}{
+		{"dot", v.Dot(u), 5},
+		{"cross", v.Cross(u), 10},
+		{"len", v.Len(), 5},
+		{"len2", v.Len2(), 25},
+		{"dist", v.Dist(u), math.Sqrt(20)},
+		{"dist2", v.Dist2(u), 20},
+		{"heading", vector.V(0, 2).Heading(), math.Pi / 2},
+		{"angle to counter-clockwise", vector.V(1, 0).AngleTo(vector.V(0, 3)), math.Pi / 2},
+		{"angle to clockwise", vector.V(1, 0).AngleTo(vector.V(1, -1)), -math.Pi / 4},
+	}
+	for _, tt := range scalars {
+		t.Run(tt.name, func(t *testing.T) {
+			if math.Abs(tt.result-tt.expected) > eps {
+				t.Errorf("got %v, want %v", tt.result, tt.expected)
+			}
+		})
+	}
+
+	if v.IsNaN() || !v.IsFinite() {
+		t.Error("expected a finite vector")
+	}
+	if !vector.V(math.NaN(), 0).IsNaN() || vector.V(math.Inf(1), 0).IsFinite() {
+		t.Error("expected NaN and infinite coordinates to be detected")
+	}
+}